@@ -0,0 +1,163 @@
+// Package client is a Go client for the rooms and messages API and its
+// realtime subscriptions. Request and event types are the ones the server
+// encodes, re-exported from this package.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrNotFound    = errors.New("client: not found")
+	ErrRoomClosed  = errors.New("client: room is closed")
+	ErrForbidden   = errors.New("client: forbidden")
+	ErrBadRequest  = errors.New("client: bad request")
+	ErrUnavailable = errors.New("client: server error")
+)
+
+// Error is returned for responses with a non-2xx status. It matches the
+// Err* values with errors.Is.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("client: %d %s", e.StatusCode, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRoomClosed:
+		return e.StatusCode == http.StatusConflict
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnavailable:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+
+	return false
+}
+
+type Client struct {
+	baseURL       *url.URL
+	http          *http.Client
+	dialer        *websocket.Dialer
+	participantID string
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) {
+		cl.http = c
+	}
+}
+
+func WithDialer(d *websocket.Dialer) Option {
+	return func(cl *Client) {
+		cl.dialer = d
+	}
+}
+
+// WithParticipantID identifies this client in the server logs.
+func WithParticipantID(id string) Option {
+	return func(cl *Client) {
+		cl.participantID = id
+	}
+}
+
+// WithReconnectBackoff sets the delay before the first reconnect attempt of
+// a subscription, doubled on every failure up to max.
+func WithReconnectBackoff(min, max time.Duration) Option {
+	return func(cl *Client) {
+		cl.minBackoff = min
+		cl.maxBackoff = max
+	}
+}
+
+// New returns a client for the server at baseURL, e.g. http://localhost:8080.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("client: unsupported scheme %q", u.Scheme)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		http:       http.DefaultClient,
+		dialer:     websocket.DefaultDialer,
+		minBackoff: 500 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL.String()+path, body)
+	if err != nil {
+		return err
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.participantID != "" {
+		req.Header.Set("X-Participant-Id", c.participantID)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// CreateRoom creates a room and returns its ID.
+func (c *Client) CreateRoom(ctx context.Context, theme string) (string, error) {
+	var out createRoomResult
+	if err := c.do(ctx, http.MethodPost, "/api/rooms", nil, createRoomInput{Theme: theme}, &out); err != nil {
+		return "", err
+	}
+
+	return out.ID, nil
+}
+
+// ListRooms returns every room.
+func (c *Client) ListRooms(ctx context.Context) ([]Room, error) {
+	var out struct {
+		Rooms []Room `json:"rooms"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/rooms", nil, nil, &out); err != nil {
+		return nil, err
+	}
+
+	return out.Rooms, nil
+}
+
+// GetRoom returns a room and how many participants are subscribed to it.
+func (c *Client) GetRoom(ctx context.Context, roomID string) (*RoomInfo, error) {
+	var out RoomInfo
+	if err := c.do(ctx, http.MethodGet, "/api/rooms/"+url.PathEscape(roomID)+"/info", nil, nil, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// ListMessages returns the visible messages of a room.
+func (c *Client) ListMessages(ctx context.Context, roomID string) (*RoomMessages, error) {
+	var out RoomMessages
+	if err := c.do(ctx, http.MethodGet, "/api/rooms/"+url.PathEscape(roomID)+"/messages", nil, nil, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// CreateMessage asks a question in a room and returns its ID.
+func (c *Client) CreateMessage(ctx context.Context, roomID, message string) (string, error) {
+	var out createMessageResult
+	if err := c.do(ctx, http.MethodPost, "/api/rooms/"+url.PathEscape(roomID)+"/messages", nil, createMessageInput{Message: message}, &out); err != nil {
+		return "", err
+	}
+
+	return out.ID, nil
+}
+
+// React adds a reaction to a message.
+func (c *Client) React(ctx context.Context, messageID string) (*ReactionResult, error) {
+	return c.reaction(ctx, http.MethodPatch, messageID)
+}
+
+// Unreact removes a reaction from a message.
+func (c *Client) Unreact(ctx context.Context, messageID string) (*ReactionResult, error) {
+	return c.reaction(ctx, http.MethodDelete, messageID)
+}
+
+func (c *Client) reaction(ctx context.Context, method, messageID string) (*ReactionResult, error) {
+	var out ReactionResult
+	if err := c.do(ctx, method, "/api/rooms/"+url.PathEscape(messageID)+"/react", nil, nil, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+// Answer marks a message as answered.
+func (c *Client) Answer(ctx context.Context, messageID string) (*AnswerResult, error) {
+	var out AnswerResult
+	if err := c.do(ctx, http.MethodPatch, "/api/rooms/"+url.PathEscape(messageID)+"/answer", nil, nil, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
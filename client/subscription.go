@@ -0,0 +1,347 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+)
+
+// Event is a frame received from a room subscription. Value holds the
+// concrete type for Kind, e.g. MessageCreated for KindMessageCreated, or a
+// RawPayload for kinds this package does not know.
+type Event struct {
+	Envelope
+	// Resumed is set on events rebuilt from a snapshot of the room after a
+	// reconnect, for changes that happened while disconnected. They have
+	// no ID.
+	Resumed bool
+}
+
+// Decode parses a frame sent by the server.
+func Decode(data []byte) (Envelope, error) {
+	return entity.Events.Decode(data)
+}
+
+// Subscription receives the events of a room. It reconnects with backoff
+// when the connection drops and, once reconnected, emits the events missed
+// in between, so Events stays consistent with the room.
+type Subscription struct {
+	c      *Client
+	roomID string
+
+	events chan Event
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+	err  error
+
+	// messages is the last known state of each message, used to work out
+	// what was missed while disconnected.
+	messages map[string]Message
+}
+
+// Subscribe connects to the room and returns once the first connection is
+// established. The subscription ends when ctx is done or Close is called.
+func (c *Client) Subscribe(ctx context.Context, roomID string) (*Subscription, error) {
+	conn, err := c.dial(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The snapshot is taken once connected, so changes made in between are
+	// either in it or received as events; events it already reflects are
+	// dropped by apply.
+	snapshot, err := c.ListMessages(ctx, roomID)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Subscription{
+		c:        c,
+		roomID:   roomID,
+		events:   make(chan Event, 64),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		conn:     conn,
+		messages: make(map[string]Message),
+	}
+
+	for _, m := range snapshot.Messages {
+		s.messages[m.ID] = m
+	}
+
+	go s.run(ctx)
+
+	return s, nil
+}
+
+// Events returns the channel of events. It is closed when the subscription
+// ends; Err then reports why.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Err returns the error that ended the subscription, or nil if it was
+// closed by the caller.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// Close ends the subscription and waits for it to stop.
+func (s *Subscription) Close() error {
+	s.cancel()
+	<-s.done
+
+	return nil
+}
+
+func (s *Subscription) run(ctx context.Context) {
+	defer close(s.done)
+	defer close(s.events)
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		conn := s.conn
+		s.mu.Unlock()
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		_ = conn.Close()
+	}()
+
+	for {
+		s.read(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err := s.reconnect(ctx); err != nil {
+			if ctx.Err() == nil {
+				s.fail(err)
+			}
+			return
+		}
+	}
+}
+
+// read delivers events until the connection fails.
+func (s *Subscription) read(ctx context.Context) {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		msg, err := Decode(data)
+		if err != nil {
+			continue
+		}
+
+		if !s.apply(msg.Value) {
+			continue
+		}
+
+		if !s.emit(ctx, Event{Envelope: msg}) {
+			return
+		}
+	}
+}
+
+func (s *Subscription) reconnect(ctx context.Context) error {
+	backoff := s.c.minBackoff
+
+	for {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		conn, err := s.c.dial(ctx, s.roomID)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrBadRequest) {
+			return err
+		}
+
+		if err == nil {
+			s.mu.Lock()
+			s.conn = conn
+			s.mu.Unlock()
+
+			if ctx.Err() != nil {
+				_ = conn.Close()
+				return ctx.Err()
+			}
+
+			s.resume(ctx)
+			return nil
+		}
+
+		backoff = min(backoff*2, s.c.maxBackoff)
+	}
+}
+
+// resume compares a snapshot of the room with the last known state and
+// emits the differences as events.
+func (s *Subscription) resume(ctx context.Context) {
+	snapshot, err := s.c.ListMessages(ctx, s.roomID)
+	if err != nil {
+		return
+	}
+
+	var missed []Event
+	var batch ReactionsBatch
+
+	current := make(map[string]bool, len(snapshot.Messages))
+	for _, m := range snapshot.Messages {
+		current[m.ID] = true
+	}
+	var deleted []string
+	for id := range s.messages {
+		if !current[id] {
+			deleted = append(deleted, id)
+		}
+	}
+	sort.Strings(deleted)
+	for _, id := range deleted {
+		missed = append(missed, s.resumed(KindMessageDeleted, MessageDeleted{ID: id}))
+		delete(s.messages, id)
+	}
+
+	for _, m := range snapshot.Messages {
+		known, ok := s.messages[m.ID]
+		if !ok {
+			missed = append(missed, s.resumed(KindMessageCreated, MessageCreated{ID: m.ID, Message: m.Message}))
+		}
+		if m.Answered && !known.Answered {
+			missed = append(missed, s.resumed(KindMessageAnswered, MessageAnswered{ID: m.ID}))
+		}
+		if ok && m.Hidden != known.Hidden {
+			missed = append(missed, s.resumed(KindMessageHidden, MessageHidden{ID: m.ID, Hidden: m.Hidden}))
+		}
+		if m.ReactionsCount != known.ReactionsCount {
+			batch.Reactions = append(batch.Reactions, ReactionCount{ID: m.ID, Count: m.ReactionsCount})
+		}
+		s.messages[m.ID] = m
+	}
+
+	if len(batch.Reactions) > 0 {
+		sort.Slice(batch.Reactions, func(i, j int) bool { return batch.Reactions[i].ID < batch.Reactions[j].ID })
+		missed = append(missed, s.resumed(KindReactionsBatch, batch))
+	}
+
+	for _, e := range missed {
+		if !s.emit(ctx, e) {
+			return
+		}
+	}
+}
+
+func (s *Subscription) resumed(kind string, value any) Event {
+	return Event{
+		Envelope: Envelope{
+			Version: entity.MessageSchemaVersion,
+			RoomId:  s.roomID,
+			Time:    time.Now().UTC(),
+			Kind:    kind,
+			Value:   value,
+		},
+		Resumed: true,
+	}
+}
+
+// apply records the event in the known state and reports whether it should
+// be delivered; events already reflected by a resume are dropped.
+func (s *Subscription) apply(value any) bool {
+	switch v := value.(type) {
+	case MessageCreated:
+		if _, ok := s.messages[v.ID]; ok {
+			return false
+		}
+		s.messages[v.ID] = Message{ID: v.ID, RoomID: s.roomID, Message: v.Message}
+	case MessageAnswered:
+		m := s.messages[v.ID]
+		m.Answered = true
+		s.messages[v.ID] = m
+	case ReactionAdded:
+		s.setCount(v.ID, v.Count)
+	case ReactionRemoved:
+		s.setCount(v.ID, v.Count)
+	case ReactionsBatch:
+		for _, r := range v.Reactions {
+			s.setCount(r.ID, r.Count)
+		}
+	case MessageHidden:
+		if m, ok := s.messages[v.ID]; ok {
+			m.Hidden = v.Hidden
+			s.messages[v.ID] = m
+		}
+	case MessageDeleted:
+		delete(s.messages, v.ID)
+	}
+
+	return true
+}
+
+func (s *Subscription) setCount(id string, count int64) {
+	if m, ok := s.messages[id]; ok {
+		m.ReactionsCount = count
+		s.messages[id] = m
+	}
+}
+
+func (s *Subscription) emit(ctx context.Context, e Event) bool {
+	select {
+	case s.events <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *Subscription) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+}
+
+func (c *Client) dial(ctx context.Context, roomID string) (*websocket.Conn, error) {
+	u := *c.baseURL
+	u.Scheme = map[string]string{"http": "ws", "https": "wss"}[u.Scheme]
+	u.Path += "/subscribe/" + url.PathEscape(roomID)
+	header := http.Header{}
+	if c.participantID != "" {
+		header.Set("X-Participant-Id", c.participantID)
+	}
+
+	conn, resp, err := c.dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			return nil, &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return nil, fmt.Errorf("client: failed to subscribe: %w", err)
+	}
+
+	return conn, nil
+}
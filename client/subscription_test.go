@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thiagoleet/go-ama-api/internal/api"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore/pgstoretest"
+)
+
+// listener lets a test drop every connection to the server and refuse new
+// ones until it is brought back up.
+type listener struct {
+	net.Listener
+
+	mu    sync.Mutex
+	conns []net.Conn
+	down  bool
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		l.mu.Lock()
+		if l.down {
+			l.mu.Unlock()
+			_ = conn.Close()
+			continue
+		}
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+
+		return conn, nil
+	}
+}
+
+func (l *listener) drop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.down = true
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.conns = nil
+}
+
+func (l *listener) up() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.down = false
+}
+
+func newServer(t *testing.T) (*Client, *pgstore.Queries, *listener) {
+	t.Helper()
+
+	ctx := context.Background()
+	db := pgstoretest.Open(t)
+	q := pgstore.New(db)
+	h := api.NewHandler(ctx, q, api.WithTransactions(db, 1))
+
+	server := httptest.NewUnstartedServer(h)
+	l := &listener{Listener: server.Listener}
+	server.Listener = l
+	server.Start()
+	t.Cleanup(func() {
+		l.up()
+		server.Close()
+		_ = h.Shutdown(ctx)
+	})
+
+	c, err := New(server.URL, WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, q, l
+}
+
+// next returns the next event of kind, skipping events of other kinds and
+// presence updates. An empty kind matches any other event.
+func next(t *testing.T, s *Subscription, kind string) Event {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-s.Events():
+			if !ok {
+				t.Fatalf("subscription ended waiting for %s: %v", kind, s.Err())
+			}
+			if e.Kind == kind || kind == "" && e.Kind != KindPresenceChanged {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", kind)
+		}
+	}
+}
+
+func TestSubscriptionResumesAfterDisconnect(t *testing.T) {
+	ctx := context.Background()
+	c, q, l := newServer(t)
+
+	roomID, err := c.CreateRoom(ctx, "Release planning")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := c.CreateMessage(ctx, roomID, "When is the next release?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := c.Subscribe(ctx, roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live, err := c.CreateMessage(ctx, roomID, "Will it support SSO?")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := next(t, s, KindMessageCreated)
+	if got := e.Value.(MessageCreated).ID; got != live || e.Resumed {
+		t.Fatalf("created event for %s (resumed %t), want live event for %s", got, e.Resumed, live)
+	}
+
+	// Changes made while the subscription is down are written to the store
+	// directly, so no event is sent for them: the client can only learn of
+	// them by resuming.
+	l.drop()
+
+	missed, err := q.InsertMessage(ctx, pgstore.InsertMessageParams{RoomID: uuid.MustParse(roomID), Message: "Is there a beta?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.MarkMessageAsAnswered(ctx, pgstore.MarkMessageAsAnsweredParams{ID: uuid.MustParse(first)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.ReactToMessage(ctx, pgstore.ReactToMessageParams{ID: uuid.MustParse(live)}); err != nil {
+		t.Fatal(err)
+	}
+
+	l.up()
+
+	resumed := map[string]Event{}
+	for len(resumed) < 3 {
+		e := next(t, s, "")
+		if !e.Resumed {
+			t.Fatalf("got live %s event before the resumed ones", e.Kind)
+		}
+		resumed[e.Kind] = e
+	}
+
+	if got := resumed[KindMessageCreated].Value.(MessageCreated).ID; got != missed.String() {
+		t.Errorf("resumed created event for %s, want %s", got, missed)
+	}
+	if got := resumed[KindMessageAnswered].Value.(MessageAnswered).ID; got != first {
+		t.Errorf("resumed answered event for %s, want %s", got, first)
+	}
+	if got := resumed[KindReactionsBatch].Value.(ReactionsBatch).Reactions; len(got) != 1 || got[0] != (ReactionCount{ID: live, Count: 1}) {
+		t.Errorf("resumed reactions %+v, want a count of 1 for %s", got, live)
+	}
+
+	// The subscription is live again.
+	if _, err := c.Answer(ctx, live); err != nil {
+		t.Fatal(err)
+	}
+
+	e = next(t, s, KindMessageAnswered)
+	if got := e.Value.(MessageAnswered).ID; got != live || e.Resumed {
+		t.Errorf("answered event for %s (resumed %t), want live event for %s", got, e.Resumed, live)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Err() = %v after Close, want nil", err)
+	}
+}
+
+func TestSubscribeUnknownRoom(t *testing.T) {
+	c, _, _ := newServer(t)
+
+	if _, err := c.Subscribe(context.Background(), uuid.NewString()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Subscribe() error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/api/usecases"
+)
+
+type (
+	Room    = entity.RoomDTO
+	Message = entity.MessageDTO
+
+	RoomInfo         = usecases.GetRoomByIdResponse
+	RoomMessages     = usecases.GetRoomMessagesResponse
+	ReactionResult   = usecases.ReactToMessageUseCaseResponse
+	AnswerResult     = usecases.AnswerMessageUseCaseResponse
+	createRoomInput  = usecases.CreateRoomInput
+	createRoomResult = usecases.CreateRoomResponse
+
+	createMessageInput  = usecases.CreateRoomMessageInput
+	createMessageResult = usecases.CreateRoomMessageResponse
+)
+
+// Event values, by kind. Frames of kinds this version does not know carry
+// a RawPayload.
+type (
+	Envelope        = entity.Message
+	RawPayload      = entity.RawPayload
+	MessageCreated  = entity.MessageMessageCreated
+	ReactionAdded   = entity.MessageMessageReactAdded
+	ReactionRemoved = entity.MessageMessageReactRemoved
+	MessageAnswered = entity.MessageMessageAnswered
+	PresenceChanged = entity.MessagePresenceChanged
+	ReactionsBatch  = entity.MessageReactionsBatch
+	ReactionCount   = entity.MessageReactionCount
+	MessageHidden   = entity.MessageMessageHidden
+	MessageDeleted  = entity.MessageMessageDeleted
+	CommandAck      = entity.MessageAck
+	CommandError    = entity.MessageError
+)
+
+const (
+	KindMessageCreated  = entity.MessageKindMessageCreated
+	KindReactionAdded   = entity.MessageKindMessageReactAdded
+	KindReactionRemoved = entity.MessageKindMessageReactRemoved
+	KindMessageAnswered = entity.MessageKindMessageAnswered
+	KindPresenceChanged = entity.MessageKindPresenceChanged
+	KindReactionsBatch  = entity.MessageKindReactionsBatch
+	KindMessageHidden   = entity.MessageKindMessageHidden
+	KindMessageDeleted  = entity.MessageKindMessageDeleted
+	KindAck             = entity.MessageKindAck
+	KindError           = entity.MessageKindError
+)
@@ -3,32 +3,76 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/joho/godotenv"
 	"github.com/thiagoleet/go-ama-api/internal/api"
+	"github.com/thiagoleet/go-ama-api/internal/config"
+	"github.com/thiagoleet/go-ama-api/internal/health"
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
+	"github.com/thiagoleet/go-ama-api/internal/origin"
+	"github.com/thiagoleet/go-ama-api/internal/outbox"
+	"github.com/thiagoleet/go-ama-api/internal/server"
+	"github.com/thiagoleet/go-ama-api/internal/store/migrate"
 	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"github.com/thiagoleet/go-ama-api/internal/webhooks"
 )
 
 func main() {
-	if err := godotenv.Load(); err != nil {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := logging.Setup(os.Stderr, logging.Config{
+		Format: cfg.Logging.Format,
+		Level:  cfg.Logging.Level,
+		Levels: cfg.Logging.Levels,
+	}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		ServiceName: "wsrs",
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		File:        cfg.Tracing.File,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
 		panic(err)
 	}
 
-	ctx := context.Background()
+	poolConfig, err := cfg.Database.PoolConfig()
+	if err != nil {
+		panic(err)
+	}
 
-	pool, err := pgxpool.New(ctx, fmt.Sprintf(
-		"user=%s password=%s host=%s port=%s dbname=%s",
-		os.Getenv("WSRS_DATABASE_USER"),
-		os.Getenv("WSRS_DATABASE_PASSWORD"),
-		os.Getenv("WSRS_DATABASE_HOST"),
-		os.Getenv("WSRS_DATABASE_PORT"),
-		os.Getenv("WSRS_DATABASE_NAME"),
-	))
+	poolConfig.ConnConfig.Tracer = tracing.QueryTracer{}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 
 	if err != nil {
 		panic(err)
@@ -41,18 +85,123 @@ func main() {
 		panic(err)
 	}
 
-	handler := api.NewHandler(pgstore.New(pool))
+	if cfg.Database.MigrateOnStart {
+		m, err := migrate.New(pool)
+		if err != nil {
+			panic(err)
+		}
 
-	go func() {
-		if err := http.ListenAndServe(":8080", handler); err != nil {
-			if !errors.Is(err, http.ErrServerClosed) {
-				panic(err)
-			}
+		if err := m.Up(ctx); err != nil {
+			panic(err)
 		}
+	}
+
+	if err := metrics.RegisterPool(pool); err != nil {
+		panic(err)
+	}
+
+	q := pgstore.New(pool)
+
+	probes := health.New()
+	probes.AddCheck("database", health.DatabaseCheck(pool))
+	probes.AddCheck("schema", health.SchemaCheck(q))
+
+	// The patterns were checked by config.Load.
+	corsOrigins := origin.MustParse(cfg.CORSOrigins()...)
+	wsOrigins := origin.MustParse(cfg.WebSocketOrigins()...)
+	if cfg.Env == config.EnvProduction && (corsOrigins.Permissive() || wsOrigins.Permissive()) {
+		logging.For("api").Warn("serving production with a permissive origin policy", "cors", corsOrigins.String(), "websocket", wsOrigins.String())
+	}
+
+	// The listeners were checked by config.Load.
+	listeners, err := cfg.HTTP.ParseListeners()
+	if err != nil {
+		panic(err)
+	}
+
+	instance := cfg.Outbox.Instance
+	if instance == "" {
+		hostname, _ := os.Hostname()
+		instance = hostname + listeners[0].Addr
+	}
+
+	opts := []api.Option{
+		api.WithHealth(probes),
+		api.WithTransactions(pool, cfg.Database.TxMaxAttempts),
+		api.WithInstance(instance),
+		api.WithOutbox(outbox.Options{
+			PollInterval: cfg.Outbox.PollInterval,
+			BatchSize:    cfg.Outbox.BatchSize,
+			ConsumerTTL:  cfg.Outbox.ConsumerTTL,
+		}),
+		api.WithWebhooks(webhooks.Options{
+			Timeout:         cfg.Webhooks.Timeout,
+			MaxAttempts:     cfg.Webhooks.MaxAttempts,
+			Backoff:         cfg.Webhooks.Backoff,
+			MaxBackoff:      cfg.Webhooks.MaxBackoff,
+			Retention:       cfg.Webhooks.Retention,
+			AllowedNetworks: webhooks.MustParseNetworks(cfg.Webhooks.AllowedNetworks...),
+		}),
+		api.WithCORSOrigins(corsOrigins),
+		api.WithWebSocketOrigins(wsOrigins),
+		api.WithCompression(cfg.WebSocket.Compression),
+		api.WithCompressionLevel(cfg.WebSocket.CompressionLevel),
+		api.WithCompressionThreshold(cfg.WebSocket.CompressionThreshold),
+		api.WithReadLimit(cfg.WebSocket.ReadLimit),
+		api.WithWriteTimeout(cfg.WebSocket.WriteTimeout),
+		api.WithBufferSizes(cfg.WebSocket.ReadBufferSize, cfg.WebSocket.WriteBufferSize),
+		api.WithReactionWindow(cfg.Features.ReactionWindow),
+		api.WithResponseValidation(cfg.Features.ValidateResponses),
+		api.WithIdempotencyTTL(cfg.Features.IdempotencyTTL),
+	}
+
+	if cfg.Features.WriteBehindReactions {
+		opts = append(opts, api.WithWriteBehindReactions(cfg.Features.ReactionFlushInterval))
+	}
+
+	handler := api.NewHandler(context.Background(), q, opts...)
+
+	srv, err := server.New(handler, listeners, server.Options{
+		CertFile:       cfg.HTTP.TLS.CertFile,
+		KeyFile:        cfg.HTTP.TLS.KeyFile,
+		ReloadInterval: cfg.HTTP.TLS.ReloadInterval,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
 	}()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
-	<-quit
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			panic(err)
+		}
+	case <-ctx.Done():
+	}
+
+	stop()
+	probes.SetShuttingDown()
+	fmt.Println("Server stopping...")
+	time.Sleep(cfg.HTTP.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to drain http requests:", err)
+	}
+
+	if err := handler.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to drain subscribers:", err)
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to flush traces:", err)
+	}
+
 	fmt.Println("Server stopped...")
 }
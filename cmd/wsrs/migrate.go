@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/thiagoleet/go-ama-api/internal/config"
+	"github.com/thiagoleet/go-ama-api/internal/store/migrate"
+)
+
+const migrateUsage = `usage: wsrs migrate [flags] up|down|status|to N`
+
+// runMigrate implements "wsrs migrate" and returns the exit code.
+func runMigrate(args []string) int {
+	cfg, args, err := config.LoadCommand("wsrs migrate", args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 0
+		}
+
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	poolConfig, err := cfg.Database.PoolConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer pool.Close()
+
+	m, err := migrate.New(pool)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch args[0] {
+	case "up":
+		err = m.Up(ctx)
+	case "down":
+		err = m.Down(ctx)
+	case "to":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+
+		var target int64
+		target, err = strconv.ParseInt(args[1], 10, 32)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "invalid version:", args[1])
+			return 2
+		}
+
+		err = m.To(ctx, int32(target))
+	case "status":
+		err = printStatus(ctx, m)
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if args[0] != "status" {
+		return printVersion(ctx, m)
+	}
+
+	return 0
+}
+
+func printStatus(ctx context.Context, m *migrate.Migrator) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range status.Migrations {
+		state := "pending"
+		if migration.Version <= status.Current {
+			state = "applied"
+		}
+		fmt.Printf("%3d  %-8s %s\n", migration.Version, state, migration.Name)
+	}
+	fmt.Printf("\nversion %d of %d\n", status.Current, status.Latest)
+
+	return nil
+}
+
+func printVersion(ctx context.Context, m *migrate.Migrator) int {
+	status, err := m.Status(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Printf("database at version %d of %d\n", status.Current, status.Latest)
+	return 0
+}
@@ -0,0 +1,113 @@
+// Command wsrsctl operates rooms and messages directly against the store,
+// through the same use cases as the API. Changes to messages are written to
+// the outbox with the events describing them, so servers push them to the
+// clients connected at the time.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/thiagoleet/go-ama-api/internal/api/usecases"
+	"github.com/thiagoleet/go-ama-api/internal/config"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+const usage = `usage: wsrsctl [flags] <command> [-o table|json] [args]
+
+Rooms:
+  rooms list                      list every room with message and connection counts
+  rooms inspect ROOM_ID           show a room and totals over its messages
+  rooms export ROOM_ID            print a room and all its messages as JSON
+  rooms close ROOM_ID             stop accepting new messages
+  rooms archive ROOM_ID           close the room and mark it archived
+  rooms reopen ROOM_ID            accept new messages again
+  rooms reset-reactions ROOM_ID   set the reactions of every message to zero
+  rooms rotate-token ROOM_ID      issue a new host token, required to edit the room and its webhooks
+
+Messages:
+  messages hide MESSAGE_ID
+  messages unhide MESSAGE_ID
+  messages delete MESSAGE_ID
+  messages reset-reactions MESSAGE_ID
+
+Connections:
+  connections                     show connections open to each room, on every instance
+
+Flags are the same as wsrs: -config, -database-url and the WSRS_* environment.`
+
+// errUsage makes main print the usage and exit with status 2.
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	cfg, args, err := config.LoadCommand("wsrsctl", args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, usage)
+			return 0
+		}
+
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	poolConfig, err := cfg.Database.PoolConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer pool.Close()
+
+	q := pgstore.New(pool)
+	work := usecases.NewUnitOfWork(q, pool, nil, cfg.Database.TxMaxAttempts)
+
+	switch args[0] {
+	case "rooms":
+		err = runRooms(ctx, q, work, args[1:])
+	case "messages":
+		err = runMessages(ctx, work, args[1:])
+	case "connections":
+		err = runConnections(ctx, q, args[1:])
+	default:
+		err = errUsage
+	}
+
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	case errors.Is(err, pgx.ErrNoRows):
+		fmt.Fprintln(os.Stderr, "not found")
+		return 1
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+}
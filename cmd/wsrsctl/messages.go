@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+
+	"github.com/thiagoleet/go-ama-api/internal/api/usecases"
+)
+
+func runMessages(ctx context.Context, work *usecases.UnitOfWork, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	format, rest, err := parseArgs("messages "+args[0], args[1:], 1)
+	if err != nil {
+		return err
+	}
+
+	messageID, err := parseID(rest[0])
+	if err != nil {
+		return err
+	}
+
+	result := map[string]any{"message_id": messageID.String()}
+
+	switch args[0] {
+	case "hide", "unhide":
+		hidden := args[0] == "hide"
+		if err := usecases.NewSetMessageHiddenUseCase(work, ctx).Execute(messageID, hidden); err != nil {
+			return err
+		}
+
+		state := "visible"
+		if hidden {
+			state = "hidden"
+		}
+
+		result["hidden"] = hidden
+		return printResult(format, result, "message "+messageID.String()+" is "+state)
+	case "delete":
+		if err := usecases.NewDeleteMessageUseCase(work, ctx).Execute(messageID); err != nil {
+			return err
+		}
+
+		result["deleted"] = true
+		return printResult(format, result, "message "+messageID.String()+" deleted")
+	case "reset-reactions":
+		if _, err := usecases.NewResetReactionsUseCase(work, ctx).ExecuteMessage(messageID); err != nil {
+			return err
+		}
+
+		result["reactions_count"] = 0
+		return printResult(format, result, "reset reactions of message "+messageID.String())
+	default:
+		return errUsage
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// parseArgs parses the -o flag of a subcommand and returns the format and
+// the remaining arguments, of which there must be exactly want.
+func parseArgs(name string, args []string, want int) (string, []string, error) {
+	fset := flag.NewFlagSet(name, flag.ContinueOnError)
+	fset.SetOutput(io.Discard)
+	format := fset.String("o", formatTable, "output format: table or json")
+
+	if err := fset.Parse(args); err != nil {
+		return "", nil, err
+	}
+
+	if *format != formatTable && *format != formatJSON {
+		return "", nil, fmt.Errorf("unknown output format %q", *format)
+	}
+
+	if fset.NArg() != want {
+		return "", nil, errUsage
+	}
+
+	return *format, fset.Args(), nil
+}
+
+func parseID(raw string) (uuid.UUID, error) {
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid id %q", raw)
+	}
+
+	return id, nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printTable writes header and rows aligned in columns.
+func printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+
+	return w.Flush()
+}
+
+// printResult prints v as JSON or, for tables, as a single line of text.
+func printResult(format string, v any, text string) error {
+	if format == formatJSON {
+		return printJSON(v)
+	}
+
+	fmt.Println(text)
+	return nil
+}
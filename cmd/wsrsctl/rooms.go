@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/api/usecases"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+func runRooms(ctx context.Context, q *pgstore.Queries, work *usecases.UnitOfWork, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	if args[0] == "list" {
+		format, _, err := parseArgs("rooms list", args[1:], 0)
+		if err != nil {
+			return err
+		}
+
+		return listRooms(ctx, q, format)
+	}
+
+	format, rest, err := parseArgs("rooms "+args[0], args[1:], 1)
+	if err != nil {
+		return err
+	}
+
+	roomID, err := parseID(rest[0])
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "inspect":
+		response, err := usecases.NewExportRoomUseCase(q, ctx).Execute(roomID)
+		if err != nil {
+			return err
+		}
+
+		if format == formatJSON {
+			response.Messages = nil
+			return printJSON(response)
+		}
+
+		return printTable([]string{"FIELD", "VALUE"}, [][]string{
+			{"id", response.Room.ID},
+			{"theme", response.Room.Theme},
+			{"status", response.Room.Status},
+			{"host token", strconv.FormatBool(response.HasHostToken)},
+			{"messages", strconv.Itoa(len(response.Messages))},
+			{"answered", strconv.Itoa(response.Answered)},
+			{"hidden", strconv.Itoa(response.Hidden)},
+			{"reactions", strconv.FormatInt(response.Reactions, 10)},
+			{"participants", strconv.FormatInt(response.Participants, 10)},
+		})
+	case "export":
+		response, err := usecases.NewExportRoomUseCase(q, ctx).Execute(roomID)
+		if err != nil {
+			return err
+		}
+
+		return printJSON(response)
+	case "close", "archive", "reopen":
+		status := map[string]string{
+			"close":   entity.RoomStatusClosed,
+			"archive": entity.RoomStatusArchived,
+			"reopen":  entity.RoomStatusOpen,
+		}[args[0]]
+
+		if err := usecases.NewSetRoomStatusUseCase(q, ctx).Execute(roomID, status); err != nil {
+			return err
+		}
+
+		return printResult(format, map[string]string{"room_id": roomID.String(), "status": status}, "room "+roomID.String()+" is "+status)
+	case "reset-reactions":
+		response, err := usecases.NewResetReactionsUseCase(work, ctx).ExecuteRoom(roomID)
+		if err != nil {
+			return err
+		}
+
+		return printResult(format, response, "reset reactions of "+strconv.FormatInt(response.Messages, 10)+" messages")
+	case "rotate-token":
+		response, err := usecases.NewRotateHostTokenUseCase(q, ctx).Execute(roomID)
+		if err != nil {
+			return err
+		}
+
+		return printResult(format, response, response.HostToken)
+	default:
+		return errUsage
+	}
+}
+
+func listRooms(ctx context.Context, q *pgstore.Queries, format string) error {
+	response, err := usecases.NewListRoomsWithStatsUseCase(q, ctx).Execute()
+	if err != nil {
+		return err
+	}
+
+	if format == formatJSON {
+		return printJSON(response)
+	}
+
+	rows := make([][]string, 0, len(response.Rooms))
+	for _, room := range response.Rooms {
+		rows = append(rows, []string{
+			room.ID,
+			room.Status,
+			strconv.FormatInt(room.Messages, 10),
+			strconv.FormatInt(room.Connections, 10),
+			room.Theme,
+		})
+	}
+
+	return printTable([]string{"ID", "STATUS", "MESSAGES", "CONNECTIONS", "THEME"}, rows)
+}
+
+func runConnections(ctx context.Context, q *pgstore.Queries, args []string) error {
+	format, _, err := parseArgs("connections", args, 0)
+	if err != nil {
+		return err
+	}
+
+	response, err := usecases.NewListRoomsWithStatsUseCase(q, ctx).Execute()
+	if err != nil {
+		return err
+	}
+
+	connected := []usecases.RoomStats{}
+	for _, room := range response.Rooms {
+		if room.Connections > 0 {
+			connected = append(connected, room)
+		}
+	}
+
+	if format == formatJSON {
+		return printJSON(connected)
+	}
+
+	rows := make([][]string, 0, len(connected))
+	for _, room := range connected {
+		rows = append(rows, []string{
+			room.ID,
+			strconv.FormatInt(room.Connections, 10),
+			strconv.FormatInt(room.Instances, 10),
+			room.Theme,
+		})
+	}
+
+	return printTable([]string{"ROOM", "CONNECTIONS", "INSTANCES", "THEME"}, rows)
+}
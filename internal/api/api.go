@@ -4,8 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"log/slog"
 	"net/http"
+	"net/netip"
 	"sync"
 
 	"github.com/go-chi/chi/v5"
@@ -15,77 +15,250 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/jackc/pgx/v5"
 	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/api/openapi"
 	"github.com/thiagoleet/go-ama-api/internal/api/usecases"
+	"github.com/thiagoleet/go-ama-api/internal/hub"
+	"github.com/thiagoleet/go-ama-api/internal/idempotency"
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
+	"github.com/thiagoleet/go-ama-api/internal/origin"
+	"github.com/thiagoleet/go-ama-api/internal/outbox"
+	"github.com/thiagoleet/go-ama-api/internal/presence"
+	"github.com/thiagoleet/go-ama-api/internal/store/counters"
 	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"github.com/thiagoleet/go-ama-api/internal/webhooks"
+	"github.com/thiagoleet/go-ama-api/internal/wire"
 )
 
-// TODO: change to interface
+// Handler serves the API and owns the realtime resources behind it.
+type Handler interface {
+	http.Handler
+	// Shutdown sends a going-away close frame to every WebSocket subscriber,
+	// stops the background workers and waits for them to finish, or for ctx
+	// to be done.
+	Shutdown(ctx context.Context) error
+}
+
 type apiHandler struct {
-	q           *pgstore.Queries
-	r           *chi.Mux
-	upgrader    websocket.Upgrader
-	subscribers map[string]map[*websocket.Conn]context.CancelFunc
-	mu          *sync.Mutex
+	q         *pgstore.Queries
+	r         *chi.Mux
+	upgrader  websocket.Upgrader
+	hub       *hub.Hub
+	presence  *presence.Tracker
+	reactions *hub.ReactionAggregator
+	counter   usecases.ReactionCounter
+	work      *usecases.UnitOfWork
+	// webhookNetworks are the private networks webhooks may be sent to.
+	webhookNetworks []netip.Prefix
+	cancel          context.CancelFunc
+	workers         *sync.WaitGroup
 }
 
 func (h apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.r.ServeHTTP(w, r)
 }
 
-func NewHandler(q *pgstore.Queries) http.Handler {
+func (h apiHandler) Shutdown(ctx context.Context) error {
+	h.reactions.Shutdown()
+	err := h.hub.Shutdown(ctx)
+	h.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		h.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// publish broadcasts an event committed by a unit of work. Reaction updates
+// go through the aggregator so busy rooms get them coalesced.
+func (h apiHandler) publish(ctx context.Context, msg entity.Message) {
+	switch msg.Kind {
+	case entity.MessageKindMessageReactAdded, entity.MessageKindMessageReactRemoved:
+		h.reactions.Publish(ctx, msg)
+	default:
+		usecases.NewNotifyClientsUseCase(h.hub, ctx).Execute(msg)
+	}
+}
+
+func (h apiHandler) goWorker(fn func()) {
+	h.workers.Add(1)
+	go func() {
+		defer h.workers.Done()
+		fn()
+	}()
+}
+
+// NewHandler builds the API router. Background workers started by the
+// handler stop when ctx is done or on Shutdown.
+func NewHandler(ctx context.Context, q *pgstore.Queries, opts ...Option) Handler {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
 	a := apiHandler{
 		q: q,
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				// Only in dev environment!!
-				return true
-			},
+			CheckOrigin:       checkOrigin(o.wsOrigins),
+			EnableCompression: o.compression,
+			Subprotocols:      wire.Subprotocols,
+			ReadBufferSize:    o.readBufferSize,
+			WriteBufferSize:   o.writeBufferSize,
 		},
-		subscribers: make(map[string]map[*websocket.Conn]context.CancelFunc),
-		mu:          &sync.Mutex{},
+		hub: hub.New(hub.Options{
+			Compression:          o.compression,
+			CompressionLevel:     o.compressionLevel,
+			CompressionThreshold: o.compressionThreshold,
+			ReadLimit:            o.readLimit,
+			WriteTimeout:         o.writeTimeout,
+		}),
+		presence:        presence.NewTracker(q, presence.NewInstanceID()),
+		webhookNetworks: o.webhooks.AllowedNetworks,
+		cancel:          cancel,
+		workers:         &sync.WaitGroup{},
+	}
+
+	a.reactions = hub.NewReactionAggregator(o.reactionWindow, func(ctx context.Context, msg entity.Message) {
+		usecases.NewNotifyClientsUseCase(a.hub, ctx).Execute(msg)
+	})
+	a.work = usecases.NewUnitOfWork(q, o.txBeginner, a.publish, o.txAttempts)
+
+	// Events committed by any instance reach the subscribers of this one
+	// through its own outbox cursor, and webhooks through a cursor shared
+	// by every instance.
+	if o.txBeginner != nil {
+		dispatcher := outbox.NewDispatcher(q, o.txBeginner, o.outbox)
+		dispatcher.Register("hub:"+o.instance, func(ctx context.Context, _ *pgstore.Queries, events []entity.Message) error {
+			for _, msg := range events {
+				a.publish(ctx, msg)
+			}
+			return nil
+		})
+		dispatcher.Register(webhooks.ConsumerName, webhooks.Enqueue)
+		a.goWorker(func() {
+			dispatcher.Run(ctx)
+		})
+
+		sender := webhooks.NewSender(q, o.webhooks)
+		a.goWorker(func() {
+			sender.Run(ctx)
+		})
+	}
+
+	a.goWorker(func() {
+		a.presence.Run(ctx, usecases.NewNotifyClientsUseCase(a.hub, ctx).Execute)
+	})
+
+	if o.reactionFlush > 0 {
+		counter := counters.NewWriteBehind(q, o.txBeginner, o.reactionFlush, a.publish)
+		a.counter = counter
+		a.goWorker(func() {
+			counter.Run(ctx)
+		})
+	}
+
+	// Retried requests carrying an Idempotency-Key replay the first response.
+	idempotent := func(next http.Handler) http.Handler { return next }
+	if o.idempotencyTTL > 0 {
+		keys := idempotency.NewStore(q, o.idempotencyTTL)
+		idempotent = keys.Middleware
+		a.goWorker(func() {
+			keys.Run(ctx)
+		})
 	}
 
 	r := chi.NewRouter()
 
 	// Adding middlewares
-	r.Use(middleware.RequestID, middleware.Recoverer, middleware.Logger)
+	r.Use(middleware.RequestID, logging.Middleware, middleware.Recoverer, tracing.Middleware, metrics.Middleware)
+
+	var spec *openapi.Spec
+	if o.validate {
+		var err error
+		if spec, err = openapi.Load(); err != nil {
+			panic(err)
+		}
+		r.Use(openapi.Middleware(spec))
+	}
 
 	// Adding CORS
-	r.Use(cors.Handler(cors.Options{
-		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
-		AllowedOrigins: []string{"https://*", "http://*"},
-		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
+	r.Use(skipWebSocket(cors.Handler(cors.Options{
+		AllowOriginFunc:  allowOrigin(o.corsOrigins),
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-Host-Token", "X-Participant-Id", "If-Match", "If-None-Match", idempotency.Header},
+		ExposedHeaders:   []string{"Link", "ETag", idempotency.ReplayedHeader},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
-	}))
+	})))
+
+	// Adding probes
+	o.health.AddCheck("hub", func(ctx context.Context) (any, error) {
+		stats := a.hub.Stats()
+		if stats.Closed {
+			return stats, hub.ErrClosed
+		}
+
+		return stats, nil
+	})
+	r.Get("/healthz", o.health.HandleLiveness)
+	r.Get("/readyz", o.health.HandleReadiness)
+	r.Get("/version", o.health.HandleVersion)
+	r.Method(http.MethodGet, "/metrics", metrics.Handler())
 
 	// Adding Web Socket
 	r.Get("/subscribe/{room_id}", a.handleSubscribe)
 
 	// Adding routes
 	r.Route("/api", func(r chi.Router) {
+		r.Get("/openapi.json", openapi.HandleDocument)
+		r.Get("/docs", openapi.HandleDocs)
+
 		r.Route("/rooms", func(r chi.Router) {
-			r.Post("/", a.handleCreateRoom)
+			r.With(idempotent).Post("/", a.handleCreateRoom)
 			r.Get("/", a.handleGetRooms)
+			r.Get("/{room_id}/info", a.handleGetRoom)
+			r.Patch("/{room_id}/info", a.handleUpdateRoom)
 
 			r.Route("/{room_id}/messages", func(r chi.Router) {
 				r.Get("/", a.handleGetRoomMessages)
-				r.Post("/", a.handleCreateRoomMessage)
+				r.With(idempotent).Post("/", a.handleCreateRoomMessage)
+			})
+
+			r.Route("/{room_id}/webhooks", func(r chi.Router) {
+				r.Get("/", a.handleListWebhooks)
+				r.With(idempotent).Post("/", a.handleCreateWebhook)
+				r.Delete("/{webhook_id}", a.handleDeleteWebhook)
+				r.Get("/{webhook_id}/deliveries", a.handleListWebhookDeliveries)
+				r.Post("/{webhook_id}/deliveries/{delivery_id}/redeliver", a.handleRedeliverWebhook)
 			})
 
 			r.Route("/{message_id}", func(r chi.Router) {
 				r.Get("/", a.handleGetRoomMessage)
-				r.Patch("/react", a.handleReactToMessage)
-				r.Delete("/react", a.handleRemoveReactFromMessage)
+				r.With(idempotent).Patch("/react", a.handleReactToMessage)
+				r.With(idempotent).Delete("/react", a.handleRemoveReactFromMessage)
 				r.Patch("/answer", a.handleMarkMessageAsAnswered)
 			})
 		})
 
 	})
 
+	if spec != nil {
+		for _, route := range spec.Undocumented(r) {
+			logging.For("openapi").Warn("route is not documented", "route", route)
+		}
+	}
+
 	a.r = r
 
 	return a
@@ -103,7 +276,7 @@ func (h apiHandler) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
 	response, err := u.Execute(body)
 
 	if err != nil {
-		slog.Error("failed to insert room", "error", err)
+		logging.FromContext(r.Context()).Error("failed to insert room", "error", err)
 		http.Error(w, "something went wrong", http.StatusInternalServerError)
 		return
 	}
@@ -129,7 +302,7 @@ func (h apiHandler) handleCreateRoomMessage(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	u := usecases.NewCreateRoomMessageUseCase(h.q, r.Context())
+	u := usecases.NewCreateRoomMessageUseCase(h.work, r.Context())
 
 	response, err := u.Execute(body, roomID)
 
@@ -139,6 +312,11 @@ func (h apiHandler) handleCreateRoomMessage(w http.ResponseWriter, r *http.Reque
 			return
 		}
 
+		if errors.Is(err, usecases.ErrRoomClosed) {
+			http.Error(w, "room is closed", http.StatusConflict)
+			return
+		}
+
 		http.Error(w, "something went wrong", http.StatusInternalServerError)
 		return
 	}
@@ -146,18 +324,6 @@ func (h apiHandler) handleCreateRoomMessage(w http.ResponseWriter, r *http.Reque
 	data, _ := json.Marshal(response)
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write(data)
-
-	notifyClients := usecases.NewNotifyClientsUseCase(h.mu, h.subscribers).Execute
-
-	go notifyClients(entity.Message{
-		Kind:   entity.MessageKindMessageCreated,
-		RoomId: rawRoomID,
-		Value: entity.MessageMessageCreated{
-			ID:      response.ID,
-			Message: body.Message,
-		},
-	})
-
 }
 
 func (h apiHandler) handleGetRooms(w http.ResponseWriter, r *http.Request) {
@@ -167,29 +333,27 @@ func (h apiHandler) handleGetRooms(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			slog.Error("no rooms found", "error", err)
+			logging.FromContext(r.Context()).Error("no rooms found", "error", err)
 			data, _ := json.Marshal(usecases.GetRoomsResponse{
 				Rooms: []entity.RoomDTO{},
 				Total: 0,
 			})
-			w.Header().Set("Content-Type", "application/json")
-			_, _ = w.Write(data)
+			writeCacheable(w, r, data)
 
 			return
 		}
 
-		slog.Error("failed to get rooms", "error", err)
+		logging.FromContext(r.Context()).Error("failed to get rooms", "error", err)
 		http.Error(w, "something went wrong", http.StatusInternalServerError)
 		return
 	}
 
 	data, _ := json.Marshal(response)
-	w.Header().Set("Content-Type", "application/json")
-	_, _ = w.Write(data)
+	writeCacheable(w, r, data)
 
 }
 
-func (h apiHandler) handleGetRoomMessages(w http.ResponseWriter, r *http.Request) {
+func (h apiHandler) handleGetRoom(w http.ResponseWriter, r *http.Request) {
 	rawRoomID := chi.URLParam(r, "room_id")
 	roomID, err := uuid.Parse(rawRoomID)
 
@@ -198,23 +362,116 @@ func (h apiHandler) handleGetRoomMessages(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	u := usecases.NewGetRoomMessages(h.q, r.Context())
+	u := usecases.NewGetRoomByIdUseCase(h.q, r.Context())
 
 	response, err := u.Execute(roomID)
 
 	if err != nil {
-		slog.Error("failed to get room messages", "error", err)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "room not found", http.StatusNotFound)
+			return
+		}
+
+		logging.FromContext(r.Context()).Error("failed to get room", "error", err)
 		http.Error(w, "something went wrong", http.StatusInternalServerError)
 		return
 	}
 
+	data, _ := json.Marshal(response)
+	writeCacheable(w, r, data)
+}
+
+func (h apiHandler) handleUpdateRoom(w http.ResponseWriter, r *http.Request) {
+	rawRoomID := chi.URLParam(r, "room_id")
+	roomID, err := uuid.Parse(rawRoomID)
+
+	if err != nil {
+		http.Error(w, "invalid room id", http.StatusBadRequest)
+		return
+	}
+
+	var body usecases.UpdateRoomInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	u := usecases.NewUpdateRoomUseCase(h.q, r.Context())
+
+	response, err := u.Execute(roomID, body, r.Header.Get("X-Host-Token"), ifMatch(r))
+
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			http.Error(w, "room not found", http.StatusNotFound)
+		case errors.Is(err, usecases.ErrInvalidRoomUpdate):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, usecases.ErrInvalidHostToken):
+			http.Error(w, "invalid host token", http.StatusForbidden)
+		case errors.Is(err, usecases.ErrVersionMismatch):
+			http.Error(w, "room was modified", http.StatusPreconditionFailed)
+		default:
+			logging.FromContext(r.Context()).Error("failed to update room", "error", err)
+			http.Error(w, "something went wrong", http.StatusInternalServerError)
+		}
+		return
+	}
+
 	data, _ := json.Marshal(response)
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(response.Version))
 	_, _ = w.Write(data)
 }
 
+func (h apiHandler) handleGetRoomMessages(w http.ResponseWriter, r *http.Request) {
+	rawRoomID := chi.URLParam(r, "room_id")
+	roomID, err := uuid.Parse(rawRoomID)
+
+	if err != nil {
+		http.Error(w, "invalid room id", http.StatusBadRequest)
+		return
+	}
+
+	u := usecases.NewGetRoomMessages(h.q, h.counter, r.Context())
+
+	response, err := u.Execute(roomID)
+
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to get room messages", "error", err)
+		http.Error(w, "something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(response)
+	writeCacheable(w, r, data)
+}
+
 func (h apiHandler) handleGetRoomMessage(w http.ResponseWriter, r *http.Request) {
-	h.handleGetRoomMessages(w, r)
+	rawMessageID := chi.URLParam(r, "message_id")
+	messageID, err := uuid.Parse(rawMessageID)
+
+	if err != nil {
+		http.Error(w, "invalid message id", http.StatusBadRequest)
+		return
+	}
+
+	u := usecases.NewGetRoomMessage(h.q, h.counter, r.Context())
+
+	response, err := u.Execute(messageID)
+
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "message not found", http.StatusNotFound)
+			return
+		}
+
+		logging.FromContext(r.Context()).Error("failed to get message", "error", err)
+		http.Error(w, "something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	data, _ := json.Marshal(response)
+	writeCacheable(w, r, data)
 }
 
 func (h apiHandler) handleReactToMessage(w http.ResponseWriter, r *http.Request) {
@@ -226,19 +483,24 @@ func (h apiHandler) handleReactToMessage(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	u := usecases.NewReactToMessageUseCase(h.q, r.Context())
+	u := usecases.NewReactToMessageUseCase(h.work, h.counter, r.Context())
 
-	response, err := u.Execute(messageID)
+	response, err := u.Execute(messageID, ifMatch(r))
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			slog.Error("message not found", "error", err)
+			logging.FromContext(r.Context()).Error("message not found", "error", err)
 			http.Error(w, "message not found", http.StatusNotFound)
 
 			return
 		}
 
-		slog.Error("failed to get message", "error", err)
+		if errors.Is(err, usecases.ErrVersionMismatch) {
+			http.Error(w, "message was modified", http.StatusPreconditionFailed)
+			return
+		}
+
+		logging.FromContext(r.Context()).Error("failed to get message", "error", err)
 		http.Error(w, "something went wrong", http.StatusInternalServerError)
 		return
 	}
@@ -246,17 +508,6 @@ func (h apiHandler) handleReactToMessage(w http.ResponseWriter, r *http.Request)
 	data, _ := json.Marshal(response)
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write(data)
-
-	notifyClients := usecases.NewNotifyClientsUseCase(h.mu, h.subscribers).Execute
-
-	go notifyClients(entity.Message{
-		Kind: entity.MessageKindMessageReactAdded,
-		Value: entity.MessageMessageReactAdded{
-			ID:    response.MessageID,
-			Count: response.ReactionsCount,
-		},
-	})
-
 }
 
 func (h apiHandler) handleRemoveReactFromMessage(w http.ResponseWriter, r *http.Request) {
@@ -268,19 +519,24 @@ func (h apiHandler) handleRemoveReactFromMessage(w http.ResponseWriter, r *http.
 		return
 	}
 
-	u := usecases.NewRemoveReactFromMessageUseCase(h.q, r.Context())
+	u := usecases.NewRemoveReactFromMessageUseCase(h.work, h.counter, r.Context())
 
-	response, err := u.Execute(messageID)
+	response, err := u.Execute(messageID, ifMatch(r))
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			slog.Error("message not found", "error", err)
+			logging.FromContext(r.Context()).Error("message not found", "error", err)
 			http.Error(w, "message not found", http.StatusNotFound)
 
 			return
 		}
 
-		slog.Error("failed to get message", "error", err)
+		if errors.Is(err, usecases.ErrVersionMismatch) {
+			http.Error(w, "message was modified", http.StatusPreconditionFailed)
+			return
+		}
+
+		logging.FromContext(r.Context()).Error("failed to get message", "error", err)
 		http.Error(w, "something went wrong", http.StatusInternalServerError)
 		return
 	}
@@ -288,16 +544,6 @@ func (h apiHandler) handleRemoveReactFromMessage(w http.ResponseWriter, r *http.
 	data, _ := json.Marshal(response)
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write(data)
-
-	notifyClients := usecases.NewNotifyClientsUseCase(h.mu, h.subscribers).Execute
-
-	go notifyClients(entity.Message{
-		Kind: entity.MessageKindMessageReactedRemoved,
-		Value: entity.MessageMessageReactRemoved{
-			ID:    response.MessageID,
-			Count: response.ReactionsCount,
-		},
-	})
 }
 
 func (h apiHandler) handleMarkMessageAsAnswered(w http.ResponseWriter, r *http.Request) {
@@ -309,35 +555,32 @@ func (h apiHandler) handleMarkMessageAsAnswered(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	u := usecases.NewAnswerMessageUseCase(h.q, r.Context())
+	u := usecases.NewAnswerMessageUseCase(h.work, r.Context())
 
-	response, err := u.Execute(messageID)
+	response, err := u.Execute(messageID, ifMatch(r))
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
-			slog.Error("message not found", "error", err)
+			logging.FromContext(r.Context()).Error("message not found", "error", err)
 			http.Error(w, "message not found", http.StatusNotFound)
 
 			return
 		}
 
-		slog.Error("failed to get message", "error", err)
+		if errors.Is(err, usecases.ErrVersionMismatch) {
+			http.Error(w, "message was modified", http.StatusPreconditionFailed)
+			return
+		}
+
+		logging.FromContext(r.Context()).Error("failed to get message", "error", err)
 		http.Error(w, "something went wrong", http.StatusInternalServerError)
 		return
 	}
 
 	data, _ := json.Marshal(response)
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(response.Version))
 	_, _ = w.Write(data)
-
-	notifyClients := usecases.NewNotifyClientsUseCase(h.mu, h.subscribers).Execute
-
-	go notifyClients(entity.Message{
-		Kind: entity.MessageKindMessageAnswered,
-		Value: entity.MessageMessageAnswered{
-			ID: response.MessageID,
-		},
-	})
 }
 
 func (h apiHandler) handleSubscribe(w http.ResponseWriter, r *http.Request) {
@@ -349,7 +592,7 @@ func (h apiHandler) handleSubscribe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = usecases.NewGetRoomByIdUseCase(h.q, r.Context()).Execute(roomID)
+	_, err = h.q.GetRoom(r.Context(), roomID)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -361,30 +604,87 @@ func (h apiHandler) handleSubscribe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, err := h.upgrader.Upgrade(w, r, nil)
+	c, err := h.upgrader.Upgrade(metrics.CountWebSocketBytes(w, rawRoomID), r, nil)
 	if err != nil {
-		slog.Warn("failed to upgrade connection", "error", err)
-		http.Error(w, "failed to upgrade to ws connection", http.StatusBadRequest)
+		logging.FromContext(r.Context()).Warn("failed to upgrade connection", "error", err)
+		return
 	}
 
 	defer c.Close()
 
 	ctx, cancel := context.WithCancel((r.Context()))
 
-	h.mu.Lock()
+	client, err := h.hub.Subscribe(rawRoomID, c, cancel)
+	if err != nil {
+		<-ctx.Done()
+		return
+	}
+
+	logging.FromContext(r.Context()).Info("new client connected", "room_id", rawRoomID, "cliend_ip", r.RemoteAddr)
 
-	if _, ok := h.subscribers[rawRoomID]; !ok {
-		h.subscribers[rawRoomID] = make(map[*websocket.Conn]context.CancelFunc)
+	// Presence counts connections: clients are not authenticated, so an ID
+	// they send can't be trusted to tell whether two connections belong to
+	// the same person.
+	connection := uuid.NewString()
+	if err := h.presence.Join(ctx, roomID, connection); err != nil {
+		logging.FromContext(r.Context()).Error("failed to register presence", "room_id", rawRoomID, "error", err)
 	}
 
-	slog.Info("new client connected", "room_id", rawRoomID, "cliend_ip", r.RemoteAddr)
-	h.subscribers[rawRoomID][c] = cancel
-	h.mu.Unlock()
+	go h.readCommands(ctx, cancel, c, client, roomID)
 
 	<-ctx.Done()
 
-	h.mu.Lock()
-	delete(h.subscribers[rawRoomID], c)
-	h.mu.Unlock()
+	h.hub.Unsubscribe(rawRoomID, client)
+
+	if err := h.presence.Leave(context.WithoutCancel(ctx), roomID, connection); err != nil {
+		logging.FromContext(r.Context()).Error("failed to release presence", "room_id", rawRoomID, "error", err)
+	}
+
+}
+
+// checkOrigin returns the upgrader's origin check for policy. Requests
+// without an Origin header don't come from a browser and are let through.
+func checkOrigin(policy *origin.Policy) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		o := r.Header.Get("Origin")
+		if o == "" || origin.SameOrigin(r, o) || policy.Allowed(o) {
+			return true
+		}
+
+		rejectOrigin(r, "websocket", o)
+		return false
+	}
+}
+
+// allowOrigin returns the CORS middleware's origin check for policy.
+func allowOrigin(policy *origin.Policy) func(r *http.Request, o string) bool {
+	return func(r *http.Request, o string) bool {
+		if origin.SameOrigin(r, o) || policy.Allowed(o) {
+			return true
+		}
+
+		rejectOrigin(r, "cors", o)
+		return false
+	}
+}
+
+// skipWebSocket applies middleware to every request but WebSocket upgrades,
+// whose origin the upgrader checks: CORS doesn't apply to them, and a
+// rejected origin would be logged and counted twice.
+func skipWebSocket(middleware func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := middleware(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if websocket.IsWebSocketUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		})
+	}
+}
 
+func rejectOrigin(r *http.Request, surface, o string) {
+	metrics.OriginRejections.WithLabelValues(surface).Inc()
+	logging.FromContext(r.Context()).Warn("origin rejected", "surface", surface, "origin", o, "path", r.URL.Path)
 }
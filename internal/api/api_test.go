@@ -0,0 +1,398 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/api/openapi"
+	"github.com/thiagoleet/go-ama-api/internal/api/usecases"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore/pgstoretest"
+	"github.com/thiagoleet/go-ama-api/internal/webhooks"
+)
+
+// fixture is a handler served over a store seeded with one room, message,
+// webhook and delivery.
+type fixture struct {
+	server    *httptest.Server
+	q         *pgstore.Queries
+	roomID    uuid.UUID
+	messageID uuid.UUID
+	hostToken string
+	webhookID uuid.UUID
+	delivery  uuid.UUID
+}
+
+func newFixture(t *testing.T, opts ...Option) *fixture {
+	t.Helper()
+
+	ctx := context.Background()
+	db := pgstoretest.Open(t)
+	q := pgstore.New(db)
+
+	h := NewHandler(ctx, q, append([]Option{WithTransactions(db, 1)}, opts...)...)
+	server := httptest.NewServer(h)
+	t.Cleanup(func() {
+		server.Close()
+		_ = h.Shutdown(ctx)
+	})
+
+	f := &fixture{server: server, q: q}
+
+	var err error
+	if f.roomID, err = q.InsertRoom(ctx, "Release planning"); err != nil {
+		t.Fatal(err)
+	}
+
+	f.messageID, err = q.InsertMessage(ctx, pgstore.InsertMessageParams{RoomID: f.roomID, Message: "When is the next release?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := usecases.NewRotateHostTokenUseCase(q, ctx).Execute(f.roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.hostToken = token.HostToken
+
+	webhook, err := usecases.NewCreateWebhookUseCase(q, nil, ctx).Execute(f.roomID, usecases.CreateWebhookInput{
+		URL:   "https://hooks.example.com/wsrs",
+		Kinds: []string{entity.MessageKindMessageCreated},
+	}, f.hostToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.webhookID = uuid.MustParse(webhook.ID)
+
+	created := entity.Message{
+		Kind:   entity.MessageKindMessageCreated,
+		RoomId: f.roomID.String(),
+		Value:  entity.MessageMessageCreated{ID: f.messageID.String(), Message: "When is the next release?"},
+	}
+	if err := webhooks.Enqueue(ctx, q, []entity.Message{created.Stamped()}); err != nil {
+		t.Fatal(err)
+	}
+
+	deliveries, err := q.ListWebhookDeliveries(ctx, pgstore.ListWebhookDeliveriesParams{WebhookID: f.webhookID, Limit: 1})
+	if err != nil || len(deliveries) == 0 {
+		t.Fatalf("seeding delivery: %v", err)
+	}
+	f.delivery = deliveries[0].ID
+
+	return f
+}
+
+func (f *fixture) do(t *testing.T, method, path, body string, header http.Header) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, f.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := f.server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+// TestResponsesMatchSpec sends requests to every route of the handler and
+// checks each response against the OpenAPI document.
+func TestResponsesMatchSpec(t *testing.T) {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFixture(t)
+
+	room := "/api/rooms/" + f.roomID.String()
+	message := "/api/rooms/" + f.messageID.String()
+	webhook := room + "/webhooks/" + f.webhookID.String()
+	host := http.Header{"X-Host-Token": {f.hostToken}}
+	missing := uuid.NewString()
+
+	tests := []struct {
+		route  string
+		method string
+		path   string
+		body   string
+		header http.Header
+		status int
+	}{
+		{"/healthz", http.MethodGet, "/healthz", "", nil, http.StatusOK},
+		{"/readyz", http.MethodGet, "/readyz", "", nil, http.StatusOK},
+		{"/version", http.MethodGet, "/version", "", nil, http.StatusOK},
+		{"/metrics", http.MethodGet, "/metrics", "", nil, http.StatusOK},
+		{"/subscribe/{room_id}", http.MethodGet, "/subscribe/" + f.roomID.String(), "", nil, http.StatusBadRequest},
+		{"/subscribe/{room_id}", http.MethodGet, "/subscribe/" + missing, "", nil, http.StatusNotFound},
+		{"/subscribe/{room_id}", http.MethodGet, "/subscribe/nope", "", nil, http.StatusBadRequest},
+		{"/api/openapi.json", http.MethodGet, "/api/openapi.json", "", nil, http.StatusOK},
+		{"/api/docs", http.MethodGet, "/api/docs", "", nil, http.StatusOK},
+
+		{"/api/rooms", http.MethodPost, "/api/rooms", `{"theme":"Roadmap"}`, nil, http.StatusOK},
+		{"/api/rooms", http.MethodPost, "/api/rooms", `{`, nil, http.StatusBadRequest},
+		{"/api/rooms", http.MethodGet, "/api/rooms", "", nil, http.StatusOK},
+		{"/api/rooms/{room_id}/info", http.MethodGet, room + "/info", "", nil, http.StatusOK},
+		{"/api/rooms/{room_id}/info", http.MethodGet, "/api/rooms/" + missing + "/info", "", nil, http.StatusNotFound},
+		{"/api/rooms/{room_id}/info", http.MethodPatch, room + "/info", `{"theme":"Release 2.0"}`, host, http.StatusOK},
+		{"/api/rooms/{room_id}/info", http.MethodPatch, room + "/info", `{"theme":"Release 3.0"}`, nil, http.StatusForbidden},
+		{"/api/rooms/{room_id}/info", http.MethodPatch, room + "/info", `{"theme":"Release 3.0"}`, http.Header{"X-Host-Token": {f.hostToken}, "If-Match": {`"1"`}}, http.StatusPreconditionFailed},
+
+		{"/api/rooms/{room_id}/messages", http.MethodGet, room + "/messages", "", nil, http.StatusOK},
+		{"/api/rooms/{room_id}/messages", http.MethodGet, "/api/rooms/nope/messages", "", nil, http.StatusBadRequest},
+		{"/api/rooms/{room_id}/messages", http.MethodPost, room + "/messages", `{"message":"Will it support SSO?"}`, nil, http.StatusOK},
+		{"/api/rooms/{room_id}/messages", http.MethodPost, "/api/rooms/" + missing + "/messages", `{"message":"Anyone?"}`, nil, http.StatusNotFound},
+
+		{"/api/rooms/{message_id}", http.MethodGet, message, "", nil, http.StatusOK},
+		{"/api/rooms/{message_id}", http.MethodGet, "/api/rooms/" + missing, "", nil, http.StatusNotFound},
+		{"/api/rooms/{message_id}", http.MethodGet, "/api/rooms/nope", "", nil, http.StatusBadRequest},
+		{"/api/rooms/{message_id}/react", http.MethodPatch, message + "/react", "", nil, http.StatusOK},
+		{"/api/rooms/{message_id}/react", http.MethodPatch, "/api/rooms/" + missing + "/react", "", nil, http.StatusNotFound},
+		{"/api/rooms/{message_id}/react", http.MethodDelete, message + "/react", "", nil, http.StatusOK},
+		{"/api/rooms/{message_id}/answer", http.MethodPatch, message + "/answer", "", nil, http.StatusOK},
+		{"/api/rooms/{message_id}/answer", http.MethodPatch, message + "/answer", "", http.Header{"If-Match": {`"1"`}}, http.StatusPreconditionFailed},
+
+		{"/api/rooms/{room_id}/webhooks", http.MethodGet, room + "/webhooks", "", host, http.StatusOK},
+		{"/api/rooms/{room_id}/webhooks", http.MethodGet, room + "/webhooks", "", nil, http.StatusForbidden},
+		{"/api/rooms/{room_id}/webhooks", http.MethodPost, room + "/webhooks", `{"url":"https://hooks.example.com/other","kinds":["message_answered"]}`, host, http.StatusOK},
+		{"/api/rooms/{room_id}/webhooks", http.MethodPost, room + "/webhooks", `{"url":"ftp://hooks.example.com","kinds":["message_answered"]}`, host, http.StatusBadRequest},
+		{"/api/rooms/{room_id}/webhooks", http.MethodPost, room + "/webhooks", `{"url":"http://169.254.169.254/latest/meta-data","kinds":["message_answered"]}`, host, http.StatusBadRequest},
+		{"/api/rooms/{room_id}/webhooks/{webhook_id}/deliveries", http.MethodGet, webhook + "/deliveries", "", host, http.StatusOK},
+		{"/api/rooms/{room_id}/webhooks/{webhook_id}/deliveries/{delivery_id}/redeliver", http.MethodPost, webhook + "/deliveries/" + f.delivery.String() + "/redeliver", "", host, http.StatusAccepted},
+		{"/api/rooms/{room_id}/webhooks/{webhook_id}/deliveries/{delivery_id}/redeliver", http.MethodPost, webhook + "/deliveries/" + missing + "/redeliver", "", host, http.StatusNotFound},
+		{"/api/rooms/{room_id}/webhooks/{webhook_id}", http.MethodDelete, webhook, "", host, http.StatusNoContent},
+		{"/api/rooms/{room_id}/webhooks/{webhook_id}", http.MethodDelete, webhook, "", host, http.StatusNotFound},
+	}
+
+	covered := map[string]bool{}
+	for _, tt := range tests {
+		covered[tt.method+" "+tt.route] = true
+
+		resp := f.do(t, tt.method, tt.path, tt.body, tt.header)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, resp.StatusCode, tt.status, body)
+			continue
+		}
+
+		if err := spec.ValidateResponse(tt.method, tt.route, resp.StatusCode, resp.Header.Get("Content-Type"), body); err != nil {
+			t.Errorf("%s %s: %v", tt.method, tt.path, err)
+		}
+	}
+
+	// The upgrade itself, which the client can't send through http.Client.
+	ws, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(f.server.URL, "http")+"/subscribe/"+f.roomID.String(), nil)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	_ = ws.Close()
+	if err := spec.ValidateResponse(http.MethodGet, "/subscribe/{room_id}", resp.StatusCode, resp.Header.Get("Content-Type"), nil); err != nil {
+		t.Errorf("subscribe: %v", err)
+	}
+
+	h := NewHandler(context.Background(), pgstore.New(pgstoretest.Open(t)))
+	defer h.Shutdown(context.Background())
+
+	_ = chi.Walk(h.(apiHandler).r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if len(route) > 1 {
+			route = strings.TrimSuffix(route, "/")
+		}
+		if !covered[method+" "+route] {
+			t.Errorf("%s %s is not exercised", method, route)
+		}
+		return nil
+	})
+}
+
+func TestGetRoomMessage(t *testing.T) {
+	f := newFixture(t)
+
+	resp := f.do(t, http.MethodGet, "/api/rooms/"+f.messageID.String(), "", nil)
+	defer resp.Body.Close()
+
+	var message entity.MessageDTO
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+		t.Fatal(err)
+	}
+
+	if message.ID != f.messageID.String() || message.RoomID != f.roomID.String() {
+		t.Errorf("got message %s of room %s, want %s of room %s", message.ID, message.RoomID, f.messageID, f.roomID)
+	}
+	if got := resp.Header.Get("ETag"); !strings.HasPrefix(got, `W/"`) {
+		t.Errorf("ETag = %s, want a weak tag of the content", got)
+	}
+}
+
+func TestGetResponsesAreTaggedByContent(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		path   string
+		change func() error
+	}{
+		{"/api/rooms/" + f.roomID.String() + "/info", func() error {
+			_, err := f.q.UpdateRoom(ctx, pgstore.UpdateRoomParams{ID: f.roomID, Theme: pgtype.Text{String: "Release 3.0", Valid: true}})
+			return err
+		}},
+		{"/api/rooms/" + f.messageID.String(), func() error {
+			_, err := f.q.ReactToMessage(ctx, pgstore.ReactToMessageParams{ID: f.messageID})
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		resp := f.do(t, http.MethodGet, tt.path, "", nil)
+		resp.Body.Close()
+		tag := resp.Header.Get("ETag")
+
+		resp = f.do(t, http.MethodGet, tt.path, "", http.Header{"If-None-Match": {tag}})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotModified {
+			t.Errorf("%s: %d for an unchanged resource, want %d", tt.path, resp.StatusCode, http.StatusNotModified)
+		}
+
+		if err := tt.change(); err != nil {
+			t.Fatal(err)
+		}
+
+		resp = f.do(t, http.MethodGet, tt.path, "", http.Header{"If-None-Match": {tag}})
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == tag {
+			t.Errorf("%s: %d with ETag %s after a change, want %d with a new tag", tt.path, resp.StatusCode, resp.Header.Get("ETag"), http.StatusOK)
+		}
+	}
+}
+
+func TestIfMatchTakesVersions(t *testing.T) {
+	f := newFixture(t)
+	path := "/api/rooms/" + f.messageID.String()
+
+	resp := f.do(t, http.MethodGet, path, "", nil)
+	var message entity.MessageDTO
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp = f.do(t, http.MethodPatch, path+"/answer", "", http.Header{"If-Match": {resp.Header.Get("ETag")}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("answer with the ETag of GET: %d, want %d", resp.StatusCode, http.StatusPreconditionFailed)
+	}
+
+	resp = f.do(t, http.MethodPatch, path+"/answer", "", http.Header{"If-Match": {etag(message.Version)}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("answer with the version: %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got, want := resp.Header.Get("ETag"), etag(message.Version+1); got != want {
+		t.Errorf("ETag after the update = %s, want %s", got, want)
+	}
+}
+
+func TestConcurrentReactions(t *testing.T) {
+	// The fixture runs each unit of work once: a transaction failing to
+	// serialize would surface as a 500.
+	f := newFixture(t)
+	path := "/api/rooms/" + f.messageID.String()
+
+	const reactions = 20
+	changes := []string{path + "/answer"}
+	for i := 0; i < reactions; i++ {
+		changes = append(changes, path+"/react")
+	}
+
+	var wg sync.WaitGroup
+	for _, change := range changes {
+		wg.Add(1)
+		go func(change string) {
+			defer wg.Done()
+
+			req, _ := http.NewRequest(http.MethodPatch, f.server.URL+change, nil)
+			resp, err := f.server.Client().Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("concurrent PATCH %s answered %d, want %d", change, resp.StatusCode, http.StatusOK)
+			}
+		}(change)
+	}
+	wg.Wait()
+
+	message, err := f.q.GetMessage(context.Background(), f.messageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.ReactionsCount != reactions || !message.Answered {
+		t.Errorf("message has %d reactions, answered %t, want %d reactions, answered", message.ReactionsCount, message.Answered, reactions)
+	}
+}
+
+func TestHiddenMessagesAreRedacted(t *testing.T) {
+	f := newFixture(t)
+	ctx := context.Background()
+
+	if _, err := f.q.SetMessageHidden(ctx, pgstore.SetMessageHiddenParams{ID: f.messageID, Hidden: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := f.do(t, http.MethodGet, "/api/rooms/"+f.roomID.String()+"/messages", "", nil)
+	var list usecases.GetRoomMessagesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(list.Messages) != 1 || list.Messages[0].ID != f.messageID.String() {
+		t.Fatalf("listed %+v, want the hidden message", list.Messages)
+	}
+	if m := list.Messages[0]; !m.Hidden || m.Message != "" {
+		t.Errorf("listed hidden message %+v, want it flagged with its text blanked", m)
+	}
+
+	resp = f.do(t, http.MethodGet, "/api/rooms/"+f.messageID.String(), "", nil)
+	var message entity.MessageDTO
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if !message.Hidden || message.Message != "" {
+		t.Errorf("got hidden message %+v, want it flagged with its text blanked", message)
+	}
+
+	export, err := usecases.NewExportRoomUseCase(f.q, ctx).Execute(f.roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Messages) != 1 || export.Messages[0].Message == "" || export.Hidden != 1 {
+		t.Errorf("exported %+v, want the hidden message with its text", export.Messages)
+	}
+}
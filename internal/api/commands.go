@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/api/usecases"
+	"github.com/thiagoleet/go-ama-api/internal/hub"
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type commandError struct {
+	code    string
+	message string
+}
+
+func (e *commandError) Error() string {
+	return e.message
+}
+
+// readCommands reads client commands from the room socket until the
+// connection is closed, replying to each one with an ack or an error frame.
+func (h apiHandler) readCommands(ctx context.Context, cancel context.CancelFunc, c *websocket.Conn, client *hub.Client, roomID uuid.UUID) {
+	defer cancel()
+
+	for {
+		_, data, err := c.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logging.FromContext(ctx).Warn("failed to read from client", "error", err)
+			}
+			return
+		}
+
+		var cmd entity.Command
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			writeToClient(ctx, client, commandErrorMessage("", &commandError{entity.ErrorCodeInvalidCommand, "invalid json"}))
+			continue
+		}
+
+		result, err := h.executeCommand(ctx, roomID, cmd)
+		if err != nil {
+			writeToClient(ctx, client, commandErrorMessage(cmd.ID, err))
+			continue
+		}
+
+		writeToClient(ctx, client, entity.Message{
+			Kind:  entity.MessageKindAck,
+			Value: entity.MessageAck{ID: cmd.ID, Result: result},
+		})
+	}
+}
+
+func writeToClient(ctx context.Context, client *hub.Client, msg entity.Message) {
+	if err := client.Send(msg.Stamped()); err != nil {
+		logging.FromContext(ctx).Error("failed to send message to client", "error", err)
+	}
+}
+
+func commandErrorMessage(id string, err error) entity.Message {
+	var cmdErr *commandError
+	if !errors.As(err, &cmdErr) {
+		cmdErr = &commandError{entity.ErrorCodeInternal, "something went wrong"}
+	}
+
+	return entity.Message{
+		Kind: entity.MessageKindError,
+		Value: entity.MessageError{
+			ID:      id,
+			Code:    cmdErr.code,
+			Message: cmdErr.message,
+		},
+	}
+}
+
+func (h apiHandler) executeCommand(ctx context.Context, roomID uuid.UUID, cmd entity.Command) (any, error) {
+	ctx, span := tracing.Tracer().Start(ctx, "ws.command "+cmd.Type, trace.WithAttributes(
+		tracing.RoomID(roomID.String()),
+		attribute.String("command_id", cmd.ID),
+	))
+	defer span.End()
+
+	if cmd.Version != entity.ProtocolVersion {
+		return nil, &commandError{entity.ErrorCodeUnsupportedVersion, "unsupported protocol version"}
+	}
+
+	switch cmd.Type {
+	case entity.CommandCreateMessage:
+		return h.commandCreateMessage(ctx, roomID, cmd)
+	case entity.CommandReact:
+		return h.commandReact(ctx, roomID, cmd)
+	case entity.CommandUnreact:
+		return h.commandUnreact(ctx, roomID, cmd)
+	case entity.CommandAnswer:
+		return h.commandAnswer(ctx, roomID, cmd)
+	default:
+		return nil, &commandError{entity.ErrorCodeUnknownCommand, "unknown command"}
+	}
+}
+
+func (h apiHandler) commandCreateMessage(ctx context.Context, roomID uuid.UUID, cmd entity.Command) (any, error) {
+	var payload entity.CommandCreateMessagePayload
+	if err := json.Unmarshal(cmd.Payload, &payload); err != nil {
+		return nil, &commandError{entity.ErrorCodeInvalidPayload, "invalid payload"}
+	}
+
+	response, err := usecases.NewCreateRoomMessageUseCase(h.work, ctx).Execute(
+		usecases.CreateRoomMessageInput{Message: payload.Message},
+		roomID,
+	)
+
+	if err != nil {
+		return nil, useCaseError(ctx, err, "room not found")
+	}
+
+	return response, nil
+}
+
+func (h apiHandler) commandReact(ctx context.Context, roomID uuid.UUID, cmd entity.Command) (any, error) {
+	messageID, err := commandMessageID(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := usecases.NewReactToMessageUseCase(h.work, h.counter, ctx).InRoom(roomID).Execute(messageID, nil)
+
+	if err != nil {
+		return nil, useCaseError(ctx, err, "message not found")
+	}
+
+	return response, nil
+}
+
+func (h apiHandler) commandUnreact(ctx context.Context, roomID uuid.UUID, cmd entity.Command) (any, error) {
+	messageID, err := commandMessageID(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := usecases.NewRemoveReactFromMessageUseCase(h.work, h.counter, ctx).InRoom(roomID).Execute(messageID, nil)
+
+	if err != nil {
+		return nil, useCaseError(ctx, err, "message not found")
+	}
+
+	return response, nil
+}
+
+func (h apiHandler) commandAnswer(ctx context.Context, roomID uuid.UUID, cmd entity.Command) (any, error) {
+	messageID, err := commandMessageID(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := usecases.NewAnswerMessageUseCase(h.work, ctx).InRoom(roomID).Execute(messageID, nil)
+
+	if err != nil {
+		return nil, useCaseError(ctx, err, "message not found")
+	}
+
+	return response, nil
+}
+
+// commandMessageID returns the message a command acts on. Sockets can only
+// act on the messages of the room they subscribed to: the use cases are
+// restricted to it, so messages of other rooms are reported as not found.
+func commandMessageID(cmd entity.Command) (uuid.UUID, error) {
+	var payload entity.CommandMessagePayload
+	if err := json.Unmarshal(cmd.Payload, &payload); err != nil {
+		return uuid.Nil, &commandError{entity.ErrorCodeInvalidPayload, "invalid payload"}
+	}
+
+	messageID, err := uuid.Parse(payload.MessageID)
+	if err != nil {
+		return uuid.Nil, &commandError{entity.ErrorCodeInvalidPayload, "invalid message id"}
+	}
+
+	return messageID, nil
+}
+
+func useCaseError(ctx context.Context, err error, notFound string) error {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return &commandError{entity.ErrorCodeNotFound, notFound}
+	case errors.Is(err, usecases.ErrRoomClosed):
+		return &commandError{entity.ErrorCodeRoomClosed, "room is closed"}
+	case errors.Is(err, usecases.ErrInvalidHostToken):
+		return &commandError{entity.ErrorCodeForbidden, "invalid host token"}
+	}
+
+	logging.FromContext(ctx).Error("failed to execute command", "error", err)
+	return err
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+func (f *fixture) dial(t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(f.server.URL, "http")+"/subscribe/"+f.roomID.String(), nil)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+// reply sends frame and returns the ack or error frame answering it,
+// skipping the room events sent in between.
+func reply(t *testing.T, conn *websocket.Conn, frame string) entity.Message {
+	t.Helper()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for the reply to %s: %v", frame, err)
+		}
+
+		msg, err := entity.Events.Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Kind == entity.MessageKindAck || msg.Kind == entity.MessageKindError {
+			return msg
+		}
+	}
+}
+
+func ack(t *testing.T, msg entity.Message, id string) map[string]any {
+	t.Helper()
+
+	v, ok := entity.Payload[entity.MessageAck](msg)
+	if !ok {
+		t.Fatalf("got %s %+v, want an ack", msg.Kind, msg.Value)
+	}
+	if v.ID != id {
+		t.Errorf("ack for %q, want %q", v.ID, id)
+	}
+
+	result, _ := v.Result.(map[string]any)
+	return result
+}
+
+func TestCommandsAck(t *testing.T) {
+	f := newFixture(t)
+	conn := f.dial(t)
+	message := f.messageID.String()
+
+	created := ack(t, reply(t, conn, `{"v":1,"id":"c1","type":"create_message","payload":{"message":"Will it support SSO?"}}`), "c1")
+	if _, err := uuid.Parse(created["id"].(string)); err != nil {
+		t.Errorf("create_message result %v, want the new message id", created)
+	}
+
+	reacted := ack(t, reply(t, conn, `{"v":1,"id":"c2","type":"react","payload":{"message_id":"`+message+`"}}`), "c2")
+	if reacted["reactions_count"] != 1.0 || reacted["message_id"] != message {
+		t.Errorf("react result %v, want a count of 1 for %s", reacted, message)
+	}
+
+	unreacted := ack(t, reply(t, conn, `{"v":1,"id":"c3","type":"unreact","payload":{"message_id":"`+message+`"}}`), "c3")
+	if unreacted["reactions_count"] != 0.0 {
+		t.Errorf("unreact result %v, want a count of 0", unreacted)
+	}
+
+	answered := ack(t, reply(t, conn, `{"v":1,"id":"c4","type":"answer","payload":{"message_id":"`+message+`"}}`), "c4")
+	if answered["message_id"] != message {
+		t.Errorf("answer result %v, want %s", answered, message)
+	}
+
+	stored, err := f.q.GetMessage(context.Background(), f.messageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Answered || stored.ReactionsCount != 0 {
+		t.Errorf("stored message answered %t with %d reactions, want answered with 0", stored.Answered, stored.ReactionsCount)
+	}
+}
+
+func TestCommandsErrors(t *testing.T) {
+	f := newFixture(t)
+	conn := f.dial(t)
+	ctx := context.Background()
+
+	otherRoom, err := f.q.InsertRoom(ctx, "Another room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := f.q.InsertMessage(ctx, pgstore.InsertMessageParams{RoomID: otherRoom, Message: "Not yours"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		frame string
+		id    string
+		code  string
+	}{
+		{"invalid json", `{"v":1,"id":"e1"`, "", entity.ErrorCodeInvalidCommand},
+		{"unsupported version", `{"v":2,"id":"e2","type":"react","payload":{}}`, "e2", entity.ErrorCodeUnsupportedVersion},
+		{"unknown command", `{"v":1,"id":"e3","type":"delete_room","payload":{}}`, "e3", entity.ErrorCodeUnknownCommand},
+		{"malformed payload", `{"v":1,"id":"e4","type":"create_message","payload":"When?"}`, "e4", entity.ErrorCodeInvalidPayload},
+		{"invalid message id", `{"v":1,"id":"e5","type":"react","payload":{"message_id":"nope"}}`, "e5", entity.ErrorCodeInvalidPayload},
+		{"missing message", `{"v":1,"id":"e6","type":"answer","payload":{"message_id":"` + uuid.NewString() + `"}}`, "e6", entity.ErrorCodeNotFound},
+		{"message of another room", `{"v":1,"id":"e7","type":"react","payload":{"message_id":"` + other.String() + `"}}`, "e7", entity.ErrorCodeNotFound},
+		{"answer in another room", `{"v":1,"id":"e8","type":"answer","payload":{"message_id":"` + other.String() + `"}}`, "e8", entity.ErrorCodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := reply(t, conn, tt.frame)
+
+			v, ok := entity.Payload[entity.MessageError](msg)
+			if !ok {
+				t.Fatalf("got %s %+v, want an error", msg.Kind, msg.Value)
+			}
+			if v.ID != tt.id || v.Code != tt.code {
+				t.Errorf("error %q for %q, want %q for %q", v.Code, v.ID, tt.code, tt.id)
+			}
+		})
+	}
+
+	stored, err := f.q.GetMessage(ctx, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ReactionsCount != 0 || stored.Answered {
+		t.Errorf("message of another room changed: %d reactions, answered %t", stored.ReactionsCount, stored.Answered)
+	}
+
+	// The socket is still usable after errors.
+	ack(t, reply(t, conn, `{"v":1,"id":"ok","type":"react","payload":{"message_id":"`+f.messageID.String()+`"}}`), "ok")
+}
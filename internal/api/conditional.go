@@ -0,0 +1,77 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag formats a row version as an entity tag.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatch returns the versions listed in If-Match, for a conditional
+// update. It returns nil, matching any version, when the header is absent or
+// "*". Weak and unknown tags are skipped, so a header listing only those
+// gives an empty list that matches nothing.
+func ifMatch(r *http.Request) []int64 {
+	tags := entityTags(r, "If-Match")
+	if tags == nil {
+		return nil
+	}
+
+	versions := []int64{}
+	for _, tag := range tags {
+		if tag == "*" {
+			return nil
+		}
+
+		version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+		if err != nil || !strings.HasPrefix(tag, `"`) {
+			continue
+		}
+		versions = append(versions, version)
+	}
+
+	return versions
+}
+
+// writeCacheable writes data as JSON with an ETag derived from it. Polling
+// clients that send the same tag back in If-None-Match get a 304 instead.
+func writeCacheable(w http.ResponseWriter, r *http.Request, data []byte) {
+	sum := sha256.Sum256(data)
+	tag := `W/"` + hex.EncodeToString(sum[:8]) + `"`
+
+	w.Header().Set("ETag", tag)
+
+	for _, t := range entityTags(r, "If-None-Match") {
+		if t == "*" || strings.TrimPrefix(t, "W/") == strings.TrimPrefix(tag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func entityTags(r *http.Request, header string) []string {
+	values := r.Header.Values(header)
+	if len(values) == 0 {
+		return nil
+	}
+
+	tags := []string{}
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return tags
+}
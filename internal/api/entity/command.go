@@ -0,0 +1,58 @@
+package entity
+
+import "encoding/json"
+
+// ProtocolVersion is the version of the command protocol spoken over
+// /subscribe/{room_id}. Clients must send it in the "v" field of every command.
+const ProtocolVersion = 1
+
+const (
+	CommandCreateMessage = "create_message"
+	CommandReact         = "react"
+	CommandUnreact       = "unreact"
+	CommandAnswer        = "answer"
+)
+
+const (
+	MessageKindAck   = "ack"
+	MessageKindError = "error"
+)
+
+const (
+	ErrorCodeInvalidCommand     = "invalid_command"
+	ErrorCodeUnsupportedVersion = "unsupported_version"
+	ErrorCodeUnknownCommand     = "unknown_command"
+	ErrorCodeInvalidPayload     = "invalid_payload"
+	ErrorCodeNotFound           = "not_found"
+	ErrorCodeRoomClosed         = "room_closed"
+	ErrorCodeForbidden          = "forbidden"
+	ErrorCodeInternal           = "internal_error"
+)
+
+// Command is a frame sent by a client over the room socket. ID is chosen by
+// the client and echoed back in the matching ack or error frame.
+type Command struct {
+	Version int             `json:"v"`
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type CommandCreateMessagePayload struct {
+	Message string `json:"message"`
+}
+
+type CommandMessagePayload struct {
+	MessageID string `json:"message_id"`
+}
+
+type MessageAck struct {
+	ID     string `json:"id"`
+	Result any    `json:"result,omitempty"`
+}
+
+type MessageError struct {
+	ID      string `json:"id"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
@@ -1,18 +1,55 @@
 package entity
 
-import "github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
 
 const (
-	MessageKindMessageCreated        = "message_created"
-	MessageKindMessageReactAdded     = "message_react_added"
-	MessageKindMessageReactedRemoved = "message_react_removed"
-	MessageKindMessageAnswered       = "message_answered"
+	MessageKindMessageCreated      = "message_created"
+	MessageKindMessageReactAdded   = "message_react_added"
+	MessageKindMessageReactRemoved = "message_react_removed"
+	MessageKindMessageAnswered     = "message_answered"
+	MessageKindPresenceChanged     = "presence_changed"
+	MessageKindReactionsBatch      = "reactions_batch"
+	MessageKindMessageHidden       = "message_hidden"
+	MessageKindMessageDeleted      = "message_deleted"
+
+	// Deprecated: use MessageKindMessageReactRemoved.
+	MessageKindMessageReactedRemoved = MessageKindMessageReactRemoved
 )
 
+// MessageSchemaVersion is the version of the envelope and payloads sent to
+// subscribers. It changes when a payload changes incompatibly.
+const MessageSchemaVersion = 1
+
+// Message is the envelope of every frame sent to subscribers. Value holds
+// the payload registered for Kind in Events.
 type Message struct {
-	Kind   string `json:"kind"`
-	Value  any    `json:"value"`
-	RoomId string `json:"-"`
+	Version int       `json:"v"`
+	ID      string    `json:"id"`
+	RoomId  string    `json:"room_id,omitempty"`
+	Time    time.Time `json:"ts"`
+	Kind    string    `json:"kind"`
+	Value   any       `json:"value"`
+}
+
+// Stamped returns m with the schema version, a new event ID and the current
+// time set, unless they already are.
+func (m Message) Stamped() Message {
+	if m.Version == 0 {
+		m.Version = MessageSchemaVersion
+	}
+	if m.ID == "" {
+		m.ID = uuid.NewString()
+	}
+	if m.Time.IsZero() {
+		m.Time = time.Now().UTC()
+	}
+
+	return m
 }
 
 type MessageMessageCreated struct {
@@ -34,9 +71,42 @@ type MessageMessageAnswered struct {
 	ID string `json:"id"`
 }
 
-type RoomDTO struct {
+// MessageMessageHidden is sent when a moderator hides a message, or shows
+// it again.
+type MessageMessageHidden struct {
+	ID     string `json:"id"`
+	Hidden bool   `json:"hidden"`
+}
+
+type MessageMessageDeleted struct {
+	ID string `json:"id"`
+}
+
+type MessageReactionCount struct {
 	ID    string `json:"id"`
-	Theme string `json:"theme"`
+	Count int64  `json:"count"`
+}
+
+type MessageReactionsBatch struct {
+	Reactions []MessageReactionCount `json:"reactions"`
+}
+
+type MessagePresenceChanged struct {
+	Count int64 `json:"count"`
+}
+
+// Room statuses. Closed and archived rooms accept no new messages.
+const (
+	RoomStatusOpen     = "open"
+	RoomStatusClosed   = "closed"
+	RoomStatusArchived = "archived"
+)
+
+type RoomDTO struct {
+	ID      string `json:"id"`
+	Theme   string `json:"theme"`
+	Status  string `json:"status"`
+	Version int64  `json:"version"`
 }
 
 func MapToRoomsDTO(rooms []pgstore.Room) []RoomDTO {
@@ -55,8 +125,10 @@ func MapToRoomsDTO(rooms []pgstore.Room) []RoomDTO {
 
 func RoomToDTO(room pgstore.Room) RoomDTO {
 	roomDTO := RoomDTO{
-		ID:    room.ID.String(),
-		Theme: room.Theme,
+		ID:      room.ID.String(),
+		Theme:   room.Theme,
+		Status:  room.Status,
+		Version: room.Version,
 	}
 
 	return roomDTO
@@ -68,6 +140,8 @@ type MessageDTO struct {
 	Message        string `json:"message"`
 	ReactionsCount int64  `json:"reactions_count"`
 	Answered       bool   `json:"answered"`
+	Hidden         bool   `json:"hidden,omitempty"`
+	Version        int64  `json:"version"`
 }
 
 func MessageToDTO(message pgstore.Message) MessageDTO {
@@ -77,6 +151,8 @@ func MessageToDTO(message pgstore.Message) MessageDTO {
 		Message:        message.Message,
 		ReactionsCount: message.ReactionsCount,
 		Answered:       message.Answered,
+		Hidden:         message.Hidden,
+		Version:        message.Version,
 	}
 }
 
@@ -0,0 +1,146 @@
+package entity
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// RawPayload is the value of messages whose kind is not registered, so
+// clients keep working when the server adds new kinds.
+type RawPayload json.RawMessage
+
+func (p RawPayload) MarshalJSON() ([]byte, error) {
+	if p == nil {
+		return []byte("null"), nil
+	}
+
+	return p, nil
+}
+
+// Registry maps message kinds to their payload types.
+type Registry struct {
+	mu       sync.RWMutex
+	decoders map[string]func(json.RawMessage) (any, error)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		decoders: make(map[string]func(json.RawMessage) (any, error)),
+	}
+}
+
+// Register makes payloads of kind decode into T.
+func Register[T any](r *Registry, kind string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.decoders[kind] = func(data json.RawMessage) (any, error) {
+		var v T
+		err := json.Unmarshal(data, &v)
+		return v, err
+	}
+}
+
+// Known reports whether kind has a registered payload type.
+func (r *Registry) Known(kind string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, ok := r.decoders[kind]
+	return ok
+}
+
+// Kinds returns the registered kinds, sorted.
+func (r *Registry) Kinds() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	kinds := make([]string, 0, len(r.decoders))
+	for kind := range r.decoders {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	return kinds
+}
+
+// Decode parses a message and its payload. The value of unknown kinds is a
+// RawPayload.
+func (r *Registry) Decode(data []byte) (Message, error) {
+	var frame struct {
+		Version int             `json:"v"`
+		ID      string          `json:"id"`
+		RoomId  string          `json:"room_id"`
+		Time    json.RawMessage `json:"ts"`
+		Kind    string          `json:"kind"`
+		Value   json.RawMessage `json:"value"`
+	}
+
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{
+		Version: frame.Version,
+		ID:      frame.ID,
+		RoomId:  frame.RoomId,
+		Kind:    frame.Kind,
+	}
+
+	if len(frame.Time) > 0 {
+		if err := json.Unmarshal(frame.Time, &msg.Time); err != nil {
+			return Message{}, err
+		}
+	}
+
+	r.mu.RLock()
+	decode, ok := r.decoders[frame.Kind]
+	r.mu.RUnlock()
+
+	if !ok {
+		msg.Value = RawPayload(frame.Value)
+		return msg, nil
+	}
+
+	value, err := decode(frame.Value)
+	if err != nil {
+		return Message{}, err
+	}
+	msg.Value = value
+
+	return msg, nil
+}
+
+// Events is the registry of every kind sent by the server. Message
+// unmarshals with it.
+var Events = NewRegistry()
+
+func init() {
+	Register[MessageMessageCreated](Events, MessageKindMessageCreated)
+	Register[MessageMessageReactAdded](Events, MessageKindMessageReactAdded)
+	Register[MessageMessageReactRemoved](Events, MessageKindMessageReactRemoved)
+	Register[MessageMessageAnswered](Events, MessageKindMessageAnswered)
+	Register[MessagePresenceChanged](Events, MessageKindPresenceChanged)
+	Register[MessageReactionsBatch](Events, MessageKindReactionsBatch)
+	Register[MessageMessageHidden](Events, MessageKindMessageHidden)
+	Register[MessageMessageDeleted](Events, MessageKindMessageDeleted)
+	Register[MessageAck](Events, MessageKindAck)
+	Register[MessageError](Events, MessageKindError)
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	msg, err := Events.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	*m = msg
+	return nil
+}
+
+// Payload returns the value of m as a T.
+func Payload[T any](m Message) (T, bool) {
+	v, ok := m.Value.(T)
+	return v, ok
+}
@@ -0,0 +1,92 @@
+package entity
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventsRoundTrip(t *testing.T) {
+	values := map[string]any{
+		MessageKindMessageCreated:      MessageMessageCreated{ID: "m1", Message: "When is the next release?"},
+		MessageKindMessageReactAdded:   MessageMessageReactAdded{ID: "m1", Count: 3},
+		MessageKindMessageReactRemoved: MessageMessageReactRemoved{ID: "m1", Count: 2},
+		MessageKindMessageAnswered:     MessageMessageAnswered{ID: "m1"},
+		MessageKindPresenceChanged:     MessagePresenceChanged{Count: 12},
+		MessageKindReactionsBatch: MessageReactionsBatch{Reactions: []MessageReactionCount{
+			{ID: "m1", Count: 3},
+			{ID: "m2", Count: 0},
+		}},
+		MessageKindMessageHidden:  MessageMessageHidden{ID: "m1", Hidden: true},
+		MessageKindMessageDeleted: MessageMessageDeleted{ID: "m1"},
+		MessageKindAck:            MessageAck{ID: "c1", Result: map[string]any{"id": "m3"}},
+		MessageKindError:          MessageError{ID: "c1", Code: ErrorCodeNotFound, Message: "message not found"},
+	}
+
+	for _, kind := range Events.Kinds() {
+		if _, ok := values[kind]; !ok {
+			t.Errorf("registered kind %s has no test value", kind)
+		}
+	}
+
+	for kind, value := range values {
+		t.Run(kind, func(t *testing.T) {
+			want := Message{
+				Version: MessageSchemaVersion,
+				ID:      "e1",
+				RoomId:  "r1",
+				Time:    time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+				Kind:    kind,
+				Value:   value,
+			}
+
+			data, err := json.Marshal(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Events.Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Decode(%s)\n got %#v\nwant %#v", data, got, want)
+			}
+		})
+	}
+}
+
+func TestEventsDecodeUnknownKind(t *testing.T) {
+	data := []byte(`{"v":1,"id":"e1","room_id":"r1","ts":"2026-10-19T12:00:00Z","kind":"poll_opened","value":{"id":"p1","options":["yes","no"]}}`)
+
+	msg, err := Events.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, ok := Payload[RawPayload](msg)
+	if !ok {
+		t.Fatalf("value is %T, want RawPayload", msg.Value)
+	}
+	if want := `{"id":"p1","options":["yes","no"]}`; string(raw) != want {
+		t.Errorf("raw payload = %s, want %s", raw, want)
+	}
+
+	// Unknown kinds are forwarded unchanged.
+	again, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("re-encoded as %s, want %s", again, data)
+	}
+}
+
+func TestEventsDecodeInvalidPayload(t *testing.T) {
+	_, err := Events.Decode([]byte(`{"v":1,"kind":"message_created","value":{"id":1}}`))
+	if err == nil {
+		t.Error("Decode() accepted a payload of the wrong type")
+	}
+}
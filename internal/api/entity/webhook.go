@@ -0,0 +1,106 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+// WebhookKinds are the kinds of events webhooks can subscribe to.
+var WebhookKinds = []string{
+	MessageKindMessageCreated,
+	MessageKindMessageReactAdded,
+	MessageKindMessageReactRemoved,
+	MessageKindMessageAnswered,
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+type WebhookDTO struct {
+	ID        string    `json:"id"`
+	RoomID    string    `json:"room_id"`
+	URL       string    `json:"url"`
+	Kinds     []string  `json:"kinds"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func WebhookToDTO(webhook pgstore.Webhook) WebhookDTO {
+	return WebhookDTO{
+		ID:        webhook.ID.String(),
+		RoomID:    webhook.RoomID.String(),
+		URL:       webhook.Url,
+		Kinds:     webhook.Kinds,
+		CreatedAt: webhook.CreatedAt.Time,
+	}
+}
+
+func MapToWebhooksDTO(webhooks []pgstore.Webhook) []WebhookDTO {
+	dtoList := make([]WebhookDTO, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		dtoList = append(dtoList, WebhookToDTO(webhook))
+	}
+
+	return dtoList
+}
+
+type WebhookDeliveryDTO struct {
+	ID        string          `json:"id"`
+	WebhookID string          `json:"webhook_id"`
+	EventID   string          `json:"event_id"`
+	Kind      string          `json:"kind"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+	Attempts  int32           `json:"attempts"`
+	// NextAttemptAt is only set while the delivery is pending.
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int32     `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	// RedeliveryOf is the delivery a manual redelivery repeats.
+	RedeliveryOf string    `json:"redelivery_of,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func WebhookDeliveryToDTO(delivery pgstore.WebhookDelivery) WebhookDeliveryDTO {
+	dto := WebhookDeliveryDTO{
+		ID:        delivery.ID.String(),
+		WebhookID: delivery.WebhookID.String(),
+		EventID:   delivery.EventID,
+		Kind:      delivery.Kind,
+		Payload:   delivery.Payload,
+		Status:    delivery.Status,
+		Attempts:  delivery.Attempts,
+		LastError: delivery.LastError,
+		CreatedAt: delivery.CreatedAt.Time,
+		UpdatedAt: delivery.UpdatedAt.Time,
+	}
+
+	if delivery.Status == WebhookDeliveryPending {
+		dto.NextAttemptAt = &delivery.NextAttemptAt.Time
+	}
+
+	if delivery.LastStatusCode.Valid {
+		dto.LastStatusCode = &delivery.LastStatusCode.Int32
+	}
+
+	if delivery.RedeliveryOf.Valid {
+		dto.RedeliveryOf = uuid.UUID(delivery.RedeliveryOf.Bytes).String()
+	}
+
+	return dto
+}
+
+func MapToWebhookDeliveriesDTO(deliveries []pgstore.WebhookDelivery) []WebhookDeliveryDTO {
+	dtoList := make([]WebhookDeliveryDTO, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		dtoList = append(dtoList, WebhookDeliveryToDTO(delivery))
+	}
+
+	return dtoList
+}
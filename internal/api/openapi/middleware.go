@@ -0,0 +1,51 @@
+package openapi
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
+)
+
+// Middleware checks every response against spec and logs, and counts in
+// wsrs_openapi_violations_total, the ones that drifted from it. Bodies are
+// buffered, so it is meant for development and staging.
+func Middleware(spec *Spec) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var body bytes.Buffer
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ww.Tee(&body)
+
+			next.ServeHTTP(ww, r)
+
+			route := chi.RouteContext(r.Context()).RoutePattern()
+			if route == "" {
+				return
+			}
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			err := spec.ValidateResponse(r.Method, route, status, ww.Header().Get("Content-Type"), body.Bytes())
+			if err != nil {
+				metrics.SpecViolations.WithLabelValues(r.Method, route).Inc()
+				logging.FromContext(r.Context()).Warn("response does not match the openapi document",
+					logging.ComponentKey, "openapi",
+					"status", status,
+					"error", err,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// Package openapi serves the OpenAPI document of the API and can check
+// responses against it.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+)
+
+//go:embed openapi.json
+var document []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+// Document returns the OpenAPI 3 document as JSON.
+func Document() []byte {
+	return document
+}
+
+func HandleDocument(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(document)
+}
+
+// HandleDocs serves a page that renders the document without fetching
+// anything but /api/openapi.json, so it works offline.
+func HandleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(docsPage)
+}
+
+// Spec is a parsed OpenAPI document.
+type Spec struct {
+	paths   map[string]any
+	schemas map[string]any
+}
+
+// Load parses the embedded document.
+func Load() (*Spec, error) {
+	var doc struct {
+		Paths      map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, err
+	}
+
+	return &Spec{paths: doc.Paths, schemas: doc.Components.Schemas}, nil
+}
@@ -0,0 +1,232 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// ValidateResponse checks that a response to method on the chi route
+// pattern is documented and, for JSON bodies, matches its schema. Only the
+// subset of JSON Schema used by the document is supported.
+func (s *Spec) ValidateResponse(method, route string, status int, contentType string, body []byte) error {
+	op, ok := s.operation(method, route)
+	if !ok {
+		return fmt.Errorf("%s %s is not documented", method, route)
+	}
+
+	responses, _ := op["responses"].(map[string]any)
+	response, ok := responses[strconv.Itoa(status)].(map[string]any)
+	if !ok {
+		response, ok = responses["default"].(map[string]any)
+	}
+	if !ok {
+		return fmt.Errorf("status %d is not documented", status)
+	}
+
+	content, _ := response["content"].(map[string]any)
+	if len(content) == 0 {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	media, ok := content[mediaType].(map[string]any)
+	if !ok {
+		return fmt.Errorf("content type %q is not documented for status %d", contentType, status)
+	}
+
+	schema, _ := media["schema"].(map[string]any)
+	if mediaType != "application/json" || schema == nil {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("invalid JSON body: %w", err)
+	}
+
+	return s.validate(schema, v, "$")
+}
+
+// Undocumented returns the routes registered in r that have no operation
+// in the document, as "METHOD pattern".
+func (s *Spec) Undocumented(r chi.Routes) []string {
+	var missing []string
+
+	_ = chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if _, ok := s.operation(method, route); !ok {
+			missing = append(missing, method+" "+route)
+		}
+		return nil
+	})
+
+	sort.Strings(missing)
+	return missing
+}
+
+func (s *Spec) operation(method, route string) (map[string]any, bool) {
+	if len(route) > 1 {
+		route = strings.TrimSuffix(route, "/")
+	}
+
+	item, ok := s.paths[route].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+
+	op, ok := item[strings.ToLower(method)].(map[string]any)
+	return op, ok
+}
+
+func (s *Spec) validate(schema map[string]any, v any, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, ok := s.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, ref)
+		}
+		return s.validate(resolved, v, path)
+	}
+
+	if v == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable || len(schema) == 0 {
+			return nil
+		}
+		if _, typed := schema["type"]; typed {
+			return fmt.Errorf("%s: unexpected null", path)
+		}
+	}
+
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		matches := 0
+		for _, candidate := range oneOf {
+			if sub, ok := candidate.(map[string]any); ok && s.validate(sub, v, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: matches %d of the oneOf schemas, want 1", path, matches)
+		}
+		return nil
+	}
+
+	if enum, ok := schema["enum"].([]any); ok && !inEnum(enum, v) {
+		return fmt.Errorf("%s: %v is not one of %v", path, v, enum)
+	}
+
+	switch schema["type"] {
+	case "object":
+		return s.validateObject(schema, v, path)
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected array", path)
+		}
+		itemSchema, _ := schema["items"].(map[string]any)
+		for i, item := range items {
+			if err := s.validate(itemSchema, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected string", path)
+		}
+		if schema["format"] == "uuid" {
+			if _, err := uuid.Parse(str); err != nil {
+				return fmt.Errorf("%s: %q is not a uuid", path, str)
+			}
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s: expected integer", path)
+		}
+		if _, err := n.Int64(); err != nil {
+			return fmt.Errorf("%s: %s is not an integer", path, n)
+		}
+	case "number":
+		if _, ok := v.(json.Number); !ok {
+			return fmt.Errorf("%s: expected number", path)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean", path)
+		}
+	}
+
+	return nil
+}
+
+func (s *Spec) validateObject(schema map[string]any, v any, path string) error {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%s: expected object", path)
+	}
+
+	required, _ := schema["required"].([]any)
+	for _, name := range required {
+		if _, ok := obj[name.(string)]; !ok {
+			return fmt.Errorf("%s: missing required property %q", path, name)
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		propertyPath := path + "." + key
+
+		if property, ok := properties[key].(map[string]any); ok {
+			if err := s.validate(property, obj[key], propertyPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: property is not documented", propertyPath)
+			}
+		case map[string]any:
+			if err := s.validate(additional, obj[key], propertyPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func inEnum(enum []any, v any) bool {
+	for _, value := range enum {
+		if n, ok := v.(json.Number); ok {
+			if f, err := n.Float64(); err == nil && value == f {
+				return true
+			}
+			continue
+		}
+		if value == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"compress/flate"
+	"time"
+
+	"github.com/thiagoleet/go-ama-api/internal/api/usecases"
+	"github.com/thiagoleet/go-ama-api/internal/health"
+	"github.com/thiagoleet/go-ama-api/internal/hub"
+	"github.com/thiagoleet/go-ama-api/internal/idempotency"
+	"github.com/thiagoleet/go-ama-api/internal/origin"
+	"github.com/thiagoleet/go-ama-api/internal/outbox"
+	"github.com/thiagoleet/go-ama-api/internal/presence"
+	"github.com/thiagoleet/go-ama-api/internal/webhooks"
+)
+
+type options struct {
+	corsOrigins          *origin.Policy
+	wsOrigins            *origin.Policy
+	compression          bool
+	compressionLevel     int
+	compressionThreshold int
+	readLimit            int64
+	writeTimeout         time.Duration
+	readBufferSize       int
+	writeBufferSize      int
+	reactionWindow       time.Duration
+	reactionFlush        time.Duration
+	health               *health.Health
+	validate             bool
+	idempotencyTTL       time.Duration
+	txBeginner           usecases.TxBeginner
+	txAttempts           int
+	instance             string
+	outbox               outbox.Options
+	webhooks             webhooks.Options
+}
+
+func defaultOptions() options {
+	return options{
+		corsOrigins:      origin.MustParse("*"),
+		wsOrigins:        origin.MustParse("*"),
+		compressionLevel: flate.BestSpeed,
+		reactionWindow:   hub.DefaultReactionWindow,
+		health:           health.New(),
+		idempotencyTTL:   idempotency.DefaultTTL,
+		txAttempts:       usecases.DefaultTxAttempts,
+		instance:         presence.NewInstanceID(),
+	}
+}
+
+type Option func(*options)
+
+// WithCORSOrigins sets the origins allowed by the CORS middleware.
+func WithCORSOrigins(policy *origin.Policy) Option {
+	return func(o *options) {
+		o.corsOrigins = policy
+	}
+}
+
+// WithWebSocketOrigins sets the origins allowed to subscribe to a room. An
+// empty policy only allows same-origin requests.
+func WithWebSocketOrigins(policy *origin.Policy) Option {
+	return func(o *options) {
+		o.wsOrigins = policy
+	}
+}
+
+// WithCompression negotiates permessage-deflate with WebSocket subscribers.
+func WithCompression(enabled bool) Option {
+	return func(o *options) {
+		o.compression = enabled
+	}
+}
+
+// WithCompressionLevel sets the flate level, from -2 (Huffman only) to 9,
+// used when compression is enabled.
+func WithCompressionLevel(level int) Option {
+	return func(o *options) {
+		o.compressionLevel = level
+	}
+}
+
+// WithCompressionThreshold only compresses frames whose payload is at least
+// size bytes.
+func WithCompressionThreshold(size int) Option {
+	return func(o *options) {
+		o.compressionThreshold = size
+	}
+}
+
+// WithReadLimit closes subscriptions that send a frame larger than limit
+// bytes. Zero means no limit.
+func WithReadLimit(limit int64) Option {
+	return func(o *options) {
+		o.readLimit = limit
+	}
+}
+
+// WithWriteTimeout drops subscribers that take longer than timeout to accept
+// a frame. Zero disables the deadline.
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.writeTimeout = timeout
+	}
+}
+
+// WithBufferSizes sets the I/O buffer sizes of WebSocket connections. Zero
+// reuses the buffers allocated by the HTTP server.
+func WithBufferSizes(read, write int) Option {
+	return func(o *options) {
+		o.readBufferSize = read
+		o.writeBufferSize = write
+	}
+}
+
+// WithReactionWindow sets how long reaction updates of a busy room are
+// coalesced before being broadcast. Zero broadcasts every update.
+func WithReactionWindow(window time.Duration) Option {
+	return func(o *options) {
+		o.reactionWindow = window
+	}
+}
+
+// WithWriteBehindReactions buffers reaction counts in memory and writes them
+// to the database every interval. Zero updates the row on every reaction.
+// Buffered counts are only seen by this instance, so it must be the only one
+// serving the database. Reaction events are raised when the counts are
+// written, one per message with its latest count.
+func WithWriteBehindReactions(interval time.Duration) Option {
+	return func(o *options) {
+		o.reactionFlush = interval
+	}
+}
+
+// WithHealth serves /healthz, /readyz and /version from h. The handler adds
+// a check reporting the realtime hub status.
+func WithHealth(h *health.Health) Option {
+	return func(o *options) {
+		o.health = h
+	}
+}
+
+// WithResponseValidation checks every response against the OpenAPI document
+// and logs the ones that drifted from it.
+func WithResponseValidation(enabled bool) Option {
+	return func(o *options) {
+		o.validate = enabled
+	}
+}
+
+// WithIdempotencyTTL sets how long responses to requests carrying an
+// Idempotency-Key header are kept for replay. Zero ignores the header.
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.idempotencyTTL = ttl
+	}
+}
+
+// WithTransactions runs use cases that check and then write, such as posting
+// or answering a message, in a transaction begun on db, at the isolation
+// level each of them needs. Each is attempted up to maxAttempts times when Postgres reports a serialization
+// failure or a deadlock. Their events go through the outbox, which is then
+// delivered to subscribers of every instance.
+func WithTransactions(db usecases.TxBeginner, maxAttempts int) Option {
+	return func(o *options) {
+		o.txBeginner = db
+		o.txAttempts = maxAttempts
+	}
+}
+
+// WithInstance names the outbox cursor of this instance. An instance
+// restarted under the same name resumes delivering events where it left off.
+// Names must be unique among running instances.
+func WithInstance(name string) Option {
+	return func(o *options) {
+		o.instance = name
+	}
+}
+
+// WithOutbox tunes how outbox events are polled and cleaned up.
+func WithOutbox(opts outbox.Options) Option {
+	return func(o *options) {
+		o.outbox = opts
+	}
+}
+
+// WithWebhooks tunes how webhook deliveries are sent, retried and kept.
+// Webhooks need WithTransactions, as deliveries are queued from the outbox.
+func WithWebhooks(opts webhooks.Options) Option {
+	return func(o *options) {
+		o.webhooks = opts
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
+	"github.com/thiagoleet/go-ama-api/internal/origin"
+)
+
+// logBuffer collects the records written by the default logger.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records returns the records logged with msg.
+func (b *logBuffer) records(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record["msg"] == msg {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+// captureLogs sends the default logger to a buffer for the rest of the test.
+func captureLogs(t *testing.T) *logBuffer {
+	b := &logBuffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(b, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	return b
+}
+
+// rejections returns the number of origins rejected on surface.
+func rejections(t *testing.T, surface string) float64 {
+	t.Helper()
+
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "wsrs_origin_rejections_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "surface" && label.GetValue() == surface {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	return 0
+}
+
+func TestRejectedOriginsAreLoggedAndCounted(t *testing.T) {
+	policy := origin.MustParse("https://app.example.com")
+	f := newFixture(t, WithCORSOrigins(policy), WithWebSocketOrigins(policy))
+	logs := captureLogs(t)
+
+	cors, ws := rejections(t, "cors"), rejections(t, "websocket")
+	room := "/api/rooms/" + f.roomID.String() + "/info"
+
+	resp := f.do(t, http.MethodGet, room, "", http.Header{"Origin": {"https://app.example.com"}})
+	resp.Body.Close()
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("allowed origin got Access-Control-Allow-Origin %q", got)
+	}
+
+	resp = f.do(t, http.MethodGet, room, "", http.Header{"Origin": {"https://evil.example.com"}})
+	resp.Body.Close()
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("rejected origin got Access-Control-Allow-Origin %q", got)
+	}
+
+	url := "ws" + strings.TrimPrefix(f.server.URL, "http") + "/subscribe/" + f.roomID.String()
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example.com"}})
+	if err == nil {
+		t.Fatal("subscribed from a rejected origin")
+	}
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("subscribe from a rejected origin: %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	if got := rejections(t, "cors") - cors; got != 1 {
+		t.Errorf("counted %v cors rejections, want 1", got)
+	}
+	if got := rejections(t, "websocket") - ws; got != 1 {
+		t.Errorf("counted %v websocket rejections, want 1", got)
+	}
+
+	records := logs.records(t, "origin rejected")
+	if len(records) != 2 {
+		t.Fatalf("logged %d rejections, want 2", len(records))
+	}
+	for i, surface := range []string{"cors", "websocket"} {
+		if r := records[i]; r["surface"] != surface || r["origin"] != "https://evil.example.com" || r["level"] != "WARN" {
+			t.Errorf("logged %v, want a warning for the %s rejection", r, surface)
+		}
+	}
+}
@@ -4,41 +4,98 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
 	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type AnswerMessageUseCase struct {
-	q   *pgstore.Queries
-	ctx context.Context
+	work   *UnitOfWork
+	roomID uuid.UUID
+	ctx    context.Context
 }
 
 type AnswerMessageUseCaseResponse struct {
 	MessageID string `json:"message_id"`
+	RoomID    string `json:"room_id"`
+	Version   int64  `json:"version"`
 }
 
-func NewAnswerMessageUseCase(queries *pgstore.Queries, context context.Context) *AnswerMessageUseCase {
+func NewAnswerMessageUseCase(work *UnitOfWork, context context.Context) *AnswerMessageUseCase {
 	return &AnswerMessageUseCase{
-		q:   queries,
-		ctx: context,
+		work: work,
+		ctx:  context,
 	}
 }
 
-func (u *AnswerMessageUseCase) Execute(messageID uuid.UUID) (*AnswerMessageUseCaseResponse, error) {
-	_, err := u.q.GetMessage(u.ctx, messageID)
+// InRoom restricts the use case to the messages of roomID. Messages of
+// other rooms are reported as pgx.ErrNoRows.
+func (u *AnswerMessageUseCase) InRoom(roomID uuid.UUID) *AnswerMessageUseCase {
+	u.roomID = roomID
+	return u
+}
 
-	if err != nil {
-		return nil, err
-	}
+// Execute marks the message as answered and notifies the room once the
+// change committed. Unless versions is nil, the message must be at one of them or
+// ErrVersionMismatch is returned.
+func (u *AnswerMessageUseCase) Execute(messageID uuid.UUID, versions []int64) (*AnswerMessageUseCaseResponse, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.AnswerMessage.Execute", trace.WithAttributes(tracing.MessageID(messageID.String())))
+	defer span.End()
+
+	var response AnswerMessageUseCaseResponse
+
+	// The message is locked until the answer commits, which orders it with
+	// concurrent reactions without a serializable transaction.
+	err := u.work.Do(ctx, pgx.ReadCommitted, func(w *Work) error {
+		message, err := w.Q.GetMessageForUpdate(ctx, messageID)
+
+		if err != nil {
+			return err
+		}
 
-	err = u.q.MarkMessageAsAnswered(u.ctx, messageID)
+		if !inRoom(message, u.roomID) {
+			return pgx.ErrNoRows
+		}
+
+		if !versionMatches(message.Version, versions) {
+			return ErrVersionMismatch
+		}
+
+		version, err := w.Q.MarkMessageAsAnswered(ctx, pgstore.MarkMessageAsAnsweredParams{
+			ID:       messageID,
+			Versions: versions,
+		})
+
+		if err != nil {
+			return conditional(err)
+		}
+
+		response = AnswerMessageUseCaseResponse{
+			MessageID: messageID.String(),
+			RoomID:    message.RoomID.String(),
+			Version:   version,
+		}
+
+		w.Publish(entity.Message{
+			Kind:   entity.MessageKindMessageAnswered,
+			RoomId: response.RoomID,
+			Value: entity.MessageMessageAnswered{
+				ID: response.MessageID,
+			},
+		})
+
+		return nil
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	response := AnswerMessageUseCaseResponse{
-		MessageID: messageID.String(),
-	}
+	metrics.DomainEvents.WithLabelValues(metrics.EventMessageAnswered).Inc()
+	logger(ctx).Debug("message answered", "message_id", messageID.String())
 
 	return &response, nil
 }
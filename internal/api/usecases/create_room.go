@@ -3,7 +3,9 @@ package usecases
 import (
 	"context"
 
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
 	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
 )
 
 type CreateRoomInput struct {
@@ -27,12 +29,17 @@ func NewCreateRoomUseCase(queries *pgstore.Queries, context context.Context) *Cr
 }
 
 func (u *CreateRoomUseCase) Execute(payload CreateRoomInput) (response *CreateRoomResponse, err error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.CreateRoom.Execute")
+	defer span.End()
 
-	roomID, err := u.q.InsertRoom(u.ctx, payload.Theme)
+	roomID, err := u.q.InsertRoom(ctx, payload.Theme)
 	if err != nil {
 		return nil, err
 	}
 
+	metrics.DomainEvents.WithLabelValues(metrics.EventRoomCreated).Inc()
+	logger(ctx).Debug("room created", "room_id", roomID.String())
+
 	data := CreateRoomResponse{ID: roomID.String()}
 
 	return &data, nil
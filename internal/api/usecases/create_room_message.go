@@ -2,14 +2,23 @@ package usecases
 
 import (
 	"context"
+	"errors"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
 	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrRoomClosed is returned when a message is sent to a room that is not open.
+var ErrRoomClosed = errors.New("room is closed")
+
 type CreateRoomMessageUseCase struct {
-	q   *pgstore.Queries
-	ctx context.Context
+	work *UnitOfWork
+	ctx  context.Context
 }
 
 type CreateRoomMessageResponse struct {
@@ -20,31 +29,60 @@ type CreateRoomMessageInput struct {
 	Message string `json:"message"`
 }
 
-func NewCreateRoomMessageUseCase(queries *pgstore.Queries, context context.Context) *CreateRoomMessageUseCase {
+func NewCreateRoomMessageUseCase(work *UnitOfWork, context context.Context) *CreateRoomMessageUseCase {
 	return &CreateRoomMessageUseCase{
-		q:   queries,
-		ctx: context,
+		work: work,
+		ctx:  context,
 	}
 }
 
+// Execute posts a message to an open room and notifies the room once it
+// committed.
 func (u *CreateRoomMessageUseCase) Execute(input CreateRoomMessageInput, roomID uuid.UUID) (*CreateRoomMessageResponse, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.CreateRoomMessage.Execute", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
 
-	_, err := NewGetRoomByIdUseCase(u.q, u.ctx).Execute(roomID)
+	var messageID uuid.UUID
 
-	if err != nil {
-		return nil, err
-	}
+	err := u.work.Do(ctx, pgx.Serializable, func(w *Work) error {
+		room, err := w.Q.GetRoom(ctx, roomID)
+
+		if err != nil {
+			return err
+		}
+
+		if room.Status != entity.RoomStatusOpen {
+			return ErrRoomClosed
+		}
 
-	messageID, err := u.q.InsertMessage(u.ctx, pgstore.InsertMessageParams{
-		RoomID:  roomID,
-		Message: input.Message,
+		messageID, err = w.Q.InsertMessage(ctx, pgstore.InsertMessageParams{
+			RoomID:  roomID,
+			Message: input.Message,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		w.Publish(entity.Message{
+			Kind:   entity.MessageKindMessageCreated,
+			RoomId: roomID.String(),
+			Value: entity.MessageMessageCreated{
+				ID:      messageID.String(),
+				Message: input.Message,
+			},
+		})
+
+		return nil
 	})
 
 	if err != nil {
-
 		return nil, err
 	}
 
+	metrics.DomainEvents.WithLabelValues(metrics.EventQuestionCreated).Inc()
+	logger(ctx).Debug("message created", "room_id", roomID.String(), "message_id", messageID.String())
+
 	response := CreateRoomMessageResponse{
 		ID: messageID.String(),
 	}
@@ -0,0 +1,127 @@
+package usecases
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"github.com/thiagoleet/go-ama-api/internal/webhooks"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrInvalidWebhook is returned when a webhook has an invalid URL or no
+// known event kinds.
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+type CreateWebhookUseCase struct {
+	q       *pgstore.Queries
+	allowed []netip.Prefix
+	ctx     context.Context
+}
+
+type CreateWebhookInput struct {
+	URL   string   `json:"url"`
+	Kinds []string `json:"kinds"`
+}
+
+type CreateWebhookResponse struct {
+	entity.WebhookDTO
+	// Secret signs the deliveries. It is only returned on creation.
+	Secret string `json:"secret"`
+}
+
+// NewCreateWebhookUseCase returns a use case refusing endpoints on private
+// addresses outside of allowed.
+func NewCreateWebhookUseCase(queries *pgstore.Queries, allowed []netip.Prefix, context context.Context) *CreateWebhookUseCase {
+	return &CreateWebhookUseCase{
+		q:       queries,
+		allowed: allowed,
+		ctx:     context,
+	}
+}
+
+// Execute registers a webhook receiving the room events of the given kinds.
+// Endpoints given by name are checked again by the sender once resolved.
+func (u *CreateWebhookUseCase) Execute(roomID uuid.UUID, input CreateWebhookInput, hostToken string) (*CreateWebhookResponse, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.CreateWebhook.Execute", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
+
+	endpoint, err := url.Parse(input.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+
+	if err := webhooks.CheckHost(endpoint.Hostname(), u.allowed); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidWebhook, err)
+	}
+
+	var kinds []string
+	for _, kind := range input.Kinds {
+		if !slices.Contains(entity.WebhookKinds, kind) {
+			return nil, fmt.Errorf("%w: unknown event kind %q", ErrInvalidWebhook, kind)
+		}
+		if !slices.Contains(kinds, kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	if len(kinds) == 0 {
+		return nil, fmt.Errorf("%w: kinds must not be empty", ErrInvalidWebhook)
+	}
+
+	if err := checkWebhookHost(ctx, u.q, roomID, hostToken); err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	secret := hex.EncodeToString(raw)
+
+	webhook, err := u.q.InsertWebhook(ctx, pgstore.InsertWebhookParams{
+		RoomID: roomID,
+		Url:    endpoint.String(),
+		Secret: secret,
+		Kinds:  kinds,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger(ctx).Info("webhook created", "room_id", roomID.String(), "webhook_id", webhook.ID.String())
+
+	response := CreateWebhookResponse{
+		WebhookDTO: entity.WebhookToDTO(webhook),
+		Secret:     secret,
+	}
+
+	return &response, nil
+}
+
+// checkWebhookHost checks hostToken against the room. Unlike other host
+// actions, webhooks require the room to have a host token, since they send
+// its activity to any URL.
+func checkWebhookHost(ctx context.Context, q *pgstore.Queries, roomID uuid.UUID, hostToken string) error {
+	room, err := q.GetRoom(ctx, roomID)
+
+	if err != nil {
+		return err
+	}
+
+	if room.HostTokenHash == nil {
+		return ErrInvalidHostToken
+	}
+
+	return checkHostToken(room, hostToken)
+}
@@ -0,0 +1,52 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type DeleteMessageUseCase struct {
+	work *UnitOfWork
+	ctx  context.Context
+}
+
+func NewDeleteMessageUseCase(work *UnitOfWork, context context.Context) *DeleteMessageUseCase {
+	return &DeleteMessageUseCase{
+		work: work,
+		ctx:  context,
+	}
+}
+
+func (u *DeleteMessageUseCase) Execute(messageID uuid.UUID) error {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.DeleteMessage.Execute", trace.WithAttributes(tracing.MessageID(messageID.String())))
+	defer span.End()
+
+	err := u.work.Do(ctx, pgx.Serializable, func(w *Work) error {
+		roomID, err := w.Q.DeleteMessage(ctx, messageID)
+
+		if err != nil {
+			return err
+		}
+
+		w.Publish(entity.Message{
+			Kind:   entity.MessageKindMessageDeleted,
+			RoomId: roomID.String(),
+			Value:  entity.MessageMessageDeleted{ID: messageID.String()},
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	logger(ctx).Info("message deleted", "message_id", messageID.String())
+
+	return nil
+}
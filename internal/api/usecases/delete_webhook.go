@@ -0,0 +1,51 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type DeleteWebhookUseCase struct {
+	q   *pgstore.Queries
+	ctx context.Context
+}
+
+func NewDeleteWebhookUseCase(queries *pgstore.Queries, context context.Context) *DeleteWebhookUseCase {
+	return &DeleteWebhookUseCase{
+		q:   queries,
+		ctx: context,
+	}
+}
+
+// Execute removes the webhook and its deliveries log. Pending deliveries are
+// dropped.
+func (u *DeleteWebhookUseCase) Execute(roomID, webhookID uuid.UUID, hostToken string) error {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.DeleteWebhook.Execute", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
+
+	if err := checkWebhookHost(ctx, u.q, roomID, hostToken); err != nil {
+		return err
+	}
+
+	rows, err := u.q.DeleteWebhook(ctx, pgstore.DeleteWebhookParams{
+		ID:     webhookID,
+		RoomID: roomID,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return pgx.ErrNoRows
+	}
+
+	logger(ctx).Info("webhook deleted", "room_id", roomID.String(), "webhook_id", webhookID.String())
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type ExportRoomUseCase struct {
+	q   *pgstore.Queries
+	ctx context.Context
+}
+
+type ExportRoomResponse struct {
+	Room         entity.RoomDTO      `json:"room"`
+	HasHostToken bool                `json:"has_host_token"`
+	Messages     []entity.MessageDTO `json:"messages"`
+	Answered     int                 `json:"answered"`
+	Hidden       int                 `json:"hidden"`
+	Reactions    int64               `json:"reactions"`
+	Participants int64               `json:"participants"`
+	ExportedAt   time.Time           `json:"exported_at"`
+}
+
+func NewExportRoomUseCase(queries *pgstore.Queries, context context.Context) *ExportRoomUseCase {
+	return &ExportRoomUseCase{
+		q:   queries,
+		ctx: context,
+	}
+}
+
+// Execute returns the room with every message, hidden ones included, and
+// totals over them.
+func (u *ExportRoomUseCase) Execute(roomID uuid.UUID) (*ExportRoomResponse, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.ExportRoom.Execute", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
+
+	room, err := u.q.GetRoom(ctx, roomID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := u.q.GetRoomMessages(ctx, roomID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	participants, err := u.q.CountRoomParticipants(ctx, roomID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := ExportRoomResponse{
+		Room:         entity.RoomToDTO(room),
+		HasHostToken: room.HostTokenHash != nil,
+		Messages:     entity.MapToMessagesDTO(messages),
+		Participants: participants,
+		ExportedAt:   time.Now().UTC(),
+	}
+
+	for _, message := range messages {
+		if message.Answered {
+			response.Answered++
+		}
+		if message.Hidden {
+			response.Hidden++
+		}
+		response.Reactions += message.ReactionsCount
+	}
+
+	return &response, nil
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/thiagoleet/go-ama-api/internal/api/entity"
 	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type GetRoomByIdUseCase struct {
@@ -14,7 +16,8 @@ type GetRoomByIdUseCase struct {
 }
 
 type GetRoomByIdResponse struct {
-	Room entity.RoomDTO `json:"room"`
+	Room     entity.RoomDTO `json:"room"`
+	Presence int64          `json:"presence"`
 }
 
 func NewGetRoomByIdUseCase(queries *pgstore.Queries, ctx context.Context) *GetRoomByIdUseCase {
@@ -25,14 +28,24 @@ func NewGetRoomByIdUseCase(queries *pgstore.Queries, ctx context.Context) *GetRo
 }
 
 func (u *GetRoomByIdUseCase) Execute(roomID uuid.UUID) (*GetRoomByIdResponse, error) {
-	room, err := u.q.GetRoom(u.ctx, roomID)
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.GetRoomById.Execute", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
+
+	room, err := u.q.GetRoom(ctx, roomID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	presence, err := u.q.CountRoomParticipants(ctx, roomID)
 
 	if err != nil {
 		return nil, err
 	}
 
 	response := GetRoomByIdResponse{
-		Room: entity.RoomToDTO(room),
+		Room:     entity.RoomToDTO(room),
+		Presence: presence,
 	}
 
 	return &response, nil
@@ -0,0 +1,62 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type GetRoomMessage struct {
+	q       *pgstore.Queries
+	counter ReactionCounter
+	ctx     context.Context
+}
+
+func NewGetRoomMessage(queries *pgstore.Queries, counter ReactionCounter, ctx context.Context) *GetRoomMessage {
+	return &GetRoomMessage{
+		q:       queries,
+		counter: counter,
+		ctx:     ctx,
+	}
+}
+
+func (u *GetRoomMessage) Execute(messageID uuid.UUID) (*entity.MessageDTO, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.GetRoomMessage.Execute", trace.WithAttributes(tracing.MessageID(messageID.String())))
+	defer span.End()
+
+	message, err := u.getMessage(ctx, messageID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := entity.MessageToDTO(message)
+
+	return &response, nil
+}
+
+func (u *GetRoomMessage) getMessage(ctx context.Context, messageID uuid.UUID) (pgstore.Message, error) {
+	var message pgstore.Message
+
+	err := view(u.counter, func() error {
+		var err error
+		message, err = u.q.GetMessage(ctx, messageID)
+
+		if err != nil {
+			return err
+		}
+
+		redact(&message)
+		if u.counter != nil {
+			message.ReactionsCount += u.counter.Pending(message.ID)
+		}
+
+		return nil
+	})
+
+	return message, err
+}
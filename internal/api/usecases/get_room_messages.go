@@ -6,11 +6,14 @@ import (
 	"github.com/google/uuid"
 	"github.com/thiagoleet/go-ama-api/internal/api/entity"
 	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type GetRoomMessages struct {
-	q   *pgstore.Queries
-	ctx context.Context
+	q       *pgstore.Queries
+	counter ReactionCounter
+	ctx     context.Context
 }
 
 type GetRoomMessagesResponse struct {
@@ -19,15 +22,19 @@ type GetRoomMessagesResponse struct {
 	Total    int64               `json:"total"`
 }
 
-func NewGetRoomMessages(queries *pgstore.Queries, ctx context.Context) *GetRoomMessages {
+func NewGetRoomMessages(queries *pgstore.Queries, counter ReactionCounter, ctx context.Context) *GetRoomMessages {
 	return &GetRoomMessages{
-		q:   queries,
-		ctx: ctx,
+		q:       queries,
+		counter: counter,
+		ctx:     ctx,
 	}
 }
 
 func (u *GetRoomMessages) Execute(roomID uuid.UUID) (*GetRoomMessagesResponse, error) {
-	messages, err := u.q.GetRoomMessages(u.ctx, roomID)
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.GetRoomMessages.Execute", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
+
+	messages, err := u.getRoomMessages(ctx, roomID)
 
 	if err != nil {
 		return nil, err
@@ -41,3 +48,45 @@ func (u *GetRoomMessages) Execute(roomID uuid.UUID) (*GetRoomMessagesResponse, e
 
 	return &response, nil
 }
+
+func (u *GetRoomMessages) getRoomMessages(ctx context.Context, roomID uuid.UUID) ([]pgstore.Message, error) {
+	var messages []pgstore.Message
+
+	err := view(u.counter, func() error {
+		var err error
+		messages, err = u.q.GetRoomMessages(ctx, roomID)
+
+		if err != nil {
+			return err
+		}
+
+		for i := range messages {
+			redact(&messages[i])
+			if u.counter != nil {
+				messages[i].ReactionsCount += u.counter.Pending(messages[i].ID)
+			}
+		}
+
+		return nil
+	})
+
+	return messages, err
+}
+
+// view runs fn inside counter.View, or directly without a counter.
+func view(counter ReactionCounter, fn func() error) error {
+	if counter == nil {
+		return fn()
+	}
+
+	return counter.View(fn)
+}
+
+// redact blanks the text of a hidden message, which only room exports
+// return. The message itself stays listed, flagged hidden, so clients
+// resuming a subscription can tell it from a deleted one.
+func redact(message *pgstore.Message) {
+	if message.Hidden {
+		message.Message = ""
+	}
+}
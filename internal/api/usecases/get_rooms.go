@@ -5,6 +5,7 @@ import (
 
 	"github.com/thiagoleet/go-ama-api/internal/api/entity"
 	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
 )
 
 type GetRoomsUseCase struct {
@@ -25,7 +26,10 @@ func NewGetRoomsUseCase(queries *pgstore.Queries, context context.Context) *GetR
 }
 
 func (u *GetRoomsUseCase) Execute() (*GetRoomsResponse, error) {
-	rooms, err := u.q.GetRooms(u.ctx)
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.GetRooms.Execute")
+	defer span.End()
+
+	rooms, err := u.q.GetRooms(ctx)
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,77 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+)
+
+type ListRoomsWithStatsUseCase struct {
+	q   *pgstore.Queries
+	ctx context.Context
+}
+
+type RoomStats struct {
+	ID       string `json:"id"`
+	Theme    string `json:"theme"`
+	Status   string `json:"status"`
+	Messages int64  `json:"messages"`
+	// Connections is how many sockets the hubs of every instance hold for
+	// the room. Each subscription is mirrored by a presence row, written
+	// when the hub registers it and removed when it leaves.
+	Connections int64 `json:"connections"`
+	Instances   int64 `json:"instances"`
+}
+
+type ListRoomsWithStatsResponse struct {
+	Rooms []RoomStats `json:"rooms"`
+	Total int         `json:"total"`
+}
+
+func NewListRoomsWithStatsUseCase(queries *pgstore.Queries, context context.Context) *ListRoomsWithStatsUseCase {
+	return &ListRoomsWithStatsUseCase{
+		q:   queries,
+		ctx: context,
+	}
+}
+
+// Execute lists every room with its message count and the connections
+// currently open on any instance.
+func (u *ListRoomsWithStatsUseCase) Execute() (*ListRoomsWithStatsResponse, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.ListRoomsWithStats.Execute")
+	defer span.End()
+
+	rooms, err := u.q.ListRoomsWithStats(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	presence, err := u.q.ListRoomPresence(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	byRoom := make(map[string]pgstore.ListRoomPresenceRow, len(presence))
+	for _, row := range presence {
+		byRoom[row.RoomID.String()] = row
+	}
+
+	response := ListRoomsWithStatsResponse{Rooms: []RoomStats{}}
+	for _, room := range rooms {
+		id := room.ID.String()
+		response.Rooms = append(response.Rooms, RoomStats{
+			ID:          id,
+			Theme:       room.Theme,
+			Status:      room.Status,
+			Messages:    room.Messages,
+			Connections: byRoom[id].Connections,
+			Instances:   byRoom[id].Instances,
+		})
+	}
+	response.Total = len(response.Rooms)
+
+	return &response, nil
+}
@@ -0,0 +1,64 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// webhookDeliveriesLimit is how many of the latest deliveries are listed.
+const webhookDeliveriesLimit = 100
+
+type ListWebhookDeliveriesUseCase struct {
+	q   *pgstore.Queries
+	ctx context.Context
+}
+
+type ListWebhookDeliveriesResponse struct {
+	Deliveries []entity.WebhookDeliveryDTO `json:"deliveries"`
+}
+
+func NewListWebhookDeliveriesUseCase(queries *pgstore.Queries, context context.Context) *ListWebhookDeliveriesUseCase {
+	return &ListWebhookDeliveriesUseCase{
+		q:   queries,
+		ctx: context,
+	}
+}
+
+// Execute returns the latest deliveries of the webhook, newest first.
+func (u *ListWebhookDeliveriesUseCase) Execute(roomID, webhookID uuid.UUID, hostToken string) (*ListWebhookDeliveriesResponse, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.ListWebhookDeliveries.Execute", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
+
+	if err := checkWebhookHost(ctx, u.q, roomID, hostToken); err != nil {
+		return nil, err
+	}
+
+	webhook, err := u.q.GetWebhook(ctx, pgstore.GetWebhookParams{
+		ID:     webhookID,
+		RoomID: roomID,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := u.q.ListWebhookDeliveries(ctx, pgstore.ListWebhookDeliveriesParams{
+		WebhookID: webhook.ID,
+		Limit:     webhookDeliveriesLimit,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := ListWebhookDeliveriesResponse{
+		Deliveries: entity.MapToWebhookDeliveriesDTO(deliveries),
+	}
+
+	return &response, nil
+}
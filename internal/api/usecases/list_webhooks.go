@@ -0,0 +1,48 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type ListWebhooksUseCase struct {
+	q   *pgstore.Queries
+	ctx context.Context
+}
+
+type ListWebhooksResponse struct {
+	Webhooks []entity.WebhookDTO `json:"webhooks"`
+}
+
+func NewListWebhooksUseCase(queries *pgstore.Queries, context context.Context) *ListWebhooksUseCase {
+	return &ListWebhooksUseCase{
+		q:   queries,
+		ctx: context,
+	}
+}
+
+func (u *ListWebhooksUseCase) Execute(roomID uuid.UUID, hostToken string) (*ListWebhooksResponse, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.ListWebhooks.Execute", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
+
+	if err := checkWebhookHost(ctx, u.q, roomID, hostToken); err != nil {
+		return nil, err
+	}
+
+	webhooks, err := u.q.ListRoomWebhooks(ctx, roomID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	response := ListWebhooksResponse{
+		Webhooks: entity.MapToWebhooksDTO(webhooks),
+	}
+
+	return &response, nil
+}
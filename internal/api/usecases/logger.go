@@ -0,0 +1,14 @@
+package usecases
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+)
+
+// logger returns the request logger carried by ctx, tagged as coming from
+// the use cases.
+func logger(ctx context.Context) *slog.Logger {
+	return logging.FromContext(ctx).With(logging.ComponentKey, "usecases")
+}
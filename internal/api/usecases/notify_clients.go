@@ -2,39 +2,35 @@ package usecases
 
 import (
 	"context"
-	"log/slog"
-	"sync"
 
-	"github.com/gorilla/websocket"
 	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/hub"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type NotifyClientsUseCase struct {
-	mu          *sync.Mutex
-	subscribers map[string]map[*websocket.Conn]context.CancelFunc
+	hub *hub.Hub
+	ctx context.Context
 }
 
-func NewNotifyClientsUseCase(mu *sync.Mutex,
-	subscribers map[string]map[*websocket.Conn]context.CancelFunc) *NotifyClientsUseCase {
+// NewNotifyClientsUseCase returns a use case broadcasting to the hub. Its
+// span starts a new trace linked to the span in ctx, since broadcasts
+// outlive the request that triggered them.
+func NewNotifyClientsUseCase(h *hub.Hub, ctx context.Context) *NotifyClientsUseCase {
 	return &NotifyClientsUseCase{
-		mu:          mu,
-		subscribers: subscribers,
+		hub: h,
+		ctx: ctx,
 	}
 }
 
 func (u *NotifyClientsUseCase) Execute(msg entity.Message) {
-	u.mu.Lock()
-	defer u.mu.Unlock()
+	_, span := tracing.Tracer().Start(context.Background(), "usecases.NotifyClients.Execute",
+		trace.WithNewRoot(),
+		trace.WithLinks(tracing.Links(u.ctx)...),
+		trace.WithAttributes(tracing.RoomID(msg.RoomId)),
+	)
+	defer span.End()
 
-	subscribers, ok := u.subscribers[msg.RoomId]
-	if !ok || len(subscribers) == 0 {
-		return
-	}
-
-	for conn, cancel := range subscribers {
-		if err := conn.WriteJSON(msg); err != nil {
-			slog.Error("failed to send message to client", "error", err)
-			cancel()
-		}
-	}
+	u.hub.Broadcast(msg)
 }
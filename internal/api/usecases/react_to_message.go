@@ -4,43 +4,160 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
 	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ReactToMessageUseCase struct {
-	q   *pgstore.Queries
-	ctx context.Context
+	work    *UnitOfWork
+	counter ReactionCounter
+	roomID  uuid.UUID
+	ctx     context.Context
 }
 
 type ReactToMessageUseCaseResponse struct {
 	ReactionsCount int64  `json:"reactions_count"`
 	MessageID      string `json:"message_id"`
+	RoomID         string `json:"room_id"`
 }
 
-func NewReactToMessageUseCase(queries *pgstore.Queries, context context.Context) *ReactToMessageUseCase {
+func (r ReactToMessageUseCaseResponse) addedEvent() entity.Message {
+	return entity.Message{
+		Kind:   entity.MessageKindMessageReactAdded,
+		RoomId: r.RoomID,
+		Value: entity.MessageMessageReactAdded{
+			ID:    r.MessageID,
+			Count: r.ReactionsCount,
+		},
+	}
+}
+
+func (r ReactToMessageUseCaseResponse) removedEvent() entity.Message {
+	return entity.Message{
+		Kind:   entity.MessageKindMessageReactRemoved,
+		RoomId: r.RoomID,
+		Value: entity.MessageMessageReactRemoved{
+			ID:    r.MessageID,
+			Count: r.ReactionsCount,
+		},
+	}
+}
+
+func NewReactToMessageUseCase(work *UnitOfWork, counter ReactionCounter, context context.Context) *ReactToMessageUseCase {
 	return &ReactToMessageUseCase{
-		q:   queries,
-		ctx: context,
+		work:    work,
+		counter: counter,
+		ctx:     context,
 	}
 }
 
-func (u *ReactToMessageUseCase) Execute(messageID uuid.UUID) (*ReactToMessageUseCaseResponse, error) {
-	_, err := u.q.GetMessage(u.ctx, messageID)
+// InRoom restricts the use case to the messages of roomID. Messages of
+// other rooms are reported as pgx.ErrNoRows.
+func (u *ReactToMessageUseCase) InRoom(roomID uuid.UUID) *ReactToMessageUseCase {
+	u.roomID = roomID
+	return u
+}
+
+// Execute updates the reaction count and notifies the room once the change
+// committed. Unless versions is nil, the message must be at one of them or
+// ErrVersionMismatch is returned.
+func (u *ReactToMessageUseCase) Execute(messageID uuid.UUID, versions []int64) (*ReactToMessageUseCaseResponse, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.ReactToMessage.Execute", trace.WithAttributes(tracing.MessageID(messageID.String())))
+	defer span.End()
+
+	if u.counter != nil {
+		return u.executeWriteBehind(ctx, messageID, versions)
+	}
+
+	var response ReactToMessageUseCaseResponse
+
+	// Locking the message serializes concurrent changes to it, so they
+	// don't need a serializable transaction, nor fail and retry under load.
+	err := u.work.Do(ctx, pgx.ReadCommitted, func(w *Work) error {
+		message, err := w.Q.GetMessageForUpdate(ctx, messageID)
+
+		if err != nil {
+			return err
+		}
+
+		if !inRoom(message, u.roomID) {
+			return pgx.ErrNoRows
+		}
+
+		if !versionMatches(message.Version, versions) {
+			return ErrVersionMismatch
+		}
+
+		reactions_count, err := w.Q.ReactToMessage(ctx, pgstore.ReactToMessageParams{
+			ID:       messageID,
+			Versions: versions,
+		})
+
+		if err != nil {
+			return conditional(err)
+		}
+
+		response = ReactToMessageUseCaseResponse{
+			ReactionsCount: reactions_count,
+			MessageID:      messageID.String(),
+			RoomID:         message.RoomID.String(),
+		}
+
+		w.Publish(response.addedEvent())
+
+		return nil
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	reactions_count, err := u.q.ReactToMessage(u.ctx, messageID)
+	metrics.DomainEvents.WithLabelValues(metrics.EventReactionAdded).Inc()
+	logger(ctx).Debug("reaction added", "message_id", messageID.String(), "write_behind", u.counter != nil)
+
+	return &response, nil
+}
+
+func (u *ReactToMessageUseCase) executeWriteBehind(ctx context.Context, messageID uuid.UUID, versions []int64) (*ReactToMessageUseCaseResponse, error) {
+	var response ReactToMessageUseCaseResponse
+
+	err := u.counter.View(func() error {
+		message, err := u.work.q.GetMessage(ctx, messageID)
+
+		if err != nil {
+			return err
+		}
+
+		if !inRoom(message, u.roomID) {
+			return pgx.ErrNoRows
+		}
+
+		if !versionMatches(message.Version, versions) {
+			return ErrVersionMismatch
+		}
+
+		response = ReactToMessageUseCaseResponse{
+			ReactionsCount: message.ReactionsCount + u.counter.Add(messageID, 1),
+			MessageID:      messageID.String(),
+			RoomID:         message.RoomID.String(),
+		}
+
+		return nil
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	response := ReactToMessageUseCaseResponse{
-		ReactionsCount: reactions_count,
-		MessageID:      messageID.String(),
-	}
+	// The event is raised by the next flush, along with the other changes
+	// to the count since the previous one.
+
+	metrics.DomainEvents.WithLabelValues(metrics.EventReactionAdded).Inc()
+	logger(ctx).Debug("reaction added", "message_id", messageID.String(), "write_behind", u.counter != nil)
 
 	return &response, nil
 }
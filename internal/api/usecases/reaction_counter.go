@@ -0,0 +1,12 @@
+package usecases
+
+import "github.com/google/uuid"
+
+// ReactionCounter buffers reaction deltas instead of updating
+// messages.reactions_count on every reaction. A nil ReactionCounter makes the
+// use cases update the row directly.
+type ReactionCounter interface {
+	View(fn func() error) error
+	Add(messageID uuid.UUID, delta int64) int64
+	Pending(messageID uuid.UUID) int64
+}
@@ -0,0 +1,58 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type RedeliverWebhookUseCase struct {
+	q   *pgstore.Queries
+	ctx context.Context
+}
+
+func NewRedeliverWebhookUseCase(queries *pgstore.Queries, context context.Context) *RedeliverWebhookUseCase {
+	return &RedeliverWebhookUseCase{
+		q:   queries,
+		ctx: context,
+	}
+}
+
+// Execute queues a new delivery of the same event, with its own attempts.
+// The original delivery is kept in the log.
+func (u *RedeliverWebhookUseCase) Execute(roomID, webhookID, deliveryID uuid.UUID, hostToken string) (*entity.WebhookDeliveryDTO, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.RedeliverWebhook.Execute", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
+
+	if err := checkWebhookHost(ctx, u.q, roomID, hostToken); err != nil {
+		return nil, err
+	}
+
+	webhook, err := u.q.GetWebhook(ctx, pgstore.GetWebhookParams{
+		ID:     webhookID,
+		RoomID: roomID,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := u.q.RedeliverWebhookDelivery(ctx, pgstore.RedeliverWebhookDeliveryParams{
+		ID:        deliveryID,
+		WebhookID: webhook.ID,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger(ctx).Info("webhook redelivery queued", "webhook_id", webhookID.String(), "delivery_id", delivery.ID.String(), "redelivery_of", deliveryID.String())
+
+	response := entity.WebhookDeliveryToDTO(delivery)
+
+	return &response, nil
+}
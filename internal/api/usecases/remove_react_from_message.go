@@ -4,12 +4,18 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
 	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RemoveReactFromMessageUseCase struct {
-	q   *pgstore.Queries
-	ctx context.Context
+	work    *UnitOfWork
+	counter ReactionCounter
+	roomID  uuid.UUID
+	ctx     context.Context
 }
 
 type RemoveReactFromMessageUseCaseResponse struct {
@@ -17,30 +23,117 @@ type RemoveReactFromMessageUseCaseResponse struct {
 	MessageID      string `json:"message_id"`
 }
 
-func NewRemoveReactFromMessageUseCase(queries *pgstore.Queries, context context.Context) *RemoveReactFromMessageUseCase {
+func NewRemoveReactFromMessageUseCase(work *UnitOfWork, counter ReactionCounter, context context.Context) *RemoveReactFromMessageUseCase {
 	return &RemoveReactFromMessageUseCase{
-		q:   queries,
-		ctx: context,
+		work:    work,
+		counter: counter,
+		ctx:     context,
 	}
 }
 
-func (u *RemoveReactFromMessageUseCase) Execute(messageID uuid.UUID) (*ReactToMessageUseCaseResponse, error) {
-	_, err := u.q.GetMessage(u.ctx, messageID)
+// InRoom restricts the use case to the messages of roomID. Messages of
+// other rooms are reported as pgx.ErrNoRows.
+func (u *RemoveReactFromMessageUseCase) InRoom(roomID uuid.UUID) *RemoveReactFromMessageUseCase {
+	u.roomID = roomID
+	return u
+}
+
+// Execute updates the reaction count and notifies the room once the change
+// committed. Unless versions is nil, the message must be at one of them or
+// ErrVersionMismatch is returned.
+func (u *RemoveReactFromMessageUseCase) Execute(messageID uuid.UUID, versions []int64) (*ReactToMessageUseCaseResponse, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.RemoveReactFromMessage.Execute", trace.WithAttributes(tracing.MessageID(messageID.String())))
+	defer span.End()
+
+	if u.counter != nil {
+		return u.executeWriteBehind(ctx, messageID, versions)
+	}
+
+	var response ReactToMessageUseCaseResponse
+
+	// Like reactions, removals lock the message instead of running
+	// serializable.
+	err := u.work.Do(ctx, pgx.ReadCommitted, func(w *Work) error {
+		message, err := w.Q.GetMessageForUpdate(ctx, messageID)
+
+		if err != nil {
+			return err
+		}
+
+		if !inRoom(message, u.roomID) {
+			return pgx.ErrNoRows
+		}
+
+		if !versionMatches(message.Version, versions) {
+			return ErrVersionMismatch
+		}
+
+		reactions_count, err := w.Q.RemoveReactionFromMessage(ctx, pgstore.RemoveReactionFromMessageParams{
+			ID:       messageID,
+			Versions: versions,
+		})
+
+		if err != nil {
+			return conditional(err)
+		}
+
+		response = ReactToMessageUseCaseResponse{
+			ReactionsCount: reactions_count,
+			MessageID:      messageID.String(),
+			RoomID:         message.RoomID.String(),
+		}
+
+		w.Publish(response.removedEvent())
+
+		return nil
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	reactions_count, err := u.q.RemoveReactionFromMessage(u.ctx, messageID)
+	metrics.DomainEvents.WithLabelValues(metrics.EventReactionRemoved).Inc()
+	logger(ctx).Debug("reaction removed", "message_id", messageID.String(), "write_behind", u.counter != nil)
+
+	return &response, nil
+}
+
+func (u *RemoveReactFromMessageUseCase) executeWriteBehind(ctx context.Context, messageID uuid.UUID, versions []int64) (*ReactToMessageUseCaseResponse, error) {
+	var response ReactToMessageUseCaseResponse
+
+	err := u.counter.View(func() error {
+		message, err := u.work.q.GetMessage(ctx, messageID)
+
+		if err != nil {
+			return err
+		}
+
+		if !inRoom(message, u.roomID) {
+			return pgx.ErrNoRows
+		}
+
+		if !versionMatches(message.Version, versions) {
+			return ErrVersionMismatch
+		}
+
+		response = ReactToMessageUseCaseResponse{
+			ReactionsCount: message.ReactionsCount + u.counter.Add(messageID, -1),
+			MessageID:      messageID.String(),
+			RoomID:         message.RoomID.String(),
+		}
+
+		return nil
+	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	response := ReactToMessageUseCaseResponse{
-		ReactionsCount: reactions_count,
-		MessageID:      messageID.String(),
-	}
+	// The event is raised by the next flush, along with the other changes
+	// to the count since the previous one.
+
+	metrics.DomainEvents.WithLabelValues(metrics.EventReactionRemoved).Inc()
+	logger(ctx).Debug("reaction removed", "message_id", messageID.String(), "write_behind", u.counter != nil)
 
 	return &response, nil
 }
@@ -0,0 +1,103 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type ResetReactionsUseCase struct {
+	work *UnitOfWork
+	ctx  context.Context
+}
+
+type ResetReactionsResponse struct {
+	Messages int64 `json:"messages"`
+}
+
+func NewResetReactionsUseCase(work *UnitOfWork, context context.Context) *ResetReactionsUseCase {
+	return &ResetReactionsUseCase{
+		work: work,
+		ctx:  context,
+	}
+}
+
+// ExecuteMessage sets the reaction count of one message to zero.
+func (u *ResetReactionsUseCase) ExecuteMessage(messageID uuid.UUID) (*ResetReactionsResponse, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.ResetReactions.ExecuteMessage", trace.WithAttributes(tracing.MessageID(messageID.String())))
+	defer span.End()
+
+	err := u.work.Do(ctx, pgx.Serializable, func(w *Work) error {
+		roomID, err := w.Q.ResetMessageReactions(ctx, messageID)
+
+		if err != nil {
+			return err
+		}
+
+		w.Publish(resetEvent(roomID, []uuid.UUID{messageID}))
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger(ctx).Info("reactions reset", "message_id", messageID.String())
+
+	return &ResetReactionsResponse{Messages: 1}, nil
+}
+
+// ExecuteRoom sets the reaction count of every message in the room to zero.
+func (u *ResetReactionsUseCase) ExecuteRoom(roomID uuid.UUID) (*ResetReactionsResponse, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.ResetReactions.ExecuteRoom", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
+
+	var response ResetReactionsResponse
+
+	err := u.work.Do(ctx, pgx.Serializable, func(w *Work) error {
+		if _, err := w.Q.GetRoom(ctx, roomID); err != nil {
+			return err
+		}
+
+		messageIDs, err := w.Q.ResetRoomReactions(ctx, roomID)
+
+		if err != nil {
+			return err
+		}
+
+		if len(messageIDs) > 0 {
+			w.Publish(resetEvent(roomID, messageIDs))
+		}
+
+		response.Messages = int64(len(messageIDs))
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	logger(ctx).Info("reactions reset", "room_id", roomID.String(), "messages", response.Messages)
+
+	return &response, nil
+}
+
+// resetEvent tells subscribers the messages have no reactions left.
+func resetEvent(roomID uuid.UUID, messageIDs []uuid.UUID) entity.Message {
+	reactions := make([]entity.MessageReactionCount, 0, len(messageIDs))
+	for _, id := range messageIDs {
+		reactions = append(reactions, entity.MessageReactionCount{ID: id.String()})
+	}
+
+	return entity.Message{
+		Kind:   entity.MessageKindReactionsBatch,
+		RoomId: roomID.String(),
+		Value:  entity.MessageReactionsBatch{Reactions: reactions},
+	}
+}
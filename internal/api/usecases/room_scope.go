@@ -0,0 +1,12 @@
+package usecases
+
+import (
+	"github.com/google/uuid"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+// inRoom reports whether message belongs to roomID. uuid.Nil matches any
+// room.
+func inRoom(message pgstore.Message, roomID uuid.UUID) bool {
+	return roomID == uuid.Nil || message.RoomID == roomID
+}
@@ -0,0 +1,86 @@
+package usecases
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrInvalidHostToken is returned when a host-only action is attempted on a
+// room with a host token without presenting it.
+var ErrInvalidHostToken = errors.New("invalid host token")
+
+type RotateHostTokenUseCase struct {
+	q   *pgstore.Queries
+	ctx context.Context
+}
+
+type RotateHostTokenResponse struct {
+	RoomID    string `json:"room_id"`
+	HostToken string `json:"host_token"`
+}
+
+func NewRotateHostTokenUseCase(queries *pgstore.Queries, context context.Context) *RotateHostTokenUseCase {
+	return &RotateHostTokenUseCase{
+		q:   queries,
+		ctx: context,
+	}
+}
+
+// Execute replaces the room's host token. Only its hash is stored, so the
+// returned token cannot be shown again.
+func (u *RotateHostTokenUseCase) Execute(roomID uuid.UUID) (*RotateHostTokenResponse, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.RotateHostToken.Execute", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
+
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(raw)
+	hash := sha256.Sum256([]byte(token))
+
+	rows, err := u.q.SetRoomHostToken(ctx, pgstore.SetRoomHostTokenParams{
+		ID:            roomID,
+		HostTokenHash: hash[:],
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rows == 0 {
+		return nil, pgx.ErrNoRows
+	}
+
+	logger(ctx).Info("host token rotated", "room_id", roomID.String())
+
+	response := RotateHostTokenResponse{
+		RoomID:    roomID.String(),
+		HostToken: token,
+	}
+
+	return &response, nil
+}
+
+func checkHostToken(room pgstore.Room, token string) error {
+	if room.HostTokenHash == nil {
+		return nil
+	}
+
+	hash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(hash[:], room.HostTokenHash) != 1 {
+		return ErrInvalidHostToken
+	}
+
+	return nil
+}
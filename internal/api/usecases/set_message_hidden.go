@@ -0,0 +1,61 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type SetMessageHiddenUseCase struct {
+	work *UnitOfWork
+	ctx  context.Context
+}
+
+func NewSetMessageHiddenUseCase(work *UnitOfWork, context context.Context) *SetMessageHiddenUseCase {
+	return &SetMessageHiddenUseCase{
+		work: work,
+		ctx:  context,
+	}
+}
+
+// Execute flags the message as hidden, or shows it again, and notifies the
+// room.
+func (u *SetMessageHiddenUseCase) Execute(messageID uuid.UUID, hidden bool) error {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.SetMessageHidden.Execute", trace.WithAttributes(tracing.MessageID(messageID.String())))
+	defer span.End()
+
+	err := u.work.Do(ctx, pgx.Serializable, func(w *Work) error {
+		roomID, err := w.Q.SetMessageHidden(ctx, pgstore.SetMessageHiddenParams{
+			ID:     messageID,
+			Hidden: hidden,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		w.Publish(entity.Message{
+			Kind:   entity.MessageKindMessageHidden,
+			RoomId: roomID.String(),
+			Value: entity.MessageMessageHidden{
+				ID:     messageID.String(),
+				Hidden: hidden,
+			},
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	logger(ctx).Info("message visibility changed", "message_id", messageID.String(), "hidden", hidden)
+
+	return nil
+}
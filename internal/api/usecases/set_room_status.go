@@ -0,0 +1,53 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type SetRoomStatusUseCase struct {
+	q   *pgstore.Queries
+	ctx context.Context
+}
+
+func NewSetRoomStatusUseCase(queries *pgstore.Queries, context context.Context) *SetRoomStatusUseCase {
+	return &SetRoomStatusUseCase{
+		q:   queries,
+		ctx: context,
+	}
+}
+
+func (u *SetRoomStatusUseCase) Execute(roomID uuid.UUID, status string) error {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.SetRoomStatus.Execute", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
+
+	switch status {
+	case entity.RoomStatusOpen, entity.RoomStatusClosed, entity.RoomStatusArchived:
+	default:
+		return fmt.Errorf("invalid room status %q", status)
+	}
+
+	rows, err := u.q.SetRoomStatus(ctx, pgstore.SetRoomStatusParams{
+		ID:     roomID,
+		Status: status,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return pgx.ErrNoRows
+	}
+
+	logger(ctx).Info("room status changed", "room_id", roomID.String(), "status", status)
+
+	return nil
+}
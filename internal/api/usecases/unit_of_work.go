@@ -0,0 +1,178 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
+	"github.com/thiagoleet/go-ama-api/internal/outbox"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+// DefaultTxAttempts is how many times a unit of work runs before a
+// serialization failure is returned to the caller.
+const DefaultTxAttempts = 5
+
+const (
+	sqlstateSerializationFailure = "40001"
+	sqlstateDeadlockDetected     = "40P01"
+
+	retryBaseDelay = 5 * time.Millisecond
+	retryMaxDelay  = 200 * time.Millisecond
+)
+
+// TxBeginner starts transactions. *pgxpool.Pool implements it.
+type TxBeginner interface {
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+}
+
+// Publisher dispatches an event raised by a unit of work that runs without
+// transactions.
+type Publisher func(ctx context.Context, msg entity.Message)
+
+// UnitOfWork runs a use case in one transaction and writes the events it
+// raised to the outbox in that transaction, so clients never see
+// events for writes that were rolled back nor miss events for writes that
+// committed.
+type UnitOfWork struct {
+	q           *pgstore.Queries
+	db          TxBeginner
+	publish     Publisher
+	maxAttempts int
+}
+
+// Work is handed to the function run by a unit of work. Its queries run in
+// the transaction.
+type Work struct {
+	Q      *pgstore.Queries
+	events []entity.Message
+}
+
+// Publish queues msg for the outbox.
+func (w *Work) Publish(msg entity.Message) {
+	w.events = append(w.events, msg)
+}
+
+// NewUnitOfWork returns a unit of work beginning transactions on db. Without
+// db, functions run on q outside a transaction and their events are handed
+// to publish when they succeed.
+func NewUnitOfWork(q *pgstore.Queries, db TxBeginner, publish Publisher, maxAttempts int) *UnitOfWork {
+	if maxAttempts < 1 {
+		maxAttempts = DefaultTxAttempts
+	}
+
+	return &UnitOfWork{
+		q:           q,
+		db:          db,
+		publish:     publish,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Do runs fn in a transaction at the isolation level chosen by the use case.
+// fn is run again from the start, in a new transaction, when Postgres
+// reports a serialization failure or a deadlock, so it must not have side
+// effects outside of w.
+func (u *UnitOfWork) Do(ctx context.Context, isolation pgx.TxIsoLevel, fn func(w *Work) error) error {
+	for attempt := 1; ; attempt++ {
+		w := &Work{}
+		err := u.run(ctx, isolation, w, fn)
+
+		if err == nil {
+			// With transactions, the events were written to the outbox.
+			if u.db == nil {
+				u.dispatch(ctx, w.events)
+			}
+			return nil
+		}
+
+		code, retry := retryable(err)
+		if !retry || attempt == u.maxAttempts {
+			return err
+		}
+
+		metrics.TxRetries.WithLabelValues(code).Inc()
+		logger(ctx).Debug("retrying transaction", "attempt", attempt, "sqlstate", code)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff(attempt)):
+		}
+	}
+}
+
+func (u *UnitOfWork) run(ctx context.Context, isolation pgx.TxIsoLevel, w *Work, fn func(w *Work) error) error {
+	if u.db == nil {
+		w.Q = u.q
+		return fn(w)
+	}
+
+	tx, err := u.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: isolation})
+	if err != nil {
+		return err
+	}
+
+	// Rolling back a committed transaction is a no-op.
+	defer func() {
+		_ = tx.Rollback(context.WithoutCancel(ctx))
+	}()
+
+	w.Q = u.q.WithTx(tx)
+
+	if err := fn(w); err != nil {
+		return err
+	}
+
+	for _, msg := range w.events {
+		if err := outbox.Write(ctx, w.Q, msg); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (u *UnitOfWork) dispatch(ctx context.Context, events []entity.Message) {
+	if u.publish == nil || len(events) == 0 {
+		return
+	}
+
+	// The events outlive the request that raised them.
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		for _, msg := range events {
+			u.publish(ctx, msg)
+		}
+	}()
+}
+
+func retryable(err error) (string, bool) {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return "", false
+	}
+
+	switch pgErr.Code {
+	case sqlstateSerializationFailure, sqlstateDeadlockDetected:
+		return pgErr.Code, true
+	}
+
+	return "", false
+}
+
+// backoff doubles the delay on each attempt, with jitter so transactions
+// that conflicted don't collide again.
+func backoff(attempt int) time.Duration {
+	delay := retryBaseDelay << (attempt - 1)
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
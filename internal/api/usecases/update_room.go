@@ -0,0 +1,90 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrInvalidRoomUpdate is returned when an update sets an empty theme or an
+// unknown status.
+var ErrInvalidRoomUpdate = errors.New("invalid room update")
+
+type UpdateRoomUseCase struct {
+	q   *pgstore.Queries
+	ctx context.Context
+}
+
+// UpdateRoomInput holds the room settings to change. Nil fields are kept.
+type UpdateRoomInput struct {
+	Theme  *string `json:"theme,omitempty"`
+	Status *string `json:"status,omitempty"`
+}
+
+func NewUpdateRoomUseCase(queries *pgstore.Queries, context context.Context) *UpdateRoomUseCase {
+	return &UpdateRoomUseCase{
+		q:   queries,
+		ctx: context,
+	}
+}
+
+// Execute changes the room settings. hostToken is checked when the room has
+// a host token. Unless versions is nil, the room must be at one of them or
+// ErrVersionMismatch is returned.
+func (u *UpdateRoomUseCase) Execute(roomID uuid.UUID, input UpdateRoomInput, hostToken string, versions []int64) (*entity.RoomDTO, error) {
+	ctx, span := tracing.Tracer().Start(u.ctx, "usecases.UpdateRoom.Execute", trace.WithAttributes(tracing.RoomID(roomID.String())))
+	defer span.End()
+
+	params := pgstore.UpdateRoomParams{ID: roomID, Versions: versions}
+
+	if input.Theme != nil {
+		theme := strings.TrimSpace(*input.Theme)
+		if theme == "" {
+			return nil, fmt.Errorf("%w: theme must not be empty", ErrInvalidRoomUpdate)
+		}
+		params.Theme = pgtype.Text{String: theme, Valid: true}
+	}
+
+	if input.Status != nil {
+		switch *input.Status {
+		case entity.RoomStatusOpen, entity.RoomStatusClosed, entity.RoomStatusArchived:
+		default:
+			return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidRoomUpdate, *input.Status)
+		}
+		params.Status = pgtype.Text{String: *input.Status, Valid: true}
+	}
+
+	room, err := u.q.GetRoom(ctx, roomID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkHostToken(room, hostToken); err != nil {
+		return nil, err
+	}
+
+	if !versionMatches(room.Version, versions) {
+		return nil, ErrVersionMismatch
+	}
+
+	room, err = u.q.UpdateRoom(ctx, params)
+
+	if err != nil {
+		return nil, conditional(err)
+	}
+
+	logger(ctx).Info("room updated", "room_id", roomID.String(), "version", room.Version)
+
+	response := entity.RoomToDTO(room)
+
+	return &response, nil
+}
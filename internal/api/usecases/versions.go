@@ -0,0 +1,28 @@
+package usecases
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrVersionMismatch is returned by conditional updates when the row's
+// version is none of the expected ones.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// versionMatches reports whether version is one of versions. A nil list
+// matches any version.
+func versionMatches(version int64, versions []int64) bool {
+	return versions == nil || slices.Contains(versions, version)
+}
+
+// conditional maps the pgx.ErrNoRows of a conditional update on a row known
+// to exist to ErrVersionMismatch.
+func conditional(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrVersionMismatch
+	}
+
+	return err
+}
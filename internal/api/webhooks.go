@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/api/usecases"
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+)
+
+func (h apiHandler) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	roomID, err := uuid.Parse(chi.URLParam(r, "room_id"))
+
+	if err != nil {
+		http.Error(w, "invalid room id", http.StatusBadRequest)
+		return
+	}
+
+	var body usecases.CreateWebhookInput
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "invalid json", http.StatusBadRequest)
+		return
+	}
+
+	u := usecases.NewCreateWebhookUseCase(h.q, h.webhookNetworks, r.Context())
+
+	response, err := u.Execute(roomID, body, r.Header.Get("X-Host-Token"))
+
+	if err != nil {
+		webhookError(w, r, err, "room not found")
+		return
+	}
+
+	data, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func (h apiHandler) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	roomID, err := uuid.Parse(chi.URLParam(r, "room_id"))
+
+	if err != nil {
+		http.Error(w, "invalid room id", http.StatusBadRequest)
+		return
+	}
+
+	u := usecases.NewListWebhooksUseCase(h.q, r.Context())
+
+	response, err := u.Execute(roomID, r.Header.Get("X-Host-Token"))
+
+	if err != nil {
+		webhookError(w, r, err, "room not found")
+		return
+	}
+
+	data, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func (h apiHandler) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	roomID, webhookID, ok := webhookParams(w, r)
+	if !ok {
+		return
+	}
+
+	u := usecases.NewDeleteWebhookUseCase(h.q, r.Context())
+
+	if err := u.Execute(roomID, webhookID, r.Header.Get("X-Host-Token")); err != nil {
+		webhookError(w, r, err, "webhook not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h apiHandler) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	roomID, webhookID, ok := webhookParams(w, r)
+	if !ok {
+		return
+	}
+
+	u := usecases.NewListWebhookDeliveriesUseCase(h.q, r.Context())
+
+	response, err := u.Execute(roomID, webhookID, r.Header.Get("X-Host-Token"))
+
+	if err != nil {
+		webhookError(w, r, err, "webhook not found")
+		return
+	}
+
+	data, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func (h apiHandler) handleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	roomID, webhookID, ok := webhookParams(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := uuid.Parse(chi.URLParam(r, "delivery_id"))
+
+	if err != nil {
+		http.Error(w, "invalid delivery id", http.StatusBadRequest)
+		return
+	}
+
+	u := usecases.NewRedeliverWebhookUseCase(h.q, r.Context())
+
+	response, err := u.Execute(roomID, webhookID, deliveryID, r.Header.Get("X-Host-Token"))
+
+	if err != nil {
+		webhookError(w, r, err, "delivery not found")
+		return
+	}
+
+	data, _ := json.Marshal(response)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write(data)
+}
+
+func webhookParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	roomID, err := uuid.Parse(chi.URLParam(r, "room_id"))
+
+	if err != nil {
+		http.Error(w, "invalid room id", http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	webhookID, err := uuid.Parse(chi.URLParam(r, "webhook_id"))
+
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return roomID, webhookID, true
+}
+
+func webhookError(w http.ResponseWriter, r *http.Request, err error, notFound string) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		http.Error(w, notFound, http.StatusNotFound)
+	case errors.Is(err, usecases.ErrInvalidWebhook):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, usecases.ErrInvalidHostToken):
+		http.Error(w, "invalid host token", http.StatusForbidden)
+	default:
+		logging.FromContext(r.Context()).Error("webhook request failed", "error", err)
+		http.Error(w, "something went wrong", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/api/usecases"
+	"github.com/thiagoleet/go-ama-api/internal/outbox"
+	"github.com/thiagoleet/go-ama-api/internal/webhooks"
+)
+
+const webhookBackoff = 20 * time.Millisecond
+
+// delivery is a request received by a receiver.
+type delivery struct {
+	at     time.Time
+	header http.Header
+	body   []byte
+}
+
+// receiver is a webhook endpoint answering with status.
+type receiver struct {
+	*httptest.Server
+	status   atomic.Int32
+	received chan delivery
+}
+
+func newReceiver(t *testing.T) *receiver {
+	t.Helper()
+
+	rc := &receiver{received: make(chan delivery, 16)}
+	rc.status.Store(http.StatusOK)
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.received <- delivery{at: time.Now(), header: r.Header.Clone(), body: body}
+
+		w.WriteHeader(int(rc.status.Load()))
+		_, _ = w.Write([]byte("stack trace of an internal service"))
+	}))
+	t.Cleanup(rc.Close)
+
+	return rc
+}
+
+func (rc *receiver) next(t *testing.T) delivery {
+	t.Helper()
+
+	select {
+	case d := <-rc.received:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a delivery")
+		return delivery{}
+	}
+}
+
+func newWebhookFixture(t *testing.T) *fixture {
+	return newFixture(t,
+		WithOutbox(outbox.Options{PollInterval: 5 * time.Millisecond}),
+		WithWebhooks(webhooks.Options{
+			Timeout:         time.Second,
+			MaxAttempts:     3,
+			Backoff:         webhookBackoff,
+			MaxBackoff:      time.Second,
+			PollInterval:    5 * time.Millisecond,
+			AllowedNetworks: webhooks.MustParseNetworks("127.0.0.0/8", "::1"),
+		}),
+	)
+}
+
+func (f *fixture) createWebhook(t *testing.T, url string) usecases.CreateWebhookResponse {
+	t.Helper()
+
+	resp := f.do(t, http.MethodPost, "/api/rooms/"+f.roomID.String()+"/webhooks",
+		`{"url":"`+url+`","kinds":["message_answered"]}`, http.Header{"X-Host-Token": {f.hostToken}})
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("create webhook: status %d: %s", resp.StatusCode, body)
+	}
+
+	var webhook usecases.CreateWebhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	return webhook
+}
+
+func (f *fixture) deliveries(t *testing.T, webhookID string) []entity.WebhookDeliveryDTO {
+	t.Helper()
+
+	resp := f.do(t, http.MethodGet, "/api/rooms/"+f.roomID.String()+"/webhooks/"+webhookID+"/deliveries", "", http.Header{"X-Host-Token": {f.hostToken}})
+	defer resp.Body.Close()
+
+	var list usecases.ListWebhookDeliveriesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+
+	return list.Deliveries
+}
+
+// waitForDelivery polls the deliveries log until id is no longer pending.
+func (f *fixture) waitForDelivery(t *testing.T, webhookID, id string) entity.WebhookDeliveryDTO {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, d := range f.deliveries(t, webhookID) {
+			if d.ID == id && d.Status != entity.WebhookDeliveryPending {
+				return d
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("delivery %s still pending", id)
+	return entity.WebhookDeliveryDTO{}
+}
+
+func checkSignature(t *testing.T, d delivery, secret string) {
+	t.Helper()
+
+	timestamp, err := strconv.ParseInt(d.header.Get(webhooks.TimestampHeader), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid %s: %v", webhooks.TimestampHeader, err)
+	}
+
+	if got, want := d.header.Get(webhooks.SignatureHeader), webhooks.Sign(secret, timestamp, d.body); got != want {
+		t.Errorf("%s = %s, want %s", webhooks.SignatureHeader, got, want)
+	}
+}
+
+func TestWebhookRetriedUntilFailed(t *testing.T) {
+	f := newWebhookFixture(t)
+	rc := newReceiver(t)
+	rc.status.Store(http.StatusServiceUnavailable)
+
+	webhook := f.createWebhook(t, rc.URL+"/hooks")
+
+	resp := f.do(t, http.MethodPatch, "/api/rooms/"+f.messageID.String()+"/answer", "", nil)
+	resp.Body.Close()
+
+	var attempts []delivery
+	for i := 0; i < 3; i++ {
+		attempts = append(attempts, rc.next(t))
+	}
+
+	deliveryID := attempts[0].header.Get(webhooks.DeliveryHeader)
+	for i, d := range attempts {
+		checkSignature(t, d, webhook.Secret)
+
+		if got := d.header.Get(webhooks.EventHeader); got != entity.MessageKindMessageAnswered {
+			t.Errorf("attempt %d: %s = %s, want %s", i+1, webhooks.EventHeader, got, entity.MessageKindMessageAnswered)
+		}
+		if got := d.header.Get(webhooks.DeliveryHeader); got != deliveryID {
+			t.Errorf("attempt %d: %s = %s, want %s", i+1, webhooks.DeliveryHeader, got, deliveryID)
+		}
+
+		msg, err := entity.Events.Decode(d.body)
+		if err != nil {
+			t.Fatalf("attempt %d: %v", i+1, err)
+		}
+		if v, _ := entity.Payload[entity.MessageMessageAnswered](msg); v.ID != f.messageID.String() {
+			t.Errorf("attempt %d: answered event for %s, want %s", i+1, v.ID, f.messageID)
+		}
+
+		// Each retry waits at least half of the doubled backoff.
+		if i > 0 {
+			if gap, want := d.at.Sub(attempts[i-1].at), webhookBackoff/2<<(i-1); gap < want {
+				t.Errorf("attempt %d sent %s after the previous one, want at least %s", i+1, gap, want)
+			}
+		}
+	}
+
+	logged := f.waitForDelivery(t, webhook.ID, deliveryID)
+	if logged.Status != entity.WebhookDeliveryFailed || logged.Attempts != 3 {
+		t.Errorf("delivery %s after %d attempts, want failed after 3", logged.Status, logged.Attempts)
+	}
+	if logged.LastStatusCode == nil || *logged.LastStatusCode != http.StatusServiceUnavailable {
+		t.Errorf("last status code %v, want %d", logged.LastStatusCode, http.StatusServiceUnavailable)
+	}
+	if logged.LastError != "unexpected status 503" {
+		t.Errorf("last error %q, want the status only", logged.LastError)
+	}
+
+	select {
+	case d := <-rc.received:
+		t.Errorf("delivery attempted again after failing: %s", d.header.Get(webhooks.DeliveryHeader))
+	case <-time.After(4 * webhookBackoff):
+	}
+}
+
+func TestWebhookRedelivery(t *testing.T) {
+	f := newWebhookFixture(t)
+	rc := newReceiver(t)
+
+	webhook := f.createWebhook(t, rc.URL+"/hooks")
+
+	resp := f.do(t, http.MethodPatch, "/api/rooms/"+f.messageID.String()+"/answer", "", nil)
+	resp.Body.Close()
+
+	first := rc.next(t)
+	checkSignature(t, first, webhook.Secret)
+
+	original := f.waitForDelivery(t, webhook.ID, first.header.Get(webhooks.DeliveryHeader))
+	if original.Status != entity.WebhookDeliverySucceeded || original.Attempts != 1 {
+		t.Fatalf("delivery %s after %d attempts, want succeeded after 1", original.Status, original.Attempts)
+	}
+
+	resp = f.do(t, http.MethodPost, "/api/rooms/"+f.roomID.String()+"/webhooks/"+webhook.ID+"/deliveries/"+original.ID+"/redeliver", "", http.Header{"X-Host-Token": {f.hostToken}})
+	var queued entity.WebhookDeliveryDTO
+	if err := json.NewDecoder(resp.Body).Decode(&queued); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if queued.ID == original.ID || queued.RedeliveryOf != original.ID || queued.EventID != original.EventID {
+		t.Errorf("redelivery %s of %s for event %s, want a new delivery of %s for event %s", queued.ID, queued.RedeliveryOf, queued.EventID, original.ID, original.EventID)
+	}
+
+	again := rc.next(t)
+	checkSignature(t, again, webhook.Secret)
+	if got := again.header.Get(webhooks.DeliveryHeader); got != queued.ID {
+		t.Errorf("%s = %s, want %s", webhooks.DeliveryHeader, got, queued.ID)
+	}
+	if string(again.body) != string(first.body) {
+		t.Errorf("redelivered %s, want %s", again.body, first.body)
+	}
+
+	redelivered := f.waitForDelivery(t, webhook.ID, queued.ID)
+	if redelivered.Status != entity.WebhookDeliverySucceeded || redelivered.RedeliveryOf != original.ID {
+		t.Errorf("redelivery %s of %q, want succeeded of %s", redelivered.Status, redelivered.RedeliveryOf, original.ID)
+	}
+
+	// Both rows stay in the log.
+	ids := map[string]bool{}
+	for _, d := range f.deliveries(t, webhook.ID) {
+		ids[d.ID] = true
+	}
+	if !ids[original.ID] || !ids[queued.ID] {
+		t.Errorf("deliveries log %v, want %s and %s", ids, original.ID, queued.ID)
+	}
+}
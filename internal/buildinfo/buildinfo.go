@@ -0,0 +1,52 @@
+// Package buildinfo exposes build metadata injected at link time:
+//
+//	go build -ldflags "-X github.com/thiagoleet/go-ama-api/internal/buildinfo.Version=v1.2.3 \
+//	  -X github.com/thiagoleet/go-ama-api/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/thiagoleet/go-ama-api/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/wsrs
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the linked build metadata, falling back to the VCS details
+// recorded by the Go toolchain when they were not injected.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		Date:      Date,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.Date == "" {
+					info.Date = s.Value
+				}
+			}
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,741 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/joho/godotenv"
+	"github.com/thiagoleet/go-ama-api/internal/origin"
+	"github.com/thiagoleet/go-ama-api/internal/outbox"
+	"github.com/thiagoleet/go-ama-api/internal/server"
+	"github.com/thiagoleet/go-ama-api/internal/webhooks"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+)
+
+type Config struct {
+	Env       string          `yaml:"env" toml:"env"`
+	HTTP      HTTPConfig      `yaml:"http" toml:"http"`
+	Database  DatabaseConfig  `yaml:"database" toml:"database"`
+	Origins   OriginsConfig   `yaml:"origins" toml:"origins"`
+	CORS      CORSConfig      `yaml:"cors" toml:"cors"`
+	WebSocket WebSocketConfig `yaml:"websocket" toml:"websocket"`
+	Features  FeaturesConfig  `yaml:"features" toml:"features"`
+	Outbox    OutboxConfig    `yaml:"outbox" toml:"outbox"`
+	Webhooks  WebhooksConfig  `yaml:"webhooks" toml:"webhooks"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Logging   LoggingConfig   `yaml:"logging" toml:"logging"`
+}
+
+type HTTPConfig struct {
+	Addr string `yaml:"addr" toml:"addr"`
+	// Listeners are URLs such as https://:8443, h2c://:8081 or
+	// unix:///run/wsrs.sock, all serving the API at once. When empty, plain
+	// HTTP is served on Addr.
+	Listeners []string  `yaml:"listeners" toml:"listeners"`
+	TLS       TLSConfig `yaml:"tls" toml:"tls"`
+	// ShutdownTimeout bounds how long in-flight requests and subscribers
+	// are drained after SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// ShutdownDelay keeps serving, with readiness failing, before draining
+	// starts, so load balancers stop routing new traffic first.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay" toml:"shutdown_delay"`
+}
+
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file" toml:"cert_file"`
+	KeyFile  string `yaml:"key_file" toml:"key_file"`
+	// ReloadInterval is how often the files are checked for a renewed
+	// certificate.
+	ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval"`
+}
+
+type DatabaseConfig struct {
+	// DSN takes precedence over the individual connection fields below.
+	DSN      string `yaml:"dsn" toml:"dsn"`
+	Host     string `yaml:"host" toml:"host"`
+	Port     string `yaml:"port" toml:"port"`
+	Name     string `yaml:"name" toml:"name"`
+	User     string `yaml:"user" toml:"user"`
+	Password string `yaml:"password" toml:"password"`
+	SSLMode  string `yaml:"sslmode" toml:"sslmode"`
+
+	MaxConns        int32         `yaml:"max_conns" toml:"max_conns"`
+	MinConns        int32         `yaml:"min_conns" toml:"min_conns"`
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime" toml:"max_conn_lifetime"`
+	MaxConnIdleTime time.Duration `yaml:"max_conn_idle_time" toml:"max_conn_idle_time"`
+
+	// TxMaxAttempts bounds how many times a transaction is run when it
+	// fails to serialize with a concurrent one.
+	TxMaxAttempts int `yaml:"tx_max_attempts" toml:"tx_max_attempts"`
+
+	// MigrateOnStart applies pending migrations before serving. Replicas
+	// starting together take turns on an advisory lock.
+	MigrateOnStart bool `yaml:"migrate_on_start" toml:"migrate_on_start"`
+}
+
+// OriginsConfig is the origin allowlist shared by CORS and WebSocket
+// upgrades. Patterns are exact origins, wildcard subdomains such as
+// https://*.example.com, or regular expressions prefixed with "re:" that
+// must match the whole origin.
+type OriginsConfig struct {
+	Allowed []string `yaml:"allowed" toml:"allowed"`
+	// Environments replaces Allowed in the named environment, so a single
+	// config file can serve development and production.
+	Environments map[string][]string `yaml:"environments" toml:"environments"`
+	// ForcePermissive lets production start with a policy that allows any
+	// origin.
+	ForcePermissive bool `yaml:"force_permissive" toml:"force_permissive"`
+}
+
+type CORSConfig struct {
+	// AllowedOrigins replaces the shared origin allowlist for CORS.
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+}
+
+type WebSocketConfig struct {
+	// AllowedOrigins replaces the shared origin allowlist for sockets. An
+	// empty list only allows same-origin requests.
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+	Compression    bool     `yaml:"compression" toml:"compression"`
+	// CompressionLevel is a compress/flate level, from -2 (Huffman only)
+	// to 9 (best compression).
+	CompressionLevel int `yaml:"compression_level" toml:"compression_level"`
+	// CompressionThreshold is the size in bytes below which frames are
+	// sent uncompressed.
+	CompressionThreshold int `yaml:"compression_threshold" toml:"compression_threshold"`
+	// ReadLimit is the largest frame, in bytes, accepted from a subscriber.
+	ReadLimit    int64         `yaml:"read_limit" toml:"read_limit"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	// ReadBufferSize and WriteBufferSize are the I/O buffer sizes in bytes.
+	// Zero uses the WebSocket library defaults.
+	ReadBufferSize  int `yaml:"read_buffer_size" toml:"read_buffer_size"`
+	WriteBufferSize int `yaml:"write_buffer_size" toml:"write_buffer_size"`
+}
+
+type FeaturesConfig struct {
+	ReactionWindow        time.Duration `yaml:"reaction_window" toml:"reaction_window"`
+	WriteBehindReactions  bool          `yaml:"write_behind_reactions" toml:"write_behind_reactions"`
+	ReactionFlushInterval time.Duration `yaml:"reaction_flush_interval" toml:"reaction_flush_interval"`
+	// SingleInstance declares that this is the only instance serving the
+	// database. Write-behind reactions require it: counts buffered by one
+	// instance are not seen by the others.
+	SingleInstance bool `yaml:"single_instance" toml:"single_instance"`
+	// ValidateResponses checks responses against the OpenAPI document.
+	ValidateResponses bool `yaml:"validate_responses" toml:"validate_responses"`
+	// IdempotencyTTL is how long responses are kept for requests retried
+	// with the same Idempotency-Key. Zero ignores the header.
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl" toml:"idempotency_ttl"`
+}
+
+type OutboxConfig struct {
+	// Instance names the outbox cursor of this instance, so it resumes
+	// where it left off after a restart. It must be unique among running
+	// instances and defaults to the hostname and first listener address.
+	Instance     string        `yaml:"instance" toml:"instance"`
+	PollInterval time.Duration `yaml:"poll_interval" toml:"poll_interval"`
+	BatchSize    int           `yaml:"batch_size" toml:"batch_size"`
+	// ConsumerTTL is how long the cursor of an instance that stopped is kept
+	// before the events it did not process may be cleaned up.
+	ConsumerTTL time.Duration `yaml:"consumer_ttl" toml:"consumer_ttl"`
+}
+
+type WebhooksConfig struct {
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+	// MaxAttempts is how many times a delivery is tried before it is marked
+	// failed.
+	MaxAttempts int `yaml:"max_attempts" toml:"max_attempts"`
+	// Backoff is the delay before the first retry, doubling on every retry
+	// up to MaxBackoff.
+	Backoff    time.Duration `yaml:"backoff" toml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff" toml:"max_backoff"`
+	// Retention is how long finished deliveries are kept in the log.
+	Retention time.Duration `yaml:"retention" toml:"retention"`
+	// AllowedNetworks are CIDR prefixes, such as "10.1.0.0/16", that
+	// webhooks may be sent to although they are loopback, link-local or
+	// private, for internal tools.
+	AllowedNetworks []string `yaml:"allowed_networks" toml:"allowed_networks"`
+}
+
+type TracingConfig struct {
+	// Exporter is "none", "otlp", "stdout" or "file".
+	Exporter    string  `yaml:"exporter" toml:"exporter"`
+	Endpoint    string  `yaml:"endpoint" toml:"endpoint"`
+	Insecure    bool    `yaml:"insecure" toml:"insecure"`
+	File        string  `yaml:"file" toml:"file"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+}
+
+type LoggingConfig struct {
+	// Format is "text" or "json".
+	Format string `yaml:"format" toml:"format"`
+	Level  string `yaml:"level" toml:"level"`
+	// Levels overrides Level per component, e.g. {hub: debug}.
+	Levels map[string]string `yaml:"levels" toml:"levels"`
+}
+
+func Default() Config {
+	return Config{
+		Env: EnvDevelopment,
+		HTTP: HTTPConfig{
+			Addr: ":8080",
+			TLS: TLSConfig{
+				ReloadInterval: server.DefaultReloadInterval,
+			},
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Host:          "localhost",
+			Port:          "5432",
+			SSLMode:       "prefer",
+			TxMaxAttempts: 5,
+		},
+		Origins: OriginsConfig{
+			Allowed: []string{"*"},
+		},
+		WebSocket: WebSocketConfig{
+			CompressionLevel:     1,
+			CompressionThreshold: 256,
+			ReadLimit:            8 << 10,
+			WriteTimeout:         10 * time.Second,
+		},
+		Features: FeaturesConfig{
+			ReactionWindow:        250 * time.Millisecond,
+			ReactionFlushInterval: time.Second,
+			IdempotencyTTL:        24 * time.Hour,
+		},
+		Outbox: OutboxConfig{
+			PollInterval: outbox.DefaultPollInterval,
+			BatchSize:    outbox.DefaultBatchSize,
+			ConsumerTTL:  outbox.DefaultConsumerTTL,
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:     webhooks.DefaultTimeout,
+			MaxAttempts: webhooks.DefaultMaxAttempts,
+			Backoff:     webhooks.DefaultBackoff,
+			MaxBackoff:  webhooks.DefaultMaxBackoff,
+			Retention:   webhooks.DefaultRetention,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			SampleRatio: 1,
+		},
+		Logging: LoggingConfig{
+			Format: "text",
+			Level:  "info",
+		},
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// the defaults, the file given by -config or WSRS_CONFIG, the environment
+// (including an optional .env file) and the command line flags in args.
+func Load(args []string) (*Config, error) {
+	cfg, _, err := LoadCommand("wsrs", args)
+	return cfg, err
+}
+
+// LoadCommand is Load for a subcommand called name. It also returns the
+// arguments left after the flags.
+func LoadCommand(name string, args []string) (*Config, []string, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("failed to load .env: %w", err)
+	}
+
+	cfg := Default()
+
+	fset := flag.NewFlagSet(name, flag.ContinueOnError)
+	path := fset.String("config", os.Getenv("WSRS_CONFIG"), "path to a YAML or TOML config file")
+	addr := fset.String("addr", "", "address to listen on")
+	dsn := fset.String("database-url", "", "PostgreSQL connection string")
+	env := fset.String("env", "", "environment: development or production")
+	migrateOnStart := fset.Bool("migrate-on-start", false, "apply pending migrations before serving")
+
+	if err := fset.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *path != "" {
+		if err := loadFile(*path, &cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := loadEnv(&cfg); err != nil {
+		return nil, nil, err
+	}
+
+	fset.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.HTTP.Addr = *addr
+		case "database-url":
+			cfg.Database.DSN = *dsn
+		case "env":
+			cfg.Env = *env
+		case "migrate-on-start":
+			cfg.Database.MigrateOnStart = *migrateOnStart
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return &cfg, fset.Args(), nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("unsupported config file %q: use .yaml, .yml or .toml", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	return nil
+}
+
+func loadEnv(cfg *Config) error {
+	var errs []error
+
+	str := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+
+	list := func(key string, dst *[]string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = splitList(v)
+		}
+	}
+
+	boolean := func(key string, dst *bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	integer := func(key string, dst *int) {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = n
+		}
+	}
+
+	int64Var := func(key string, dst *int64) {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = n
+		}
+	}
+
+	int32Var := func(key string, dst *int32) {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.ParseInt(v, 10, 32)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = int32(n)
+		}
+	}
+
+	float := func(key string, dst *float64) {
+		if v, ok := os.LookupEnv(key); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = f
+		}
+	}
+
+	duration := func(key string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(key); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	str("WSRS_ENV", &cfg.Env)
+	str("WSRS_HTTP_ADDR", &cfg.HTTP.Addr)
+	list("WSRS_HTTP_LISTENERS", &cfg.HTTP.Listeners)
+	str("WSRS_TLS_CERT_FILE", &cfg.HTTP.TLS.CertFile)
+	str("WSRS_TLS_KEY_FILE", &cfg.HTTP.TLS.KeyFile)
+	duration("WSRS_TLS_RELOAD_INTERVAL", &cfg.HTTP.TLS.ReloadInterval)
+	duration("WSRS_SHUTDOWN_TIMEOUT", &cfg.HTTP.ShutdownTimeout)
+	duration("WSRS_SHUTDOWN_DELAY", &cfg.HTTP.ShutdownDelay)
+
+	str("WSRS_DATABASE_URL", &cfg.Database.DSN)
+	str("WSRS_DATABASE_HOST", &cfg.Database.Host)
+	str("WSRS_DATABASE_PORT", &cfg.Database.Port)
+	str("WSRS_DATABASE_NAME", &cfg.Database.Name)
+	str("WSRS_DATABASE_USER", &cfg.Database.User)
+	str("WSRS_DATABASE_PASSWORD", &cfg.Database.Password)
+	str("WSRS_DATABASE_SSLMODE", &cfg.Database.SSLMode)
+	int32Var("WSRS_DATABASE_MAX_CONNS", &cfg.Database.MaxConns)
+	int32Var("WSRS_DATABASE_MIN_CONNS", &cfg.Database.MinConns)
+	duration("WSRS_DATABASE_MAX_CONN_LIFETIME", &cfg.Database.MaxConnLifetime)
+	duration("WSRS_DATABASE_MAX_CONN_IDLE_TIME", &cfg.Database.MaxConnIdleTime)
+	integer("WSRS_DATABASE_TX_MAX_ATTEMPTS", &cfg.Database.TxMaxAttempts)
+	boolean("WSRS_MIGRATE_ON_START", &cfg.Database.MigrateOnStart)
+
+	list("WSRS_ALLOWED_ORIGINS", &cfg.Origins.Allowed)
+	boolean("WSRS_FORCE_PERMISSIVE_ORIGINS", &cfg.Origins.ForcePermissive)
+	list("WSRS_CORS_ALLOWED_ORIGINS", &cfg.CORS.AllowedOrigins)
+	list("WSRS_WS_ALLOWED_ORIGINS", &cfg.WebSocket.AllowedOrigins)
+	boolean("WSRS_WS_COMPRESSION", &cfg.WebSocket.Compression)
+	integer("WSRS_WS_COMPRESSION_LEVEL", &cfg.WebSocket.CompressionLevel)
+	integer("WSRS_WS_COMPRESSION_THRESHOLD", &cfg.WebSocket.CompressionThreshold)
+	int64Var("WSRS_WS_READ_LIMIT", &cfg.WebSocket.ReadLimit)
+	duration("WSRS_WS_WRITE_TIMEOUT", &cfg.WebSocket.WriteTimeout)
+	integer("WSRS_WS_READ_BUFFER_SIZE", &cfg.WebSocket.ReadBufferSize)
+	integer("WSRS_WS_WRITE_BUFFER_SIZE", &cfg.WebSocket.WriteBufferSize)
+
+	duration("WSRS_REACTION_WINDOW", &cfg.Features.ReactionWindow)
+	boolean("WSRS_WRITE_BEHIND_REACTIONS", &cfg.Features.WriteBehindReactions)
+	duration("WSRS_REACTION_FLUSH_INTERVAL", &cfg.Features.ReactionFlushInterval)
+	boolean("WSRS_SINGLE_INSTANCE", &cfg.Features.SingleInstance)
+	boolean("WSRS_VALIDATE_RESPONSES", &cfg.Features.ValidateResponses)
+	duration("WSRS_IDEMPOTENCY_TTL", &cfg.Features.IdempotencyTTL)
+
+	str("WSRS_OUTBOX_INSTANCE", &cfg.Outbox.Instance)
+	duration("WSRS_OUTBOX_POLL_INTERVAL", &cfg.Outbox.PollInterval)
+	integer("WSRS_OUTBOX_BATCH_SIZE", &cfg.Outbox.BatchSize)
+	duration("WSRS_OUTBOX_CONSUMER_TTL", &cfg.Outbox.ConsumerTTL)
+
+	duration("WSRS_WEBHOOK_TIMEOUT", &cfg.Webhooks.Timeout)
+	integer("WSRS_WEBHOOK_MAX_ATTEMPTS", &cfg.Webhooks.MaxAttempts)
+	duration("WSRS_WEBHOOK_BACKOFF", &cfg.Webhooks.Backoff)
+	duration("WSRS_WEBHOOK_MAX_BACKOFF", &cfg.Webhooks.MaxBackoff)
+	duration("WSRS_WEBHOOK_RETENTION", &cfg.Webhooks.Retention)
+	list("WSRS_WEBHOOK_ALLOWED_NETWORKS", &cfg.Webhooks.AllowedNetworks)
+
+	str("WSRS_TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	str("WSRS_TRACING_ENDPOINT", &cfg.Tracing.Endpoint)
+	boolean("WSRS_TRACING_INSECURE", &cfg.Tracing.Insecure)
+	str("WSRS_TRACING_FILE", &cfg.Tracing.File)
+	float("WSRS_TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio)
+
+	str("WSRS_LOG_FORMAT", &cfg.Logging.Format)
+	str("WSRS_LOG_LEVEL", &cfg.Logging.Level)
+	if v, ok := os.LookupEnv("WSRS_LOG_LEVELS"); ok {
+		levels, err := splitMap(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("WSRS_LOG_LEVELS: %w", err))
+		}
+		cfg.Logging.Levels = levels
+	}
+
+	return errors.Join(errs...)
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// splitMap parses "key=value,key=value".
+func splitMap(v string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, item := range splitList(v) {
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected key=value, got %q", item)
+		}
+		m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return m, nil
+}
+
+// ParseListeners returns the listeners to serve on. It fails if a TLS
+// listener is configured without a certificate.
+func (c *HTTPConfig) ParseListeners() ([]server.Listener, error) {
+	specs := c.Listeners
+	if len(specs) == 0 {
+		specs = []string{"http://" + c.Addr}
+	}
+
+	var listeners []server.Listener
+	for _, spec := range specs {
+		l, err := server.ParseListener(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		if l.TLS && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+			return nil, fmt.Errorf("listener %q: http.tls.cert_file and http.tls.key_file are required", spec)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// CORSOrigins returns the origin patterns allowed by CORS in c.Env.
+func (c *Config) CORSOrigins() []string {
+	if c.CORS.AllowedOrigins != nil {
+		return c.CORS.AllowedOrigins
+	}
+
+	return c.allowedOrigins()
+}
+
+// WebSocketOrigins returns the origin patterns allowed to subscribe in c.Env.
+func (c *Config) WebSocketOrigins() []string {
+	if c.WebSocket.AllowedOrigins != nil {
+		return c.WebSocket.AllowedOrigins
+	}
+
+	return c.allowedOrigins()
+}
+
+func (c *Config) allowedOrigins() []string {
+	if patterns, ok := c.Origins.Environments[c.Env]; ok {
+		return patterns
+	}
+
+	return c.Origins.Allowed
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		errs = append(errs, fmt.Errorf("env must be %q or %q, got %q", EnvDevelopment, EnvProduction, c.Env))
+	}
+
+	if c.HTTP.Addr == "" && len(c.HTTP.Listeners) == 0 {
+		errs = append(errs, errors.New("http.addr or http.listeners is required"))
+	}
+
+	if _, err := c.HTTP.ParseListeners(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if c.HTTP.TLS.ReloadInterval < 0 {
+		errs = append(errs, errors.New("http.tls.reload_interval must not be negative"))
+	}
+
+	if c.HTTP.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("http.shutdown_timeout must be positive"))
+	}
+
+	if c.HTTP.ShutdownDelay < 0 {
+		errs = append(errs, errors.New("http.shutdown_delay must not be negative"))
+	}
+
+	if c.Database.DSN == "" && c.Database.Name == "" {
+		errs = append(errs, errors.New("database.dsn or database.name is required"))
+	}
+
+	if c.Database.MaxConns < 0 || c.Database.MinConns < 0 {
+		errs = append(errs, errors.New("database pool sizes must not be negative"))
+	}
+
+	if c.Database.MaxConns > 0 && c.Database.MinConns > c.Database.MaxConns {
+		errs = append(errs, errors.New("database.min_conns must not exceed database.max_conns"))
+	}
+
+	if c.Database.MaxConnLifetime < 0 || c.Database.MaxConnIdleTime < 0 {
+		errs = append(errs, errors.New("database connection lifetimes must not be negative"))
+	}
+
+	if c.Database.TxMaxAttempts < 1 {
+		errs = append(errs, errors.New("database.tx_max_attempts must be at least 1"))
+	}
+
+	origins := []struct {
+		name     string
+		patterns []string
+	}{
+		{"cors", c.CORSOrigins()},
+		{"websocket", c.WebSocketOrigins()},
+	}
+	for _, o := range origins {
+		policy, err := origin.Parse(o.patterns)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s origins: %w", o.name, err))
+			continue
+		}
+
+		if c.Env == EnvProduction && policy.Permissive() && !c.Origins.ForcePermissive {
+			errs = append(errs, fmt.Errorf("%s origins %q allow any origin in production; list the allowed origins or set origins.force_permissive", o.name, policy))
+		}
+	}
+
+	if c.WebSocket.CompressionLevel < -2 || c.WebSocket.CompressionLevel > 9 {
+		errs = append(errs, errors.New("websocket.compression_level must be between -2 and 9"))
+	}
+
+	if c.WebSocket.CompressionThreshold < 0 || c.WebSocket.ReadLimit < 0 || c.WebSocket.WriteTimeout < 0 {
+		errs = append(errs, errors.New("websocket limits must not be negative"))
+	}
+
+	if c.WebSocket.ReadBufferSize < 0 || c.WebSocket.WriteBufferSize < 0 {
+		errs = append(errs, errors.New("websocket buffer sizes must not be negative"))
+	}
+
+	if c.Features.ReactionWindow < 0 {
+		errs = append(errs, errors.New("features.reaction_window must not be negative"))
+	}
+
+	if c.Features.IdempotencyTTL < 0 {
+		errs = append(errs, errors.New("features.idempotency_ttl must not be negative"))
+	}
+
+	if c.Features.WriteBehindReactions && c.Features.ReactionFlushInterval <= 0 {
+		errs = append(errs, errors.New("features.reaction_flush_interval must be positive when write_behind_reactions is enabled"))
+	}
+
+	if c.Features.WriteBehindReactions && !c.Features.SingleInstance {
+		errs = append(errs, errors.New("features.write_behind_reactions requires features.single_instance"))
+	}
+
+	if c.Outbox.PollInterval <= 0 || c.Outbox.ConsumerTTL <= 0 {
+		errs = append(errs, errors.New("outbox.poll_interval and outbox.consumer_ttl must be positive"))
+	}
+
+	if c.Outbox.BatchSize < 1 {
+		errs = append(errs, errors.New("outbox.batch_size must be at least 1"))
+	}
+
+	if c.Webhooks.Timeout <= 0 || c.Webhooks.Backoff <= 0 || c.Webhooks.Retention <= 0 {
+		errs = append(errs, errors.New("webhooks.timeout, webhooks.backoff and webhooks.retention must be positive"))
+	}
+
+	if c.Webhooks.MaxBackoff < c.Webhooks.Backoff {
+		errs = append(errs, errors.New("webhooks.max_backoff must not be less than webhooks.backoff"))
+	}
+
+	if c.Webhooks.MaxAttempts < 1 {
+		errs = append(errs, errors.New("webhooks.max_attempts must be at least 1"))
+	}
+
+	if _, err := webhooks.ParseNetworks(c.Webhooks.AllowedNetworks); err != nil {
+		errs = append(errs, fmt.Errorf("webhooks.allowed_networks: %w", err))
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	case "file":
+		if c.Tracing.File == "" {
+			errs = append(errs, errors.New("tracing.file is required with the file exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be none, otlp, stdout or file, got %q", c.Tracing.Exporter))
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing.sample_ratio must be between 0 and 1"))
+	}
+
+	if c.Logging.Format != "text" && c.Logging.Format != "json" {
+		errs = append(errs, fmt.Errorf("logging.format must be text or json, got %q", c.Logging.Format))
+	}
+
+	for component, level := range c.Logging.Levels {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err != nil {
+			errs = append(errs, fmt.Errorf("logging.levels.%s: %w", component, err))
+		}
+	}
+
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("logging.level: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// PoolConfig returns the pgxpool configuration for the database settings.
+func (d DatabaseConfig) PoolConfig() (*pgxpool.Config, error) {
+	dsn := d.DSN
+	if dsn == "" {
+		dsn = fmt.Sprintf(
+			"user=%s password=%s host=%s port=%s dbname=%s sslmode=%s",
+			quote(d.User), quote(d.Password), quote(d.Host), quote(d.Port), quote(d.Name), quote(d.SSLMode),
+		)
+	}
+
+	pc, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database configuration: %w", err)
+	}
+
+	if d.MaxConns > 0 {
+		pc.MaxConns = d.MaxConns
+	}
+	if d.MinConns > 0 {
+		pc.MinConns = d.MinConns
+	}
+	if d.MaxConnLifetime > 0 {
+		pc.MaxConnLifetime = d.MaxConnLifetime
+	}
+	if d.MaxConnIdleTime > 0 {
+		pc.MaxConnIdleTime = d.MaxConnIdleTime
+	}
+
+	return pc, nil
+}
+
+// quote escapes a value for a keyword/value connection string.
+func quote(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
@@ -0,0 +1,310 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets the WSRS_ variables for the duration of the test.
+func clearEnv(t *testing.T) {
+	t.Helper()
+
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(key, "WSRS_") {
+			t.Setenv(key, "")
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "wsrs.yaml", `
+http:
+  addr: ":8001"
+database:
+  name: ama
+websocket:
+  compression_level: 5
+`)
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"defaults", nil, []string{"-database-url", "postgres://localhost/ama"}, ":8080"},
+		{"file over defaults", nil, []string{"-config", file}, ":8001"},
+		{"env over file", map[string]string{"WSRS_HTTP_ADDR": ":8002"}, []string{"-config", file}, ":8002"},
+		{"flags over env", map[string]string{"WSRS_HTTP_ADDR": ":8002"}, []string{"-config", file, "-addr", ":8003"}, ":8003"},
+		{"file from env", map[string]string{"WSRS_CONFIG": file}, nil, ":8001"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			cfg, err := Load(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.HTTP.Addr != tt.want {
+				t.Errorf("http.addr = %q, want %q", cfg.HTTP.Addr, tt.want)
+			}
+		})
+	}
+
+	t.Run("settings missing from a layer are kept", func(t *testing.T) {
+		clearEnv(t)
+		t.Setenv("WSRS_HTTP_ADDR", ":8002")
+
+		cfg, err := Load([]string{"-config", file})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cfg.WebSocket.CompressionLevel != 5 || cfg.Database.Name != "ama" {
+			t.Errorf("compression level %d and database %q, want the file's", cfg.WebSocket.CompressionLevel, cfg.Database.Name)
+		}
+		if cfg.WebSocket.CompressionThreshold != Default().WebSocket.CompressionThreshold {
+			t.Errorf("compression threshold %d, want the default", cfg.WebSocket.CompressionThreshold)
+		}
+	})
+
+	t.Run("invalid env values are reported", func(t *testing.T) {
+		clearEnv(t)
+		t.Setenv("WSRS_WS_WRITE_TIMEOUT", "soon")
+		t.Setenv("WSRS_WS_COMPRESSION", "maybe")
+
+		_, err := Load([]string{"-config", file})
+		if err == nil || !strings.Contains(err.Error(), "WSRS_WS_WRITE_TIMEOUT") || !strings.Contains(err.Error(), "WSRS_WS_COMPRESSION") {
+			t.Errorf("got %v, want both variables reported", err)
+		}
+	})
+}
+
+func TestLoadFile(t *testing.T) {
+	want := Default()
+	want.Env = EnvProduction
+	want.HTTP.Listeners = []string{"http://:8080", "unix:///run/wsrs.sock"}
+	want.HTTP.ShutdownTimeout = 30 * time.Second
+	want.Database.DSN = "postgres://db/ama"
+	want.Database.MaxConns = 20
+	want.Origins.Allowed = []string{"https://ama.example.com"}
+	want.Origins.Environments = map[string][]string{"development": {"*"}}
+	want.WebSocket.Compression = true
+	want.Features.ReactionWindow = 100 * time.Millisecond
+	want.Tracing.SampleRatio = 0.25
+	want.Logging.Levels = map[string]string{"hub": "debug"}
+
+	files := map[string]string{
+		"wsrs.yaml": `
+env: production
+http:
+  listeners: ["http://:8080", "unix:///run/wsrs.sock"]
+  shutdown_timeout: 30s
+database:
+  dsn: postgres://db/ama
+  max_conns: 20
+origins:
+  allowed: ["https://ama.example.com"]
+  environments:
+    development: ["*"]
+websocket:
+  compression: true
+features:
+  reaction_window: 100ms
+tracing:
+  sample_ratio: 0.25
+logging:
+  levels:
+    hub: debug
+`,
+		"wsrs.toml": `
+env = "production"
+
+[http]
+listeners = ["http://:8080", "unix:///run/wsrs.sock"]
+shutdown_timeout = "30s"
+
+[database]
+dsn = "postgres://db/ama"
+max_conns = 20
+
+[origins]
+allowed = ["https://ama.example.com"]
+
+[origins.environments]
+development = ["*"]
+
+[websocket]
+compression = true
+
+[features]
+reaction_window = "100ms"
+
+[tracing]
+sample_ratio = 0.25
+
+[logging.levels]
+hub = "debug"
+`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			clearEnv(t)
+
+			cfg, err := Load([]string{"-config", writeFile(t, name, content)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(*cfg, want) {
+				t.Errorf("got %+v\nwant %+v", *cfg, want)
+			}
+		})
+	}
+
+	t.Run("unsupported extension", func(t *testing.T) {
+		clearEnv(t)
+
+		_, err := Load([]string{"-config", writeFile(t, "wsrs.json", "{}")})
+		if err == nil || !strings.Contains(err.Error(), "unsupported config file") {
+			t.Errorf("got %v, want an unsupported file error", err)
+		}
+	})
+
+	t.Run("malformed file", func(t *testing.T) {
+		clearEnv(t)
+
+		_, err := Load([]string{"-config", writeFile(t, "wsrs.toml", "env = ")})
+		if err == nil || !strings.Contains(err.Error(), "failed to parse config file") {
+			t.Errorf("got %v, want a parse error", err)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{"env", func(c *Config) { c.Env = "staging" }, `env must be "development" or "production"`},
+		{"no address", func(c *Config) { c.HTTP.Addr = "" }, "http.addr or http.listeners is required"},
+		{"bad listener", func(c *Config) { c.HTTP.Listeners = []string{"ftp://:21"} }, "ftp"},
+		{"tls listener without certificate", func(c *Config) { c.HTTP.Listeners = []string{"https://:8443"} }, "http.tls.cert_file and http.tls.key_file are required"},
+		{"reload interval", func(c *Config) { c.HTTP.TLS.ReloadInterval = -1 }, "http.tls.reload_interval must not be negative"},
+		{"shutdown timeout", func(c *Config) { c.HTTP.ShutdownTimeout = 0 }, "http.shutdown_timeout must be positive"},
+		{"shutdown delay", func(c *Config) { c.HTTP.ShutdownDelay = -1 }, "http.shutdown_delay must not be negative"},
+		{"no database", func(c *Config) { c.Database.Name = "" }, "database.dsn or database.name is required"},
+		{"pool size", func(c *Config) { c.Database.MaxConns = -1 }, "database pool sizes must not be negative"},
+		{"min over max", func(c *Config) { c.Database.MaxConns, c.Database.MinConns = 2, 4 }, "database.min_conns must not exceed database.max_conns"},
+		{"connection lifetime", func(c *Config) { c.Database.MaxConnIdleTime = -1 }, "database connection lifetimes must not be negative"},
+		{"tx attempts", func(c *Config) { c.Database.TxMaxAttempts = 0 }, "database.tx_max_attempts must be at least 1"},
+		{"invalid origin", func(c *Config) { c.Origins.Allowed = []string{"re:("} }, "cors origins"},
+		{"invalid websocket origin", func(c *Config) { c.WebSocket.AllowedOrigins = []string{"re:("} }, "websocket origins"},
+		{"permissive in production", func(c *Config) { c.Env = EnvProduction }, "allow any origin in production"},
+		{"permissive websocket in production", func(c *Config) {
+			c.Env = EnvProduction
+			c.Origins.Allowed = []string{"https://ama.example.com"}
+			c.WebSocket.AllowedOrigins = []string{"https://*"}
+		}, "websocket origins"},
+		{"compression level", func(c *Config) { c.WebSocket.CompressionLevel = 10 }, "websocket.compression_level must be between -2 and 9"},
+		{"websocket limits", func(c *Config) { c.WebSocket.ReadLimit = -1 }, "websocket limits must not be negative"},
+		{"websocket buffers", func(c *Config) { c.WebSocket.WriteBufferSize = -1 }, "websocket buffer sizes must not be negative"},
+		{"reaction window", func(c *Config) { c.Features.ReactionWindow = -1 }, "features.reaction_window must not be negative"},
+		{"idempotency ttl", func(c *Config) { c.Features.IdempotencyTTL = -1 }, "features.idempotency_ttl must not be negative"},
+		{"flush interval", func(c *Config) {
+			c.Features.WriteBehindReactions, c.Features.SingleInstance = true, true
+			c.Features.ReactionFlushInterval = 0
+		}, "features.reaction_flush_interval must be positive"},
+		{"write-behind with several instances", func(c *Config) { c.Features.WriteBehindReactions = true }, "features.write_behind_reactions requires features.single_instance"},
+		{"outbox intervals", func(c *Config) { c.Outbox.PollInterval = 0 }, "outbox.poll_interval and outbox.consumer_ttl must be positive"},
+		{"outbox batch", func(c *Config) { c.Outbox.BatchSize = 0 }, "outbox.batch_size must be at least 1"},
+		{"webhook durations", func(c *Config) { c.Webhooks.Retention = 0 }, "webhooks.timeout, webhooks.backoff and webhooks.retention must be positive"},
+		{"webhook max backoff", func(c *Config) { c.Webhooks.MaxBackoff = c.Webhooks.Backoff - 1 }, "webhooks.max_backoff must not be less than webhooks.backoff"},
+		{"webhook attempts", func(c *Config) { c.Webhooks.MaxAttempts = 0 }, "webhooks.max_attempts must be at least 1"},
+		{"webhook networks", func(c *Config) { c.Webhooks.AllowedNetworks = []string{"10.0.0.0/33"} }, "webhooks.allowed_networks"},
+		{"tracing file", func(c *Config) { c.Tracing.Exporter = "file" }, "tracing.file is required with the file exporter"},
+		{"tracing exporter", func(c *Config) { c.Tracing.Exporter = "zipkin" }, "tracing.exporter must be none, otlp, stdout or file"},
+		{"sample ratio", func(c *Config) { c.Tracing.SampleRatio = 2 }, "tracing.sample_ratio must be between 0 and 1"},
+		{"log format", func(c *Config) { c.Logging.Format = "xml" }, "logging.format must be text or json"},
+		{"component level", func(c *Config) { c.Logging.Levels = map[string]string{"hub": "loud"} }, "logging.levels.hub"},
+		{"log level", func(c *Config) { c.Logging.Level = "loud" }, "logging.level"},
+	}
+
+	valid := func() Config {
+		cfg := Default()
+		cfg.Database.Name = "ama"
+		return cfg
+	}
+
+	base := valid()
+	if err := base.Validate(); err != nil {
+		t.Fatalf("defaults with a database: %v", err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("forced permissive origins in production", func(t *testing.T) {
+		cfg := valid()
+		cfg.Env = EnvProduction
+		cfg.Origins.ForcePermissive = true
+
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("got %v, want no error", err)
+		}
+	})
+
+	t.Run("listed origins in production", func(t *testing.T) {
+		cfg := valid()
+		cfg.Env = EnvProduction
+		cfg.Origins.Allowed = []string{"https://ama.example.com", "https://*.ama.example.com"}
+
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("got %v, want no error", err)
+		}
+	})
+
+	t.Run("environment origins in production", func(t *testing.T) {
+		cfg := valid()
+		cfg.Env = EnvProduction
+		cfg.Origins.Environments = map[string][]string{EnvProduction: {"https://ama.example.com"}}
+
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("got %v, want no error", err)
+		}
+	})
+}
@@ -0,0 +1,171 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/thiagoleet/go-ama-api/internal/buildinfo"
+	"github.com/thiagoleet/go-ama-api/internal/store/migrate"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency is usable. details, if not nil, is
+// included in the readiness report.
+type Check func(ctx context.Context) (details any, err error)
+
+type Result struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Details any    `json:"details,omitempty"`
+}
+
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Health serves the liveness, readiness and version endpoints.
+type Health struct {
+	mu     sync.Mutex
+	checks map[string]Check
+
+	shuttingDown atomic.Bool
+}
+
+func New() *Health {
+	return &Health{
+		checks: make(map[string]Check),
+	}
+}
+
+// AddCheck registers a readiness check under name.
+func (h *Health) AddCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks[name] = check
+}
+
+// SetShuttingDown makes readiness fail from now on, so the orchestrator
+// stops routing traffic while the server drains.
+func (h *Health) SetShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+func (h *Health) HandleLiveness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *Health) HandleReadiness(w http.ResponseWriter, r *http.Request) {
+	report := h.Check(r.Context())
+
+	status := http.StatusOK
+	if report.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, report)
+}
+
+func (h *Health) HandleVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildinfo.Get())
+}
+
+// Check runs every readiness check concurrently.
+func (h *Health) Check(ctx context.Context) Report {
+	h.mu.Lock()
+	checks := make(map[string]Check, len(h.checks))
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	report := Report{Status: "ready", Checks: make(map[string]Result, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			details, err := check(ctx)
+			result := Result{Status: "ok", Details: details}
+			if err != nil {
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = "not_ready"
+			}
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	if h.shuttingDown.Load() {
+		report.Status = "shutting_down"
+	}
+
+	return report
+}
+
+// DatabaseCheck pings the pool.
+func DatabaseCheck(pool *pgxpool.Pool) Check {
+	return func(ctx context.Context) (any, error) {
+		stat := pool.Stat()
+		details := map[string]int32{
+			"total_conns":    stat.TotalConns(),
+			"acquired_conns": stat.AcquiredConns(),
+			"idle_conns":     stat.IdleConns(),
+		}
+
+		return details, pool.Ping(ctx)
+	}
+}
+
+// SchemaCheck fails unless the database was migrated to the version this
+// build expects.
+func SchemaCheck(q *pgstore.Queries) Check {
+	expected := migrate.Latest()
+
+	return func(ctx context.Context) (any, error) {
+		version, err := q.SchemaVersion(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		details := map[string]int32{
+			"expected": expected,
+			"current":  version,
+		}
+
+		if version != expected {
+			return details, fmt.Errorf("schema version %d, expected %d", version, expected)
+		}
+
+		return details, nil
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,195 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/thiagoleet/go-ama-api/internal/buildinfo"
+	"github.com/thiagoleet/go-ama-api/internal/store/migrate"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+// schemaDB answers the schema version query with version, or err.
+type schemaDB struct {
+	version int32
+	err     error
+}
+
+func (db schemaDB) Exec(context.Context, string, ...any) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, errors.New("unexpected exec")
+}
+
+func (db schemaDB) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	return nil, errors.New("unexpected query")
+}
+
+func (db schemaDB) QueryRow(context.Context, string, ...any) pgx.Row { return db }
+
+func (db schemaDB) Scan(dest ...any) error {
+	if db.err != nil {
+		return db.err
+	}
+
+	*dest[0].(*int32) = db.version
+	return nil
+}
+
+func readiness(t *testing.T, h *Health) (int, Report) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.HandleReadiness(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control %q, want no-store", got)
+	}
+
+	var report Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+
+	return w.Code, report
+}
+
+func ok(context.Context) (any, error) { return map[string]int{"n": 1}, nil }
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name         string
+		checks       map[string]Check
+		shuttingDown bool
+		status       int
+		want         string
+	}{
+		{"no checks", nil, false, http.StatusOK, "ready"},
+		{"passing checks", map[string]Check{"a": ok, "b": ok}, false, http.StatusOK, "ready"},
+		{"failing check", map[string]Check{"a": ok, "b": func(context.Context) (any, error) {
+			return nil, errors.New("unreachable")
+		}}, false, http.StatusServiceUnavailable, "not_ready"},
+		{"shutting down", map[string]Check{"a": ok}, true, http.StatusServiceUnavailable, "shutting_down"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New()
+			for name, check := range tt.checks {
+				h.AddCheck(name, check)
+			}
+			if tt.shuttingDown {
+				h.SetShuttingDown()
+			}
+
+			status, report := readiness(t, h)
+			if status != tt.status || report.Status != tt.want {
+				t.Errorf("got %d %s, want %d %s", status, report.Status, tt.status, tt.want)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Errorf("reported %d checks, want %d", len(report.Checks), len(tt.checks))
+			}
+		})
+	}
+}
+
+func TestCheckReportsEachResult(t *testing.T) {
+	h := New()
+	h.AddCheck("ok", ok)
+	h.AddCheck("down", func(context.Context) (any, error) { return "details", errors.New("unreachable") })
+
+	report := h.Check(context.Background())
+
+	if got := report.Checks["ok"]; got.Status != "ok" || got.Error != "" || got.Details == nil {
+		t.Errorf("ok check reported %+v", got)
+	}
+	if got := report.Checks["down"]; got.Status != "fail" || got.Error != "unreachable" || got.Details != "details" {
+		t.Errorf("failing check reported %+v", got)
+	}
+}
+
+func TestCheckRunsConcurrentlyWithDeadline(t *testing.T) {
+	h := New()
+
+	// Each check waits for the other one: run one after the other, they
+	// would only return when the deadline passes.
+	started := [2]chan struct{}{make(chan struct{}), make(chan struct{})}
+	for i, name := range []string{"a", "b"} {
+		i := i
+		h.AddCheck(name, func(ctx context.Context) (any, error) {
+			if _, ok := ctx.Deadline(); !ok {
+				return nil, errors.New("no deadline")
+			}
+
+			close(started[i])
+			select {
+			case <-started[1-i]:
+				return nil, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		})
+	}
+
+	if report := h.Check(context.Background()); report.Status != "ready" {
+		t.Errorf("got %+v, want both checks to pass", report)
+	}
+}
+
+func TestLivenessAndVersion(t *testing.T) {
+	h := New()
+	h.AddCheck("down", func(context.Context) (any, error) { return nil, errors.New("unreachable") })
+	h.SetShuttingDown()
+
+	w := httptest.NewRecorder()
+	h.HandleLiveness(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("liveness %d while not ready, want %d", w.Code, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	h.HandleVersion(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+
+	var info buildinfo.Info
+	if err := json.NewDecoder(w.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info != buildinfo.Get() {
+		t.Errorf("version %+v, want %+v", info, buildinfo.Get())
+	}
+}
+
+func TestSchemaCheck(t *testing.T) {
+	latest := migrate.Latest()
+
+	tests := []struct {
+		name    string
+		db      schemaDB
+		wantErr bool
+	}{
+		{"migrated", schemaDB{version: latest}, false},
+		{"behind", schemaDB{version: latest - 1}, true},
+		{"ahead", schemaDB{version: latest + 1}, true},
+		{"not migrated", schemaDB{err: errors.New(`relation "schema_version" does not exist`)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			details, err := SchemaCheck(pgstore.New(tt.db))(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want one: %t", err, tt.wantErr)
+			}
+
+			if tt.db.err == nil {
+				want := map[string]int32{"expected": latest, "current": tt.db.version}
+				if got, _ := details.(map[string]int32); got["expected"] != want["expected"] || got["current"] != want["current"] {
+					t.Errorf("details %v, want %v", details, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
+	"github.com/thiagoleet/go-ama-api/internal/wire"
+)
+
+// ErrClosed is returned by Subscribe once the hub is shutting down.
+var ErrClosed = errors.New("hub: closed")
+
+const closeTimeout = time.Second
+
+// Client is a WebSocket subscribed to a room. Writes to the underlying
+// connection are serialised, so broadcasts and command replies can be sent
+// from different goroutines.
+type Client struct {
+	conn   *websocket.Conn
+	codec  wire.Codec
+	opts   *Options
+	roomID string
+	cancel context.CancelFunc
+	mu     sync.Mutex
+}
+
+// Send writes msg in the encoding negotiated by the client.
+func (c *Client) Send(msg entity.Message) error {
+	data, err := c.codec.Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.beforeWrite(len(data))
+	if err := c.conn.WriteMessage(c.codec.MessageType(), data); err != nil {
+		return err
+	}
+
+	metrics.WebSocketPayloadBytes.WithLabelValues(c.roomID).Add(float64(len(data)))
+	return nil
+}
+
+func (c *Client) writePrepared(pm preparedMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.beforeWrite(pm.size)
+	if err := c.conn.WritePreparedMessage(pm.message); err != nil {
+		return err
+	}
+
+	metrics.WebSocketPayloadBytes.WithLabelValues(c.roomID).Add(float64(pm.size))
+	return nil
+}
+
+// beforeWrite sets the write deadline and only compresses frames of at least
+// CompressionThreshold bytes. It must be called with c.mu held.
+func (c *Client) beforeWrite(size int) {
+	if c.opts.WriteTimeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout))
+	}
+
+	c.conn.EnableWriteCompression(c.opts.Compression && size >= c.opts.CompressionThreshold)
+}
+
+// close sends a close frame with code and reason, then releases the
+// subscription so the connection is torn down.
+func (c *Client) close(code int, reason string) {
+	c.mu.Lock()
+	err := c.conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(closeTimeout),
+	)
+	c.mu.Unlock()
+
+	if err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+		logging.For("hub").Warn("failed to send close frame", "error", err)
+	}
+
+	c.cancel()
+}
+
+type Options struct {
+	// Compression enables permessage-deflate on connections that negotiated it.
+	Compression bool
+	// CompressionLevel is the flate level used for compressed frames.
+	CompressionLevel int
+	// CompressionThreshold is the smallest payload, in bytes, that is sent
+	// compressed. Smaller frames cost more to deflate than they save.
+	CompressionThreshold int
+	// ReadLimit is the largest frame accepted from a client. Zero means no
+	// limit.
+	ReadLimit int64
+	// WriteTimeout bounds every write, so a slow client can't hold up a
+	// broadcast. Zero disables the deadline.
+	WriteTimeout time.Duration
+}
+
+// Hub keeps the clients subscribed to each room and fans events out to them.
+type Hub struct {
+	opts Options
+
+	mu     sync.RWMutex
+	rooms  map[string]map[*Client]struct{}
+	closed bool
+}
+
+func New(opts Options) *Hub {
+	return &Hub{
+		opts:  opts,
+		rooms: make(map[string]map[*Client]struct{}),
+	}
+}
+
+// Subscribe registers conn in roomID. Events are encoded for the
+// subprotocol negotiated on conn. cancel is called when a write to the
+// connection fails or the hub shuts down.
+func (h *Hub) Subscribe(roomID string, conn *websocket.Conn, cancel context.CancelFunc) (*Client, error) {
+	if h.opts.Compression {
+		if err := conn.SetCompressionLevel(h.opts.CompressionLevel); err != nil {
+			logging.For("hub").Warn("invalid compression level", "level", h.opts.CompressionLevel, "error", err)
+		}
+	}
+	if h.opts.ReadLimit > 0 {
+		conn.SetReadLimit(h.opts.ReadLimit)
+	}
+
+	c := &Client{
+		conn:   conn,
+		codec:  wire.ForSubprotocol(conn.Subprotocol()),
+		opts:   &h.opts,
+		roomID: roomID,
+		cancel: cancel,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		go c.close(websocket.CloseGoingAway, "server going away")
+		return nil, ErrClosed
+	}
+
+	if _, ok := h.rooms[roomID]; !ok {
+		h.rooms[roomID] = make(map[*Client]struct{})
+	}
+	h.rooms[roomID][c] = struct{}{}
+	metrics.WebSocketConnections.WithLabelValues(roomID).Set(float64(len(h.rooms[roomID])))
+
+	return c, nil
+}
+
+func (h *Hub) Unsubscribe(roomID string, c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.rooms[roomID], c)
+	if len(h.rooms[roomID]) == 0 {
+		delete(h.rooms, roomID)
+		metrics.DeleteWebSocketRoom(roomID)
+		return
+	}
+
+	metrics.WebSocketConnections.WithLabelValues(roomID).Set(float64(len(h.rooms[roomID])))
+}
+
+// Count returns how many connections are subscribed to roomID.
+func (h *Hub) Count(roomID string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.rooms[roomID])
+}
+
+type Stats struct {
+	Rooms       int  `json:"rooms"`
+	Connections int  `json:"connections"`
+	Closed      bool `json:"closed"`
+}
+
+func (h *Hub) Stats() Stats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	stats := Stats{Rooms: len(h.rooms), Closed: h.closed}
+	for _, room := range h.rooms {
+		stats.Connections += len(room)
+	}
+
+	return stats
+}
+
+// Broadcast sends msg to every client in msg.RoomId. The event is stamped
+// and encoded once per subprotocol in use and the same frame is written to
+// every connection; the room lock is only held while taking a snapshot of
+// its clients.
+func (h *Hub) Broadcast(msg entity.Message) {
+	clients := h.clients(msg.RoomId)
+	if len(clients) == 0 {
+		return
+	}
+
+	msg = msg.Stamped()
+
+	start := time.Now()
+	defer func() {
+		metrics.BroadcastDuration.WithLabelValues(msg.Kind).Observe(time.Since(start).Seconds())
+	}()
+
+	prepared := make(map[wire.Codec]preparedMessage, 1)
+
+	for _, c := range clients {
+		pm, ok := prepared[c.codec]
+		if !ok {
+			var err error
+			pm, err = prepare(c.codec, msg)
+			if err != nil {
+				logging.For("hub").Error("failed to encode message", "kind", msg.Kind, "subprotocol", c.codec.Subprotocol(), "error", err)
+			}
+			prepared[c.codec] = pm
+		}
+
+		if pm.message == nil {
+			metrics.BroadcastFailures.WithLabelValues(msg.Kind).Inc()
+			continue
+		}
+
+		if err := c.writePrepared(pm); err != nil {
+			logging.For("hub").Error("failed to send message to client", "error", err)
+			metrics.BroadcastFailures.WithLabelValues(msg.Kind).Inc()
+			c.cancel()
+		}
+	}
+}
+
+// Shutdown refuses new subscriptions and sends a "server going away" close
+// frame to every subscriber, so clients reconnect to another instance.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closed = true
+	var clients []*Client
+	for _, room := range h.rooms {
+		for c := range room {
+			clients = append(clients, c)
+		}
+	}
+	h.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			c.close(websocket.CloseGoingAway, "server going away")
+		}(c)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *Hub) clients(roomID string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	room := h.rooms[roomID]
+	clients := make([]*Client, 0, len(room))
+	for c := range room {
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+// preparedMessage is a frame shared by every client using the same codec,
+// with the size of its uncompressed payload.
+type preparedMessage struct {
+	message *websocket.PreparedMessage
+	size    int
+}
+
+func prepare(codec wire.Codec, msg entity.Message) (preparedMessage, error) {
+	data, err := codec.Encode(msg)
+	if err != nil {
+		return preparedMessage{}, err
+	}
+
+	pm, err := websocket.NewPreparedMessage(codec.MessageType(), data)
+	if err != nil {
+		return preparedMessage{}, err
+	}
+
+	return preparedMessage{message: pm, size: len(data)}, nil
+}
@@ -0,0 +1,381 @@
+package hub
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
+	"github.com/thiagoleet/go-ama-api/internal/wire"
+)
+
+const benchSubscribers = 10_000
+
+// discardConn is a connection that accepts every write, so benchmarks
+// measure encoding and framing rather than the network.
+type discardConn struct {
+	closed chan struct{}
+}
+
+func newDiscardConn() *discardConn {
+	return &discardConn{closed: make(chan struct{})}
+}
+
+func (c *discardConn) Read([]byte) (int, error) {
+	<-c.closed
+	return 0, net.ErrClosed
+}
+
+func (c *discardConn) Write(p []byte) (int, error)      { return len(p), nil }
+func (c *discardConn) Close() error                     { close(c.closed); return nil }
+func (c *discardConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (c *discardConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (c *discardConn) SetDeadline(time.Time) error      { return nil }
+func (c *discardConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *discardConn) SetWriteDeadline(time.Time) error { return nil }
+
+// hijacker hands conn to the upgrader in place of a real HTTP connection.
+type hijacker struct {
+	*httptest.ResponseRecorder
+	conn net.Conn
+}
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return h.conn, bufio.NewReadWriter(bufio.NewReader(h.conn), bufio.NewWriter(h.conn)), nil
+}
+
+// upgrade returns a server side WebSocket over conn, with permessage-deflate
+// negotiated when compress is set.
+func upgrade(tb testing.TB, conn net.Conn, compress bool) *websocket.Conn {
+	tb.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/subscribe/room", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "13")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	if compress {
+		r.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+	}
+
+	ws, err := (&websocket.Upgrader{EnableCompression: compress}).Upgrade(hijacker{httptest.NewRecorder(), conn}, r, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return ws
+}
+
+func subscribeMany(b *testing.B, h *Hub, roomID string, n int) []*Client {
+	b.Helper()
+
+	clients := make([]*Client, 0, n)
+	for i := 0; i < n; i++ {
+		conn := newDiscardConn()
+		b.Cleanup(func() { _ = conn.Close() })
+
+		c, err := h.Subscribe(roomID, upgrade(b, conn, h.opts.Compression), func() {})
+		if err != nil {
+			b.Fatal(err)
+		}
+		clients = append(clients, c)
+	}
+
+	return clients
+}
+
+func benchMessage(roomID string) entity.Message {
+	return entity.Message{
+		Kind:   entity.MessageKindMessageCreated,
+		RoomId: roomID,
+		Value: entity.MessageMessageCreated{
+			ID:      "0b3f3c1e-8f0a-4d55-9a3e-52d1c0b5c1a7",
+			Message: "What is the plan for the next release?",
+		},
+	}
+}
+
+// BenchmarkBroadcast compares encoding the event for every subscriber, as
+// broadcasts did before, with writing one prepared frame to all of them.
+func BenchmarkBroadcast(b *testing.B) {
+	const roomID = "room"
+
+	h := New(Options{})
+	clients := subscribeMany(b, h, roomID, benchSubscribers)
+	msg := benchMessage(roomID)
+
+	b.Run("per-client", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			stamped := msg.Stamped()
+			for _, c := range clients {
+				if err := c.Send(stamped); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("prepared", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			h.Broadcast(msg)
+		}
+	})
+
+	_ = h.Shutdown(context.Background())
+}
+
+// BenchmarkBroadcastCompressed measures a prepared broadcast to clients that
+// negotiated permessage-deflate: the frame is deflated once, not once per
+// subscriber.
+func BenchmarkBroadcastCompressed(b *testing.B) {
+	const roomID = "room"
+
+	h := New(Options{Compression: true, CompressionLevel: 1})
+	subscribeMany(b, h, roomID, benchSubscribers)
+	msg := benchMessage(roomID)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.Broadcast(msg)
+	}
+	b.StopTimer()
+
+	_ = h.Shutdown(context.Background())
+}
+
+// stalledConn is a client that stopped reading once subscribed: writes
+// with a deadline block until it passes.
+type stalledConn struct {
+	*discardConn
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (c *stalledConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+
+	// The handshake is written without a deadline.
+	if deadline.IsZero() {
+		return len(p), nil
+	}
+
+	time.Sleep(time.Until(deadline))
+	return 0, os.ErrDeadlineExceeded
+}
+
+func (c *stalledConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.deadline = t
+	return nil
+}
+
+// serve subscribes every connection to roomID until the client hangs up or
+// the hub drops it.
+func serve(t *testing.T, h *Hub, roomID string) string {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{EnableCompression: h.opts.Compression, Subprotocols: wire.Subprotocols}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithCancel(r.Context())
+		c, err := h.Subscribe(roomID, conn, cancel)
+		if err != nil {
+			return
+		}
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		<-ctx.Done()
+		h.Unsubscribe(roomID, c)
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestBroadcastReachesEveryClient(t *testing.T) {
+	const roomID = "room"
+
+	h := New(Options{Compression: true, CompressionLevel: 1, WriteTimeout: time.Second})
+	url := serve(t, h, roomID)
+
+	dialers := []struct {
+		subprotocol string
+		compress    bool
+	}{
+		{wire.SubprotocolJSON, false},
+		{wire.SubprotocolJSON, true},
+		{wire.SubprotocolMsgPack, false},
+		{wire.SubprotocolProto, true},
+		{"", false},
+	}
+
+	var conns []*websocket.Conn
+	for _, d := range dialers {
+		dialer := websocket.Dialer{EnableCompression: d.compress}
+		if d.subprotocol != "" {
+			dialer.Subprotocols = []string{d.subprotocol}
+		}
+
+		conn, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = conn.Close() })
+		conns = append(conns, conn)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for h.Count(roomID) != len(conns) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients subscribed, want %d", h.Count(roomID), len(conns))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	msg := benchMessage(roomID)
+	h.Broadcast(msg)
+
+	// Clients sharing a codec get the same frame, whether it was sent
+	// compressed or not.
+	frames := map[string][]byte{}
+	for i, conn := range conns {
+		codec := wire.ForSubprotocol(dialers[i].subprotocol)
+
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("client %d (%s): %v", i, codec.Subprotocol(), err)
+		}
+		if messageType != codec.MessageType() {
+			t.Errorf("client %d (%s): frame type %d, want %d", i, codec.Subprotocol(), messageType, codec.MessageType())
+		}
+
+		if frame, ok := frames[codec.Subprotocol()]; ok && string(frame) != string(data) {
+			t.Errorf("client %d (%s): got %q, want %q", i, codec.Subprotocol(), data, frame)
+		}
+		frames[codec.Subprotocol()] = data
+
+		if codec.Subprotocol() == wire.SubprotocolJSON {
+			got, err := entity.Events.Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := entity.Payload[entity.MessageMessageCreated](got); v != msg.Value {
+				t.Errorf("client %d: got %+v, want %+v", i, v, msg.Value)
+			}
+		}
+	}
+}
+
+func TestBroadcastDropsSlowClient(t *testing.T) {
+	const roomID = "room"
+	const timeout = 50 * time.Millisecond
+
+	h := New(Options{WriteTimeout: timeout})
+
+	slow := &stalledConn{discardConn: newDiscardConn()}
+	t.Cleanup(func() { _ = slow.Close() })
+
+	dropped := make(chan struct{})
+	if _, err := h.Subscribe(roomID, upgrade(t, slow, false), func() { close(dropped) }); err != nil {
+		t.Fatal(err)
+	}
+
+	fast := newDiscardConn()
+	t.Cleanup(func() { _ = fast.Close() })
+	if _, err := h.Subscribe(roomID, upgrade(t, fast, false), func() { t.Error("fast client dropped") }); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	h.Broadcast(benchMessage(roomID))
+
+	select {
+	case <-dropped:
+	default:
+		t.Fatal("slow client still subscribed after a timed out write")
+	}
+	if elapsed := time.Since(start); elapsed > 20*timeout {
+		t.Errorf("broadcast blocked for %s, want about the %s write timeout", elapsed, timeout)
+	}
+}
+
+func TestUnsubscribeDeletesRoomSeries(t *testing.T) {
+	const roomID = "emptied"
+
+	h := New(Options{})
+
+	var clients []*Client
+	for i := 0; i < 2; i++ {
+		conn := newDiscardConn()
+		t.Cleanup(func() { _ = conn.Close() })
+
+		c, err := h.Subscribe(roomID, upgrade(t, conn, false), func() {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+	}
+	h.Broadcast(benchMessage(roomID))
+
+	h.Unsubscribe(roomID, clients[0])
+	if got := roomSeries(t, roomID); got != 2 {
+		t.Fatalf("%d series for a room with a subscriber left, want 2", got)
+	}
+
+	h.Unsubscribe(roomID, clients[1])
+	if got := roomSeries(t, roomID); got != 0 {
+		t.Errorf("%d series left for an empty room, want 0", got)
+	}
+}
+
+// roomSeries counts the series labelled with roomID.
+func roomSeries(t *testing.T, roomID string) int {
+	t.Helper()
+
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 0
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "room_id" && label.GetValue() == roomID {
+					n++
+				}
+			}
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,146 @@
+package hub
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultReactionWindow is how long reaction updates of a busy room are
+// collected before being sent as a single reactions_batch event.
+const DefaultReactionWindow = 250 * time.Millisecond
+
+// maxBatchLinks bounds how many of the spans that caused a reactions_batch
+// event it is linked to.
+const maxBatchLinks = 32
+
+// ReactionAggregator coalesces reaction count updates per room. The first
+// update after a quiet period is delivered immediately and opens a window;
+// updates arriving while the window is open are merged, keeping the latest
+// count of each message, and sent as one reactions_batch event when it
+// closes. The window stays open for as long as updates keep arriving.
+//
+// Events are delivered with the context they were published with, and a
+// batch with a context linking to the spans of the updates it merged.
+type ReactionAggregator struct {
+	window time.Duration
+	notify func(context.Context, entity.Message)
+
+	mu     sync.Mutex
+	rooms  map[string]*pendingReactions
+	closed bool
+}
+
+type pendingReactions struct {
+	counts map[string]int64
+	spans  []trace.SpanContext
+	timer  *time.Timer
+}
+
+// NewReactionAggregator returns an aggregator delivering events through
+// notify. A window of zero disables coalescing.
+func NewReactionAggregator(window time.Duration, notify func(context.Context, entity.Message)) *ReactionAggregator {
+	return &ReactionAggregator{
+		window: window,
+		notify: notify,
+		rooms:  make(map[string]*pendingReactions),
+	}
+}
+
+// Publish delivers or buffers msg, which must carry a
+// MessageMessageReactAdded or MessageMessageReactRemoved value.
+func (a *ReactionAggregator) Publish(ctx context.Context, msg entity.Message) {
+	var messageID string
+	var count int64
+
+	switch v := msg.Value.(type) {
+	case entity.MessageMessageReactAdded:
+		messageID, count = v.ID, v.Count
+	case entity.MessageMessageReactRemoved:
+		messageID, count = v.ID, v.Count
+	default:
+		a.notify(ctx, msg)
+		return
+	}
+
+	if a.window <= 0 {
+		a.notify(ctx, msg)
+		return
+	}
+
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		a.notify(ctx, msg)
+		return
+	}
+
+	pending, open := a.rooms[msg.RoomId]
+	if open {
+		pending.counts[messageID] = count
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() && len(pending.spans) < maxBatchLinks {
+			pending.spans = append(pending.spans, sc)
+		}
+		a.mu.Unlock()
+		return
+	}
+
+	a.rooms[msg.RoomId] = &pendingReactions{
+		counts: make(map[string]int64),
+		timer:  time.AfterFunc(a.window, func() { a.flush(msg.RoomId) }),
+	}
+	a.mu.Unlock()
+
+	a.notify(ctx, msg)
+}
+
+// Shutdown stops the timers of open windows and drops the updates they
+// collected. Later updates are delivered immediately.
+func (a *ReactionAggregator) Shutdown() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.closed = true
+	for roomID, pending := range a.rooms {
+		pending.timer.Stop()
+		delete(a.rooms, roomID)
+	}
+}
+
+func (a *ReactionAggregator) flush(roomID string) {
+	a.mu.Lock()
+	pending, ok := a.rooms[roomID]
+	if !ok {
+		// Shut down after the timer fired.
+		a.mu.Unlock()
+		return
+	}
+	if len(pending.counts) == 0 {
+		delete(a.rooms, roomID)
+		a.mu.Unlock()
+		return
+	}
+
+	a.rooms[roomID] = &pendingReactions{
+		counts: make(map[string]int64),
+		timer:  time.AfterFunc(a.window, func() { a.flush(roomID) }),
+	}
+	a.mu.Unlock()
+
+	reactions := make([]entity.MessageReactionCount, 0, len(pending.counts))
+	for id, count := range pending.counts {
+		reactions = append(reactions, entity.MessageReactionCount{ID: id, Count: count})
+	}
+	sort.Slice(reactions, func(i, j int) bool { return reactions[i].ID < reactions[j].ID })
+
+	a.notify(tracing.WithLinks(context.Background(), pending.spans...), entity.Message{
+		Kind:   entity.MessageKindReactionsBatch,
+		RoomId: roomID,
+		Value:  entity.MessageReactionsBatch{Reactions: reactions},
+	})
+}
@@ -0,0 +1,184 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+)
+
+const testWindow = 40 * time.Millisecond
+
+// recorder collects the events delivered by an aggregator, formatted as
+// "kind id=count ...".
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) notify(_ context.Context, msg entity.Message) {
+	var counts []string
+	switch v := msg.Value.(type) {
+	case entity.MessageMessageReactAdded:
+		counts = append(counts, fmt.Sprintf("%s=%d", v.ID, v.Count))
+	case entity.MessageMessageReactRemoved:
+		counts = append(counts, fmt.Sprintf("%s=%d", v.ID, v.Count))
+	case entity.MessageReactionsBatch:
+		for _, reaction := range v.Reactions {
+			counts = append(counts, fmt.Sprintf("%s=%d", reaction.ID, reaction.Count))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, strings.TrimSpace(msg.Kind+" "+strings.Join(counts, " ")))
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.events...)
+}
+
+func reacted(id string, count int64) entity.Message {
+	return entity.Message{
+		Kind:   entity.MessageKindMessageReactAdded,
+		RoomId: "room",
+		Value:  entity.MessageMessageReactAdded{ID: id, Count: count},
+	}
+}
+
+func unreacted(id string, count int64) entity.Message {
+	return entity.Message{
+		Kind:   entity.MessageKindMessageReactRemoved,
+		RoomId: "room",
+		Value:  entity.MessageMessageReactRemoved{ID: id, Count: count},
+	}
+}
+
+func TestReactionAggregator(t *testing.T) {
+	type step struct {
+		wait time.Duration
+		msg  entity.Message
+	}
+
+	tests := []struct {
+		name   string
+		window time.Duration
+		steps  []step
+		want   []string
+	}{
+		{
+			name:   "first update is sent immediately",
+			window: testWindow,
+			steps:  []step{{0, reacted("a", 1)}},
+			want:   []string{"message_react_added a=1"},
+		},
+		{
+			name:   "updates in the window are batched",
+			window: testWindow,
+			steps: []step{
+				{0, reacted("a", 1)},
+				{0, reacted("b", 1)},
+				{0, reacted("a", 2)},
+				{0, unreacted("b", 0)},
+			},
+			want: []string{"message_react_added a=1", "reactions_batch a=2 b=0"},
+		},
+		{
+			name:   "window stays open while updates arrive",
+			window: testWindow,
+			steps: []step{
+				{0, reacted("a", 1)},
+				{0, reacted("a", 2)},
+				{testWindow * 3 / 2, reacted("a", 3)},
+			},
+			want: []string{"message_react_added a=1", "reactions_batch a=2", "reactions_batch a=3"},
+		},
+		{
+			name:   "window resets after a quiet period",
+			window: testWindow,
+			steps: []step{
+				{0, reacted("a", 1)},
+				{testWindow * 4, reacted("a", 2)},
+			},
+			want: []string{"message_react_added a=1", "message_react_added a=2"},
+		},
+		{
+			name:   "other events are not delayed",
+			window: testWindow,
+			steps: []step{
+				{0, reacted("a", 1)},
+				{0, entity.Message{Kind: entity.MessageKindMessageAnswered, RoomId: "room", Value: entity.MessageMessageAnswered{ID: "a"}}},
+			},
+			want: []string{"message_react_added a=1", "message_answered"},
+		},
+		{
+			name:   "zero window disables batching",
+			window: 0,
+			steps: []step{
+				{0, reacted("a", 1)},
+				{0, reacted("a", 2)},
+			},
+			want: []string{"message_react_added a=1", "message_react_added a=2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &recorder{}
+			a := NewReactionAggregator(tt.window, r.notify)
+			defer a.Shutdown()
+
+			for i, s := range tt.steps {
+				time.Sleep(s.wait)
+
+				before := len(r.get())
+				a.Publish(context.Background(), s.msg)
+
+				// The first update is delivered before Publish returns.
+				if i == 0 && len(r.get()) != before+1 {
+					t.Fatalf("step %d: first update not delivered immediately", i)
+				}
+			}
+
+			time.Sleep(3 * testWindow)
+
+			if got := r.get(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("delivered %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReactionAggregatorShutdown(t *testing.T) {
+	r := &recorder{}
+	a := NewReactionAggregator(testWindow, r.notify)
+
+	a.Publish(context.Background(), reacted("a", 1))
+	a.Publish(context.Background(), reacted("a", 2))
+	a.Shutdown()
+
+	time.Sleep(3 * testWindow)
+	if got, want := r.get(), []string{"message_react_added a=1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("delivered %q after shutdown, want %q", got, want)
+	}
+
+	a.mu.Lock()
+	open := len(a.rooms)
+	a.mu.Unlock()
+	if open != 0 {
+		t.Errorf("%d windows still open after shutdown", open)
+	}
+
+	a.Publish(context.Background(), reacted("a", 3))
+	if got := r.get(); len(got) != 2 || got[1] != "message_react_added a=3" {
+		t.Errorf("delivered %q, want updates after shutdown delivered immediately", got)
+	}
+}
@@ -0,0 +1,229 @@
+// Package idempotency lets clients retry unsafe requests with an
+// Idempotency-Key header. The first response for a key is stored in the
+// idempotency_keys table and replayed to retries of the same request.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+const (
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set on responses replayed from a previous request.
+	ReplayedHeader = "Idempotent-Replayed"
+
+	// DefaultTTL is how long a response is kept for replay.
+	DefaultTTL = 24 * time.Hour
+
+	maxKeyLength    = 255
+	maxBodySize     = 1 << 20
+	cleanupInterval = 10 * time.Minute
+)
+
+// callerHeaders identify who sent a request. Keys are scoped by them so one
+// caller can't replay another's response by guessing its key.
+var callerHeaders = []string{"Authorization", "X-Host-Token", "X-Participant-Id"}
+
+// storedHeaders are replayed along with the status and body.
+var storedHeaders = []string{"ETag", "Link", "Location"}
+
+// Store keeps responses in Postgres for ttl.
+type Store struct {
+	q   *pgstore.Queries
+	ttl time.Duration
+}
+
+func NewStore(q *pgstore.Queries, ttl time.Duration) *Store {
+	return &Store{q: q, ttl: ttl}
+}
+
+// Middleware replays the stored response when the same caller retries a
+// request with the same key and body. A key reused with a different body gets a 422 and one
+// whose first request is still running a 409. Server errors aren't stored,
+// so the request can be retried with the same key.
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxKeyLength {
+			http.Error(w, "idempotency key is too long", http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "body is too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.Sum256(body)
+		scope := r.Method + " " + r.URL.Path + " " + caller(r)
+		ctx := r.Context()
+
+		claimed, err := s.q.ClaimIdempotencyKey(ctx, pgstore.ClaimIdempotencyKeyParams{
+			Scope:       scope,
+			Key:         key,
+			RequestHash: hash[:],
+			TtlSeconds:  s.ttl.Seconds(),
+		})
+		if err != nil {
+			logging.FromContext(ctx).Error("failed to claim idempotency key", "error", err)
+			http.Error(w, "something went wrong", http.StatusInternalServerError)
+			return
+		}
+
+		if claimed == 0 {
+			s.replay(w, r, scope, key, hash[:])
+			return
+		}
+
+		var buf bytes.Buffer
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(&buf)
+
+		defer func() {
+			// The response is written; don't let a cancelled request keep
+			// the key claimed until it expires.
+			ctx := context.WithoutCancel(ctx)
+
+			// Nothing written means the handler panicked.
+			status := ww.Status()
+			if status == 0 || status >= http.StatusInternalServerError {
+				if err := s.q.DeleteIdempotencyKey(ctx, pgstore.DeleteIdempotencyKeyParams{Scope: scope, Key: key}); err != nil {
+					logging.FromContext(ctx).Error("failed to release idempotency key", "error", err)
+				}
+				return
+			}
+
+			headers, err := json.Marshal(replayable(ww.Header()))
+			if err != nil {
+				logging.FromContext(ctx).Error("failed to encode idempotent response headers", "error", err)
+			}
+
+			err = s.q.CompleteIdempotencyKey(ctx, pgstore.CompleteIdempotencyKeyParams{
+				Scope:           scope,
+				Key:             key,
+				StatusCode:      pgtype.Int4{Int32: int32(status), Valid: true},
+				ContentType:     ww.Header().Get("Content-Type"),
+				ResponseBody:    buf.Bytes(),
+				ResponseHeaders: headers,
+			})
+			if err != nil {
+				logging.FromContext(ctx).Error("failed to store idempotent response", "error", err)
+			}
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// caller hashes the headers identifying who sent r.
+func caller(r *http.Request) string {
+	h := sha256.New()
+	for _, name := range callerHeaders {
+		// The length prefix keeps values from running into each other.
+		value := r.Header.Get(name)
+		fmt.Fprintf(h, "%d:%s", len(value), value)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replayable(header http.Header) map[string][]string {
+	headers := make(map[string][]string)
+	for _, name := range storedHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			headers[name] = values
+		}
+	}
+
+	return headers
+}
+
+func (s *Store) replay(w http.ResponseWriter, r *http.Request, scope, key string, hash []byte) {
+	stored, err := s.q.GetIdempotencyKey(r.Context(), pgstore.GetIdempotencyKeyParams{Scope: scope, Key: key})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Released by a failed first request since the claim.
+			http.Error(w, "idempotency key is being released, retry the request", http.StatusConflict)
+			return
+		}
+
+		logging.FromContext(r.Context()).Error("failed to load idempotency key", "error", err)
+		http.Error(w, "something went wrong", http.StatusInternalServerError)
+		return
+	}
+
+	if !bytes.Equal(stored.RequestHash, hash) {
+		http.Error(w, "idempotency key was already used with a different request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !stored.StatusCode.Valid {
+		http.Error(w, "a request with this idempotency key is in progress", http.StatusConflict)
+		return
+	}
+
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	if len(stored.ResponseHeaders) > 0 {
+		var headers map[string][]string
+		if err := json.Unmarshal(stored.ResponseHeaders, &headers); err != nil {
+			logging.FromContext(r.Context()).Error("failed to decode idempotent response headers", "error", err)
+		}
+		for name, values := range headers {
+			w.Header()[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(int(stored.StatusCode.Int32))
+	_, _ = w.Write(stored.ResponseBody)
+}
+
+// Run deletes expired keys periodically until ctx is done.
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.q.DeleteExpiredIdempotencyKeys(ctx)
+			if err != nil {
+				logging.For("idempotency").Error("failed to delete expired keys", "error", err)
+				continue
+			}
+			if deleted > 0 {
+				logging.For("idempotency").Debug("deleted expired keys", "count", deleted)
+			}
+		}
+	}
+}
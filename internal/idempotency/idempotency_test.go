@@ -0,0 +1,91 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore/pgstoretest"
+)
+
+// counter answers every request with the number of requests it has handled,
+// as a body and an ETag.
+func counter() (http.Handler, *int) {
+	calls := 0
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"`+strconv.Itoa(calls)+`"`)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(strconv.Itoa(calls)))
+	}), &calls
+}
+
+func post(h http.Handler, body, participant string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/rooms", strings.NewReader(body))
+	r.Header.Set(Header, "k1")
+	if participant != "" {
+		r.Header.Set("X-Participant-Id", participant)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestMiddlewareReplaysResponse(t *testing.T) {
+	next, calls := counter()
+	h := NewStore(pgstore.New(pgstoretest.Open(t)), time.Hour).Middleware(next)
+
+	first := post(h, `{"theme":"a"}`, "p1")
+	again := post(h, `{"theme":"a"}`, "p1")
+
+	if *calls != 1 {
+		t.Fatalf("handler called %d times, want 1", *calls)
+	}
+	if again.Code != http.StatusCreated || again.Body.String() != first.Body.String() {
+		t.Errorf("replayed %d %q, want %d %q", again.Code, again.Body, first.Code, first.Body)
+	}
+	if got, want := again.Header().Get("ETag"), first.Header().Get("ETag"); got != want {
+		t.Errorf("replayed ETag %q, want %q", got, want)
+	}
+	if again.Header().Get(ReplayedHeader) != "true" {
+		t.Errorf("replayed response lacks %s", ReplayedHeader)
+	}
+
+	if w := post(h, `{"theme":"b"}`, "p1"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused with another body: %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
+func TestMiddlewareScopesKeysByCaller(t *testing.T) {
+	next, calls := counter()
+	h := NewStore(pgstore.New(pgstoretest.Open(t)), time.Hour).Middleware(next)
+
+	post(h, `{"theme":"a"}`, "p1")
+	other := post(h, `{"theme":"a"}`, "p2")
+
+	if *calls != 2 {
+		t.Fatalf("handler called %d times, want 2", *calls)
+	}
+	if other.Header().Get(ReplayedHeader) != "" || other.Body.String() != "2" {
+		t.Errorf("another caller got a replayed response: %q", other.Body)
+	}
+}
+
+func TestMiddlewareLimitsBody(t *testing.T) {
+	next, calls := counter()
+	h := NewStore(pgstore.New(pgstoretest.Open(t)), time.Hour).Middleware(next)
+
+	if w := post(h, strings.Repeat("a", maxBodySize+1), "p1"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body: %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	if *calls != 0 {
+		t.Errorf("handler called %d times, want 0", *calls)
+	}
+}
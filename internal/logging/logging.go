@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// ComponentKey is the attribute naming the package a record comes from.
+// Per-component levels are matched against it.
+const ComponentKey = "component"
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+type Config struct {
+	Format string
+	Level  string
+	// Levels overrides Level for individual components, e.g. {"hub": "debug"}.
+	Levels map[string]string
+}
+
+// Setup installs the default slog logger described by cfg.
+func Setup(w io.Writer, cfg Config) error {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+
+	levels := make(map[string]slog.Level, len(cfg.Levels))
+	for component, l := range cfg.Levels {
+		if levels[component], err = ParseLevel(l); err != nil {
+			return fmt.Errorf("component %s: %w", component, err)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	var base slog.Handler
+	switch cfg.Format {
+	case "", FormatText:
+		base = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		base = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	slog.SetDefault(slog.New(&levelHandler{
+		base:   base,
+		level:  level,
+		levels: levels,
+	}))
+
+	return nil
+}
+
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+
+	err := level.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return level, err
+}
+
+// For returns the logger of a component.
+func For(component string) *slog.Logger {
+	return slog.Default().With(ComponentKey, component)
+}
+
+// levelHandler filters records by the level configured for the component
+// the logger was bound to with ComponentKey.
+type levelHandler struct {
+	base      slog.Handler
+	level     slog.Level
+	levels    map[string]slog.Level
+	component string
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	min := h.level
+	if l, ok := h.levels[h.component]; ok {
+		min = l
+	}
+
+	return level >= min
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.base.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.base = h.base.WithAttrs(attrs)
+	for _, a := range attrs {
+		if a.Key == ComponentKey {
+			clone.component = a.Value.String()
+		}
+	}
+
+	return &clone
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.base = h.base.WithGroup(name)
+	return &clone
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type ctxKey struct{}
+
+// Middleware puts a request-scoped logger in the context, carrying the
+// request ID and the participant, and logs one line per completed request.
+// It must run after middleware.RequestID.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		l := slog.Default().With(
+			"request_id", middleware.GetReqID(r.Context()),
+			"method", r.Method,
+			"path", r.URL.Path,
+		)
+
+		if participant := Participant(r); participant != "" {
+			l = l.With("participant", participant)
+		}
+
+		ctx := context.WithValue(r.Context(), ctxKey{}, l)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		FromContext(ctx).With(ComponentKey, "http").Info("request completed",
+			"status", ww.Status(),
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
+
+// FromContext returns the request logger stored by Middleware, with the
+// matched route and its room_id and message_id, or the default logger
+// outside of a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	l, ok := ctx.Value(ctxKey{}).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		if route := rctx.RoutePattern(); route != "" {
+			l = l.With("route", route)
+		}
+		if id := rctx.URLParam("room_id"); id != "" {
+			l = l.With("room_id", id)
+		}
+		if id := rctx.URLParam("message_id"); id != "" {
+			l = l.With("message_id", id)
+		}
+	}
+
+	return l
+}
+
+// Participant returns the participant a request was made on behalf of, as
+// sent in X-Participant-Id, if any. It is only used in logs.
+func Participant(r *http.Request) string {
+	return r.Header.Get("X-Participant-Id")
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "wsrs"
+
+const (
+	EventRoomCreated     = "room_created"
+	EventQuestionCreated = "question_created"
+	EventReactionAdded   = "reaction_added"
+	EventReactionRemoved = "reaction_removed"
+	EventMessageAnswered = "message_answered"
+)
+
+// Registry holds every collector exposed on /metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by chi route pattern.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	WebSocketConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Active WebSocket subscribers per room.",
+	}, []string{"room_id"})
+
+	BroadcastDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "broadcast_duration_seconds",
+		Help:      "Time to encode an event and write it to every subscriber of a room.",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5},
+	}, []string{"kind"})
+
+	BroadcastFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "broadcast_failures_total",
+		Help:      "Writes to subscribers that failed during a broadcast.",
+	}, []string{"kind"})
+
+	DomainEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_total",
+		Help:      "Domain events such as questions created, reactions and answers.",
+	}, []string{"event"})
+
+	WebSocketPayloadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_payload_bytes_total",
+		Help:      "Bytes of events sent to WebSocket subscribers, by room, before compression.",
+	}, []string{"room_id"})
+
+	WebSocketWireBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_wire_bytes_total",
+		Help:      "Bytes read from and written to WebSocket connections, by room, after compression and framing.",
+	}, []string{"room_id", "direction"})
+
+	OriginRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "origin_rejections_total",
+		Help:      "Cross-origin requests refused by the origin policy, by surface (cors or websocket).",
+	}, []string{"surface"})
+
+	TxRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tx_retries_total",
+		Help:      "Transactions retried after a serialization failure or deadlock, by SQLSTATE.",
+	}, []string{"sqlstate"})
+
+	OutboxDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_deliveries_total",
+		Help:      "Outbox events handed to a consumer, by consumer.",
+	}, []string{"consumer"})
+
+	OutboxFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_failures_total",
+		Help:      "Outbox batches that failed and will be delivered again, by consumer.",
+	}, []string{"consumer"})
+
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook delivery attempts, by result (succeeded, retrying or failed).",
+	}, []string{"result"})
+
+	SpecViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "openapi_violations_total",
+		Help:      "Responses that did not match the OpenAPI document, when validation is enabled.",
+	}, []string{"method", "route"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		WebSocketConnections,
+		BroadcastDuration,
+		BroadcastFailures,
+		DomainEvents,
+		WebSocketPayloadBytes,
+		WebSocketWireBytes,
+		OriginRejections,
+		TxRetries,
+		OutboxDeliveries,
+		OutboxFailures,
+		WebhookDeliveries,
+		SpecViolations,
+	)
+}
+
+// Handler serves the metrics in Registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Middleware records the duration of each request labelled by the chi route
+// pattern that matched it. WebSocket upgrades are skipped, as their duration
+// is the lifetime of the connection.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		HTTPRequestDuration.
+			WithLabelValues(r.Method, route, strconv.Itoa(status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
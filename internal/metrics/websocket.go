@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CountWebSocketBytes returns w with Hijack wrapped, so the connection taken
+// over by the WebSocket upgrader adds the bytes it reads and writes to
+// WebSocketWireBytes for roomID.
+func CountWebSocketBytes(w http.ResponseWriter, roomID string) http.ResponseWriter {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return w
+	}
+
+	return &countingResponseWriter{ResponseWriter: w, hijacker: hijacker, roomID: roomID}
+}
+
+// DeleteWebSocketRoom removes the per-room series of roomID once it has no
+// subscribers left.
+func DeleteWebSocketRoom(roomID string) {
+	WebSocketConnections.DeleteLabelValues(roomID)
+	WebSocketPayloadBytes.DeleteLabelValues(roomID)
+	WebSocketWireBytes.DeleteLabelValues(roomID, "in")
+	WebSocketWireBytes.DeleteLabelValues(roomID, "out")
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	hijacker http.Hijacker
+	roomID   string
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	counted := &countingConn{
+		Conn: conn,
+		in:   WebSocketWireBytes.WithLabelValues(w.roomID, "in"),
+		out:  WebSocketWireBytes.WithLabelValues(w.roomID, "out"),
+	}
+
+	// The upgrader may reuse these buffers. They are empty after a valid
+	// handshake, so pointing them at the counted connection loses nothing.
+	if rw.Reader.Buffered() == 0 {
+		rw.Reader.Reset(counted)
+	}
+	if rw.Writer.Buffered() == 0 {
+		rw.Writer.Reset(counted)
+	}
+
+	return counted, rw, nil
+}
+
+type countingConn struct {
+	net.Conn
+	in, out prometheus.Counter
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out.Add(float64(n))
+	return n, err
+}
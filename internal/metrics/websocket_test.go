@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// series returns the value of every series of the metric name carrying the
+// label room_id=roomID, keyed by their other label values.
+func series(t *testing.T, name, roomID string) map[string]float64 {
+	t.Helper()
+
+	families, err := Registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, m := range family.GetMetric() {
+			var room bool
+			var key []string
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "room_id" {
+					room = label.GetValue() == roomID
+					continue
+				}
+				key = append(key, label.GetValue())
+			}
+			if !room {
+				continue
+			}
+
+			value := m.GetCounter().GetValue()
+			if m.GetGauge() != nil {
+				value = m.GetGauge().GetValue()
+			}
+			values[strings.Join(key, ",")] = value
+		}
+	}
+
+	return values
+}
+
+func TestDeleteWebSocketRoom(t *testing.T) {
+	for _, roomID := range []string{"emptied", "busy"} {
+		WebSocketConnections.WithLabelValues(roomID).Set(1)
+		WebSocketPayloadBytes.WithLabelValues(roomID).Add(10)
+		WebSocketWireBytes.WithLabelValues(roomID, "in").Add(10)
+		WebSocketWireBytes.WithLabelValues(roomID, "out").Add(10)
+	}
+
+	DeleteWebSocketRoom("emptied")
+
+	for _, name := range []string{"wsrs_websocket_connections", "wsrs_websocket_payload_bytes_total", "wsrs_websocket_wire_bytes_total"} {
+		if got := series(t, name, "emptied"); len(got) != 0 {
+			t.Errorf("%s still has series %v for the emptied room", name, got)
+		}
+		if got := series(t, name, "busy"); len(got) == 0 {
+			t.Errorf("%s lost the series of another room", name)
+		}
+	}
+
+	DeleteWebSocketRoom("busy")
+}
+
+// clientConn counts the bytes a client reads and writes.
+type clientConn struct {
+	net.Conn
+	in, out atomic.Int64
+}
+
+func (c *clientConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in.Add(int64(n))
+	return n, err
+}
+
+func (c *clientConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out.Add(int64(n))
+	return n, err
+}
+
+func TestCountWebSocketBytes(t *testing.T) {
+	const roomID = "counted"
+	defer DeleteWebSocketRoom(roomID)
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+
+		conn, err := (&websocket.Upgrader{}).Upgrade(CountWebSocketBytes(w, roomID), r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		// Echo frames until the client closes.
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	var client *clientConn
+	dialer := websocket.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			client = &clientConn{Conn: conn}
+			return client, nil
+		},
+	}
+
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handshake := client.out.Load()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 3; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 100*i))); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not notice the client closing")
+	}
+
+	// Everything the client sent after the handshake request was read
+	// through the counted connection, and everything it received, the
+	// handshake response included, was written through it.
+	got := series(t, "wsrs_websocket_wire_bytes_total", roomID)
+	if got["out"] != float64(client.in.Load()) {
+		t.Errorf("counted %v bytes out, client read %d", got["out"], client.in.Load())
+	}
+	if want := client.out.Load() - handshake; got["in"] != float64(want) {
+		t.Errorf("counted %v bytes in, client wrote %d after the handshake", got["in"], want)
+	}
+}
@@ -0,0 +1,248 @@
+// Package origin matches the Origin header of browser requests against an
+// allowlist shared by the CORS middleware and WebSocket upgrades.
+//
+// A policy is built from patterns of three kinds:
+//
+//	https://app.example.com          exact scheme, host and port
+//	https://*.example.com            any subdomain of example.com, on any
+//	                                 port unless one is given
+//	re:https://pr-\d+\.example\.dev  regular expression over the origin
+//
+// "*" allows every origin and "https://*" every host over https. An empty
+// policy only allows same-origin requests.
+//
+// Regular expressions must match the whole origin, lowercased and without
+// its default port, as if they started with ^ and ended with $.
+package origin
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const regexpPrefix = "re:"
+
+// probes are unrelated origins a policy should not allow. A policy that
+// allows them allows anything.
+var probes = func() []string {
+	var probes []string
+	for _, tld := range append([]string{"invalid"}, probeTLDs...) {
+		probes = append(probes,
+			"https://originprobe."+tld,
+			"http://originprobe."+tld,
+		)
+	}
+	return probes
+}()
+
+// probeTLDs are common top-level domains. A policy allowing every host under
+// one of them, as re:https://.*\.com does, is as good as allowing anything.
+var probeTLDs = []string{"com", "net", "org", "io", "dev", "app", "co", "ai"}
+
+type wildcard struct {
+	scheme string
+	// suffix is ".example.com", or empty to match any host.
+	suffix string
+	// port is empty to match any port.
+	port string
+}
+
+type Policy struct {
+	patterns  []string
+	any       bool
+	exact     map[string]struct{}
+	wildcards []wildcard
+	regexps   []*regexp.Regexp
+}
+
+// Parse builds a policy from patterns.
+func Parse(patterns []string) (*Policy, error) {
+	p := &Policy{
+		patterns: patterns,
+		exact:    make(map[string]struct{}),
+	}
+
+	for _, pattern := range patterns {
+		if err := p.add(strings.TrimSpace(pattern)); err != nil {
+			return nil, fmt.Errorf("origin %q: %w", pattern, err)
+		}
+	}
+
+	return p, nil
+}
+
+// MustParse is like Parse but panics if a pattern is invalid.
+func MustParse(patterns ...string) *Policy {
+	p, err := Parse(patterns)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+func (p *Policy) add(pattern string) error {
+	if pattern == "*" {
+		p.any = true
+		return nil
+	}
+
+	if expr, ok := strings.CutPrefix(pattern, regexpPrefix); ok {
+		// Unanchored, "example\.com" would also match
+		// https://example.com.attacker.dev.
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return err
+		}
+		p.regexps = append(p.regexps, re)
+		return nil
+	}
+
+	scheme, host, ok := strings.Cut(pattern, "://")
+	if !ok || scheme == "" || host == "" {
+		return fmt.Errorf("expected scheme://host[:port]")
+	}
+	scheme = strings.ToLower(scheme)
+	host = strings.ToLower(host)
+
+	if !strings.HasPrefix(host, "*") {
+		if strings.Contains(host, "*") {
+			return fmt.Errorf("wildcards must be a whole leading label, as in *.example.com")
+		}
+		origin, err := normalize(scheme + "://" + host)
+		if err != nil {
+			return err
+		}
+		p.exact[origin] = struct{}{}
+		return nil
+	}
+
+	name, port, _ := strings.Cut(host, ":")
+	if name != "*" && !strings.HasPrefix(name, "*.") {
+		return fmt.Errorf("wildcards must be a whole leading label, as in *.example.com")
+	}
+	if strings.Contains(name[1:], "*") {
+		return fmt.Errorf("only one leading wildcard is supported")
+	}
+
+	p.wildcards = append(p.wildcards, wildcard{
+		scheme: scheme,
+		suffix: strings.TrimPrefix(name, "*"),
+		port:   port,
+	})
+
+	return nil
+}
+
+// Allowed reports whether requests from origin are allowed.
+func (p *Policy) Allowed(origin string) bool {
+	if p.any {
+		return true
+	}
+
+	normalized, err := normalize(origin)
+	if err != nil {
+		return false
+	}
+
+	if _, ok := p.exact[normalized]; ok {
+		return true
+	}
+
+	u, _ := url.Parse(normalized)
+	for _, w := range p.wildcards {
+		if w.scheme != u.Scheme || !strings.HasSuffix(u.Hostname(), w.suffix) {
+			continue
+		}
+		if w.port == "" || w.port == defaultPort(u.Scheme, u.Port()) {
+			return true
+		}
+	}
+
+	for _, re := range p.regexps {
+		if re.MatchString(normalized) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Permissive reports whether the policy allows arbitrary origins, such as
+// "*", "https://*", a wildcard over a whole top-level domain like
+// "https://*.com", or a regular expression matching any host.
+func (p *Policy) Permissive() bool {
+	for _, w := range p.wildcards {
+		if strings.Count(w.suffix, ".") <= 1 {
+			return true
+		}
+	}
+
+	for _, probe := range probes {
+		if p.Allowed(probe) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Empty reports whether the policy only allows same-origin requests.
+func (p *Policy) Empty() bool {
+	return !p.any && len(p.exact) == 0 && len(p.wildcards) == 0 && len(p.regexps) == 0
+}
+
+func (p *Policy) String() string {
+	return strings.Join(p.patterns, ",")
+}
+
+// SameOrigin reports whether origin is the host r was sent to.
+func SameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// normalize lowercases origin and drops its default port, so
+// "HTTPS://Example.com:443" and "https://example.com" compare equal.
+func normalize(origin string) (string, error) {
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return "", fmt.Errorf("expected scheme://host[:port]")
+	}
+
+	host := u.Hostname()
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && port != defaultPort(u.Scheme, "") {
+		host += ":" + port
+	}
+
+	return u.Scheme + "://" + host, nil
+}
+
+func defaultPort(scheme, port string) string {
+	if port != "" {
+		return port
+	}
+
+	switch scheme {
+	case "https", "wss":
+		return "443"
+	case "http", "ws":
+		return "80"
+	}
+
+	return ""
+}
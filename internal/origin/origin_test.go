@@ -0,0 +1,165 @@
+package origin
+
+import "testing"
+
+func TestAllowedRegexp(t *testing.T) {
+	p := MustParse(`re:https://pr-\d+\.example\.dev`, `re:^https://app\.example\.com$`)
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://pr-42.example.dev", true},
+		{"HTTPS://PR-42.Example.dev:443", true},
+		{"https://app.example.com", true},
+		{"https://pr-42.example.dev.attacker.com", false},
+		{"https://evil-https://pr-42.example.dev", false},
+		{"http://pr-42.example.dev", false},
+		{"https://pr-42.example.dev:8443", false},
+		{"https://app.example.com.attacker.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := p.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%q) = %t, want %t", tt.origin, got, tt.want)
+		}
+	}
+
+	if p.Permissive() {
+		t.Error("anchored expressions reported as permissive")
+	}
+	if !MustParse(`re:https?://.*`).Permissive() {
+		t.Error("expression matching any host not reported as permissive")
+	}
+}
+
+func TestAllowedExact(t *testing.T) {
+	p := MustParse("https://App.Example.com:443", "http://localhost:8080", "https://[::1]:8443", "http://[::1]")
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"https://app.example.com:443", true},
+		{"https://app.example.com/", true},
+		{"https://app.example.com:8443", false},
+		{"http://app.example.com", false},
+		{"https://api.example.com", false},
+		{"http://localhost:8080", true},
+		{"http://localhost", false},
+		{"http://localhost:80", false},
+		{"https://[::1]:8443", true},
+		{"https://[::1]", false},
+		{"http://[::1]", true},
+		{"http://[::1]:80", true},
+		{"http://[::2]", false},
+		{"null", false},
+		{"https://app.example.com/path", false},
+	}
+
+	for _, tt := range tests {
+		if got := p.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%q) = %t, want %t", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestAllowedWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		origin  string
+		want    bool
+	}{
+		{"https://*.example.com", "https://app.example.com", true},
+		{"https://*.example.com", "https://a.b.example.com", true},
+		{"https://*.example.com", "HTTPS://App.Example.COM", true},
+		{"https://*.example.com", "https://app.example.com:8443", true},
+		{"https://*.example.com", "https://example.com", false},
+		{"https://*.example.com", "https://evilexample.com", false},
+		{"https://*.example.com", "https://app.example.com.attacker.dev", false},
+		{"https://*.example.com", "http://app.example.com", false},
+		{"https://*.example.dev:8443", "https://app.example.dev:8443", true},
+		{"https://*.example.dev:8443", "https://app.example.dev", false},
+		{"https://*.example.dev:8443", "https://app.example.dev:9443", false},
+		{"https://*.example.net:443", "https://app.example.net", true},
+		{"https://*.example.net:443", "https://app.example.net:443", true},
+		{"https://*.example.net:443", "https://app.example.net:8443", false},
+		{"https://*", "https://anything.test", true},
+		{"https://*", "http://anything.test", false},
+	}
+
+	for _, tt := range tests {
+		if got := MustParse(tt.pattern).Allowed(tt.origin); got != tt.want {
+			t.Errorf("%s: Allowed(%q) = %t, want %t", tt.pattern, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestPermissive(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		want     bool
+	}{
+		{[]string{"*"}, true},
+		{[]string{"https://*"}, true},
+		{[]string{"http://*:8080"}, true},
+		{[]string{"https://*.com"}, true},
+		{[]string{"https://app.example.com", "http://*.dev"}, true},
+		{[]string{`re:https://.*\.io`}, true},
+		{[]string{`re:https://[a-z]+\.(com|net)`}, true},
+		{[]string{"https://*.example.com"}, false},
+		{[]string{"https://*.example.co.uk"}, false},
+		{[]string{"https://app.example.com", "http://localhost:3000"}, false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := MustParse(tt.patterns...).Permissive(); got != tt.want {
+			t.Errorf("%q: Permissive() = %t, want %t", tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		want     bool
+	}{
+		{nil, true},
+		{[]string{}, true},
+		{[]string{"*"}, false},
+		{[]string{"https://app.example.com"}, false},
+		{[]string{"https://*.example.com"}, false},
+		{[]string{`re:https://pr-\d+\.example\.dev`}, false},
+	}
+
+	for _, tt := range tests {
+		p, err := Parse(tt.patterns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Empty(); got != tt.want {
+			t.Errorf("%q: Empty() = %t, want %t", tt.patterns, got, tt.want)
+		}
+		if tt.want && p.Allowed("https://app.example.com") {
+			t.Errorf("%q: empty policy allowed a cross-origin request", tt.patterns)
+		}
+	}
+}
+
+func TestParseRejectsInvalidPatterns(t *testing.T) {
+	for _, pattern := range []string{
+		"example.com",
+		"https://",
+		"https://app*.example.com",
+		"https://app.*.example.com",
+		"https://*.*.example.com",
+		"re:(",
+	} {
+		if _, err := Parse([]string{pattern}); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", pattern)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Package outbox delivers realtime events written to the outbox table in the
+// same transaction as the change they describe, so an event is never lost
+// when the process crashes after the change committed. Every consumer keeps
+// a cursor in outbox_cursors: delivery is at least once and resumes where it
+// left off after a restart.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultPollInterval is how often consumers look for new events.
+	DefaultPollInterval = 100 * time.Millisecond
+	// DefaultBatchSize is the most events handed to a consumer at once.
+	DefaultBatchSize = 100
+	// DefaultConsumerTTL is how long the cursor of a consumer that stopped
+	// polling, such as an instance that was scaled down, holds back cleanup.
+	DefaultConsumerTTL = time.Hour
+
+	cleanupInterval = time.Minute
+)
+
+// TxBeginner starts transactions. *pgxpool.Pool implements it.
+type TxBeginner interface {
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+}
+
+// Write adds msg to the outbox. q should run in the transaction making the
+// change msg describes. msg is stamped first, so every delivery of it
+// carries the same event ID, and the span in ctx is stored with it, so its
+// delivery links back to it.
+func Write(ctx context.Context, q *pgstore.Queries, msg entity.Message) error {
+	msg = msg.Stamped()
+
+	roomID, err := uuid.Parse(msg.RoomId)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	traceparent := tracing.Traceparent(ctx)
+
+	return q.InsertOutboxEvent(ctx, pgstore.InsertOutboxEventParams{
+		RoomID:      roomID,
+		Kind:        msg.Kind,
+		Payload:     payload,
+		Traceparent: pgtype.Text{String: traceparent, Valid: traceparent != ""},
+	})
+}
+
+// Handler delivers a batch of events in the order they were committed. q
+// runs in the transaction advancing the consumer's cursor. ctx carries a
+// span linked to the spans that wrote the events. When it returns an error
+// the cursor stays put and the batch is delivered again.
+type Handler func(ctx context.Context, q *pgstore.Queries, events []entity.Message) error
+
+type Options struct {
+	PollInterval time.Duration
+	BatchSize    int
+	ConsumerTTL  time.Duration
+}
+
+type consumer struct {
+	name    string
+	handle  Handler
+	touched time.Time
+}
+
+// Dispatcher hands events to its consumers and deletes the ones every
+// consumer has processed.
+type Dispatcher struct {
+	q         *pgstore.Queries
+	db        TxBeginner
+	opts      Options
+	consumers []*consumer
+}
+
+func NewDispatcher(q *pgstore.Queries, db TxBeginner, opts Options) *Dispatcher {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.ConsumerTTL <= 0 {
+		opts.ConsumerTTL = DefaultConsumerTTL
+	}
+
+	return &Dispatcher{
+		q:    q,
+		db:   db,
+		opts: opts,
+	}
+}
+
+// Register adds a consumer before Run. A consumer only sees events written
+// after its cursor was first created. Dispatchers of several instances
+// registering the same name share its cursor, and each batch is delivered
+// by only one of them.
+func (d *Dispatcher) Register(name string, handle Handler) {
+	d.consumers = append(d.consumers, &consumer{name: name, handle: handle})
+}
+
+// Run delivers events until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for _, c := range d.consumers {
+		if err := d.q.InitOutboxCursor(ctx, c.name); err != nil {
+			logger().Error("failed to create cursor", "consumer", c.name, "error", err)
+		}
+	}
+
+	poll := time.NewTicker(d.opts.PollInterval)
+	defer poll.Stop()
+
+	cleanup := time.NewTicker(cleanupInterval)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			for _, c := range d.consumers {
+				d.drain(ctx, c)
+			}
+		case <-cleanup.C:
+			d.cleanup(ctx)
+		}
+	}
+}
+
+// drain delivers batches to c until it caught up.
+func (d *Dispatcher) drain(ctx context.Context, c *consumer) {
+	for ctx.Err() == nil {
+		n, err := d.deliver(ctx, c)
+		if err != nil {
+			if ctx.Err() == nil {
+				metrics.OutboxFailures.WithLabelValues(c.name).Inc()
+				logger().Error("failed to deliver events", "consumer", c.name, "error", err)
+			}
+			return
+		}
+
+		if n < d.opts.BatchSize {
+			return
+		}
+	}
+}
+
+// deliver hands the next batch to c and returns how many events it had.
+func (d *Dispatcher) deliver(ctx context.Context, c *consumer) (int, error) {
+	tx, err := d.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	// Rolling back a committed transaction is a no-op.
+	defer func() {
+		_ = tx.Rollback(context.WithoutCancel(ctx))
+	}()
+
+	q := d.q.WithTx(tx)
+
+	cursor, err := q.LockOutboxCursor(ctx, c.name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Another instance is delivering to this consumer, or the cursor
+		// was deleted as stale and starts over from the latest events.
+		return 0, d.q.InitOutboxCursor(ctx, c.name)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := q.ListOutboxEvents(ctx, pgstore.ListOutboxEventsParams{
+		TxID:      cursor.TxID,
+		EventID:   cursor.EventID,
+		MaxEvents: int32(d.opts.BatchSize),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// An idle cursor is still touched now and then, so it isn't taken for
+	// the cursor of a consumer that went away.
+	if len(rows) == 0 && time.Since(c.touched) < d.opts.ConsumerTTL/4 {
+		return 0, nil
+	}
+
+	if len(rows) > 0 {
+		events := make([]entity.Message, 0, len(rows))
+		var links []trace.Link
+		for _, row := range rows {
+			msg, err := entity.Events.Decode(row.Payload)
+			if err != nil {
+				logger().Error("skipping undecodable event", "consumer", c.name, "event_id", row.ID, "error", err)
+				continue
+			}
+			events = append(events, msg)
+
+			if sc := tracing.ParseTraceparent(row.Traceparent.String); sc.IsValid() {
+				links = append(links, trace.Link{SpanContext: sc})
+			}
+		}
+
+		if err := d.handle(ctx, c, q, events, links); err != nil {
+			return 0, err
+		}
+
+		last := rows[len(rows)-1]
+		cursor.TxID, cursor.EventID = last.TxID, last.ID
+	}
+
+	err = q.AdvanceOutboxCursor(ctx, pgstore.AdvanceOutboxCursorParams{
+		Consumer: c.name,
+		TxID:     cursor.TxID,
+		EventID:  cursor.EventID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+
+	c.touched = time.Now()
+	metrics.OutboxDeliveries.WithLabelValues(c.name).Add(float64(len(rows)))
+
+	return len(rows), nil
+}
+
+// handle hands events to c in a span of its own, linked to the spans that
+// wrote them: a batch can gather events of several requests.
+func (d *Dispatcher) handle(ctx context.Context, c *consumer, q *pgstore.Queries, events []entity.Message, links []trace.Link) error {
+	ctx, span := tracing.Tracer().Start(ctx, "outbox.Deliver",
+		trace.WithNewRoot(),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("consumer", c.name),
+			attribute.Int("event_count", len(events)),
+		),
+	)
+	defer span.End()
+
+	if err := c.handle(ctx, q, events); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) cleanup(ctx context.Context) {
+	stale, err := d.q.DeleteStaleOutboxCursors(ctx, d.opts.ConsumerTTL.Seconds())
+	if err != nil {
+		logger().Error("failed to delete stale cursors", "error", err)
+		return
+	}
+	if stale > 0 {
+		logger().Info("deleted stale cursors", "count", stale)
+	}
+
+	deleted, err := d.q.DeleteProcessedOutboxEvents(ctx)
+	if err != nil {
+		logger().Error("failed to delete processed events", "error", err)
+		return
+	}
+	if deleted > 0 {
+		logger().Debug("deleted processed events", "count", deleted)
+	}
+}
+
+func logger() *slog.Logger {
+	return logging.For("outbox")
+}
@@ -0,0 +1,318 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore/pgstoretest"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// autocommit begins transactions whose statements commit one by one. The
+// in-memory DB runs one transaction at a time: with it, the dispatcher can
+// deliver while a test holds a transaction open.
+type autocommit struct {
+	db pgstore.DBTX
+}
+
+func (a autocommit) BeginTx(context.Context, pgx.TxOptions) (pgx.Tx, error) {
+	return autocommitTx{db: a.db}, nil
+}
+
+type autocommitTx struct {
+	pgx.Tx
+	db pgstore.DBTX
+}
+
+func (t autocommitTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return t.db.Exec(ctx, sql, args...)
+}
+
+func (t autocommitTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return t.db.Query(ctx, sql, args...)
+}
+
+func (t autocommitTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return t.db.QueryRow(ctx, sql, args...)
+}
+
+func (t autocommitTx) Commit(context.Context) error   { return nil }
+func (t autocommitTx) Rollback(context.Context) error { return nil }
+
+// recorder is a consumer collecting the IDs of the messages it was handed.
+type recorder struct {
+	ids []string
+	// fail makes the next call fail.
+	fail bool
+	ctx  context.Context
+}
+
+func (r *recorder) handle(ctx context.Context, _ *pgstore.Queries, events []entity.Message) error {
+	if r.fail {
+		r.fail = false
+		return errors.New("consumer failed")
+	}
+
+	r.ctx = ctx
+	for _, msg := range events {
+		r.ids = append(r.ids, msg.Value.(entity.MessageMessageCreated).ID)
+	}
+	return nil
+}
+
+// take returns the IDs recorded since the previous call.
+func (r *recorder) take() []string {
+	ids := r.ids
+	r.ids = nil
+	return ids
+}
+
+type fixture struct {
+	db     pgstoretest.Store
+	q      *pgstore.Queries
+	d      *Dispatcher
+	roomID uuid.UUID
+}
+
+func newFixture(t *testing.T, opts Options, consumers ...*recorder) *fixture {
+	t.Helper()
+
+	ctx := context.Background()
+	db := pgstoretest.Open(t)
+	q := pgstore.New(db)
+
+	roomID, err := q.InsertRoom(ctx, "Release planning")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(q, autocommit{db: db}, opts)
+	for i, c := range consumers {
+		d.Register(fmt.Sprintf("consumer-%d", i), c.handle)
+		if err := q.InitOutboxCursor(ctx, d.consumers[i].name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &fixture{db: db, q: q, d: d, roomID: roomID}
+}
+
+// write adds a message_created event named id to the outbox through q.
+func (f *fixture) write(t *testing.T, ctx context.Context, q *pgstore.Queries, id string) {
+	t.Helper()
+
+	err := Write(ctx, q, entity.Message{
+		Kind:   entity.MessageKindMessageCreated,
+		RoomId: f.roomID.String(),
+		Value:  entity.MessageMessageCreated{ID: id, Message: id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// drain delivers to the consumer i until it caught up.
+func (f *fixture) drain(t *testing.T, i int) {
+	t.Helper()
+
+	f.d.drain(context.Background(), f.d.consumers[i])
+}
+
+// pending returns the number of events left in the outbox.
+func (f *fixture) pending(t *testing.T) int {
+	t.Helper()
+
+	rows, err := f.q.ListOutboxEvents(context.Background(), pgstore.ListOutboxEventsParams{MaxEvents: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return len(rows)
+}
+
+func TestDeliverInCommitOrder(t *testing.T) {
+	ctx := context.Background()
+	r := &recorder{}
+	f := newFixture(t, Options{BatchSize: 2}, r)
+
+	// a1 and a2 are written by a transaction that began before b was
+	// committed: b has the smaller ID but commits last.
+	tx, err := f.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	f.write(t, ctx, f.q.WithTx(tx), "a1")
+	f.write(t, ctx, f.q, "b")
+	f.write(t, ctx, f.q.WithTx(tx), "a2")
+
+	// b is held back until every transaction older than it ended, or the
+	// cursor would move past a1 and a2 before they commit.
+	f.drain(t, 0)
+	if got := r.take(); len(got) != 0 {
+		t.Fatalf("delivered %q while an older transaction was open", got)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The batch size splits them in two batches, drained at once.
+	f.drain(t, 0)
+	if got, want := r.take(), []string{"a1", "a2", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("delivered %q, want %q", got, want)
+	}
+
+	// The cursor moved past them.
+	f.write(t, ctx, f.q, "c")
+	f.drain(t, 0)
+	f.drain(t, 0)
+	if got, want := r.take(), []string{"c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+}
+
+func TestDeliverRetriesFailedBatches(t *testing.T) {
+	ctx := context.Background()
+	r := &recorder{fail: true}
+	f := newFixture(t, Options{}, r)
+
+	f.write(t, ctx, f.q, "a")
+
+	f.drain(t, 0)
+	if got := r.take(); len(got) != 0 {
+		t.Fatalf("delivered %q to a failing consumer", got)
+	}
+
+	f.drain(t, 0)
+	if got, want := r.take(), []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %q after a failure, want %q", got, want)
+	}
+}
+
+func TestDeliverSkipsUndecodableEvents(t *testing.T) {
+	ctx := context.Background()
+	r := &recorder{}
+	f := newFixture(t, Options{}, r)
+
+	f.write(t, ctx, f.q, "a")
+	err := f.q.InsertOutboxEvent(ctx, pgstore.InsertOutboxEventParams{
+		RoomID:  f.roomID,
+		Kind:    entity.MessageKindMessageCreated,
+		Payload: []byte(`{"kind":"message_created","value":{"id":42}}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.write(t, ctx, f.q, "b")
+
+	f.drain(t, 0)
+	if got, want := r.take(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("delivered %q, want %q", got, want)
+	}
+
+	// The cursor moved past the undecodable event too.
+	f.drain(t, 0)
+	if got := r.take(); len(got) != 0 {
+		t.Errorf("delivered %q again", got)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	ctx := context.Background()
+	fast, slow := &recorder{}, &recorder{}
+	f := newFixture(t, Options{ConsumerTTL: time.Hour}, fast, slow)
+
+	f.write(t, ctx, f.q, "a")
+	f.write(t, ctx, f.q, "b")
+
+	// Events are kept until every consumer processed them.
+	f.drain(t, 0)
+	f.d.cleanup(ctx)
+	if got := f.pending(t); got != 2 {
+		t.Fatalf("%d events left while a consumer is behind, want 2", got)
+	}
+
+	f.drain(t, 1)
+	f.d.cleanup(ctx)
+	if got := f.pending(t); got != 0 {
+		t.Fatalf("%d events left after every consumer processed them, want 0", got)
+	}
+}
+
+func TestCleanupDropsStaleConsumers(t *testing.T) {
+	ctx := context.Background()
+	fast, gone := &recorder{}, &recorder{}
+	f := newFixture(t, Options{ConsumerTTL: 200 * time.Millisecond}, fast, gone)
+
+	f.write(t, ctx, f.q, "a")
+	time.Sleep(300 * time.Millisecond)
+
+	// The first consumer keeps delivering; the other one went away.
+	f.drain(t, 0)
+	f.d.cleanup(ctx)
+
+	if got := f.pending(t); got != 0 {
+		t.Errorf("%d events held back by a stale consumer, want 0", got)
+	}
+	if _, err := f.q.LockOutboxCursor(ctx, f.d.consumers[1].name); !errors.Is(err, pgx.ErrNoRows) {
+		t.Errorf("stale cursor still there: %v", err)
+	}
+	if _, err := f.q.LockOutboxCursor(ctx, f.d.consumers[0].name); err != nil {
+		t.Errorf("active cursor deleted: %v", err)
+	}
+}
+
+func TestDeliveryLinksToWriter(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	r := &recorder{}
+	f := newFixture(t, Options{}, r)
+
+	ctx, request := otel.Tracer("test").Start(context.Background(), "request")
+	f.write(t, ctx, f.q, "a")
+	request.End()
+	f.write(t, context.Background(), f.q, "b")
+
+	f.drain(t, 0)
+	if got, want := r.take(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("delivered %q, want %q", got, want)
+	}
+
+	var deliver sdktrace.ReadOnlySpan
+	for _, span := range spans.Ended() {
+		if span.Name() == "outbox.Deliver" {
+			deliver = span
+		}
+	}
+	if deliver == nil {
+		t.Fatal("no outbox.Deliver span")
+	}
+
+	if deliver.Parent().IsValid() {
+		t.Error("delivery span is not a root span")
+	}
+	links := deliver.Links()
+	if len(links) != 1 || links[0].SpanContext.SpanID() != request.SpanContext().SpanID() || links[0].SpanContext.TraceID() != request.SpanContext().TraceID() {
+		t.Errorf("delivery links %+v, want one link to the writing request", links)
+	}
+	if sc := trace.SpanContextFromContext(r.ctx); sc.SpanID() != deliver.SpanContext().SpanID() {
+		t.Error("consumer not handed the delivery span")
+	}
+}
@@ -0,0 +1,210 @@
+package presence
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+const (
+	// DefaultThrottle is the minimum time between two presence_changed
+	// events for the same room.
+	DefaultThrottle = 2 * time.Second
+	// DefaultHeartbeat is how often this instance refreshes its connection rows.
+	DefaultHeartbeat = 10 * time.Second
+	// DefaultTTL is how long rows of an instance that stopped heartbeating
+	// are still counted.
+	DefaultTTL = 30 * time.Second
+)
+
+// Tracker keeps the connections to this instance in the room_participants
+// table, so counts are shared by every instance using the same database.
+// Clients are not authenticated, so presence counts connections rather than
+// people: each connection has a row of its own, whose participant_id is the
+// connection ID.
+type Tracker struct {
+	q          *pgstore.Queries
+	instanceID string
+
+	throttleInterval  time.Duration
+	heartbeatInterval time.Duration
+	ttl               time.Duration
+
+	mu     sync.Mutex
+	local  map[uuid.UUID]map[string]struct{}
+	counts map[uuid.UUID]int64
+}
+
+func NewTracker(q *pgstore.Queries, instanceID string) *Tracker {
+	return &Tracker{
+		q:                 q,
+		instanceID:        instanceID,
+		throttleInterval:  DefaultThrottle,
+		heartbeatInterval: DefaultHeartbeat,
+		ttl:               DefaultTTL,
+		local:             make(map[uuid.UUID]map[string]struct{}),
+		counts:            make(map[uuid.UUID]int64),
+	}
+}
+
+// NewInstanceID returns an identifier unique to this process.
+func NewInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "wsrs"
+	}
+
+	return fmt.Sprintf("%s-%s", hostname, uuid.NewString()[:8])
+}
+
+// Join registers connectionID, unique to a connection, as present in the
+// room.
+func (t *Tracker) Join(ctx context.Context, roomID uuid.UUID, connectionID string) error {
+	t.mu.Lock()
+	if _, ok := t.local[roomID]; !ok {
+		t.local[roomID] = make(map[string]struct{})
+	}
+	t.local[roomID][connectionID] = struct{}{}
+	t.mu.Unlock()
+
+	return t.q.UpsertRoomParticipant(ctx, pgstore.UpsertRoomParticipantParams{
+		RoomID:        roomID,
+		ParticipantID: connectionID,
+		InstanceID:    t.instanceID,
+	})
+}
+
+// Leave releases a connection registered with Join.
+func (t *Tracker) Leave(ctx context.Context, roomID uuid.UUID, connectionID string) error {
+	t.mu.Lock()
+	connections, ok := t.local[roomID]
+	if !ok {
+		t.mu.Unlock()
+		return nil
+	}
+
+	delete(connections, connectionID)
+	if len(connections) == 0 {
+		delete(t.local, roomID)
+		delete(t.counts, roomID)
+	}
+	t.mu.Unlock()
+
+	return t.q.DeleteRoomParticipant(ctx, pgstore.DeleteRoomParticipantParams{
+		RoomID:        roomID,
+		ParticipantID: connectionID,
+		InstanceID:    t.instanceID,
+	})
+}
+
+// Run heartbeats this instance's rows and broadcasts a presence_changed
+// event, at most once per throttle interval, for every room with local
+// subscribers whose count changed. It removes this instance's rows when ctx
+// is done.
+func (t *Tracker) Run(ctx context.Context, notify func(entity.Message)) {
+	throttle := time.NewTicker(t.throttleInterval)
+	defer throttle.Stop()
+
+	heartbeat := time.NewTicker(t.heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			cleanupCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := t.q.DeleteInstanceParticipants(cleanupCtx, t.instanceID); err != nil {
+				logging.For("presence").Error("failed to remove presence rows", "instance_id", t.instanceID, "error", err)
+			}
+			cancel()
+			return
+		case <-heartbeat.C:
+			t.heartbeat(ctx)
+		case <-throttle.C:
+			t.broadcast(ctx, notify)
+		}
+	}
+}
+
+// heartbeat refreshes the rows of every local connection. Rows are upserted
+// rather than updated, so a live connection whose row was removed as stale,
+// after the database or this instance stalled for longer than the TTL, is
+// counted again.
+func (t *Tracker) heartbeat(ctx context.Context) {
+	params := pgstore.UpsertInstanceParticipantsParams{InstanceID: t.instanceID}
+
+	t.mu.Lock()
+	for roomID, connections := range t.local {
+		for connectionID := range connections {
+			params.RoomIds = append(params.RoomIds, roomID)
+			params.ParticipantIds = append(params.ParticipantIds, connectionID)
+		}
+	}
+	t.mu.Unlock()
+
+	if len(params.RoomIds) > 0 {
+		if err := t.q.UpsertInstanceParticipants(ctx, params); err != nil {
+			logging.For("presence").Error("failed to refresh presence", "instance_id", t.instanceID, "error", err)
+		}
+	}
+
+	if err := t.q.DeleteStaleRoomParticipants(ctx, t.ttl.Seconds()); err != nil {
+		logging.For("presence").Error("failed to remove stale presence", "error", err)
+	}
+}
+
+func (t *Tracker) broadcast(ctx context.Context, notify func(entity.Message)) {
+	t.mu.Lock()
+	roomIDs := make([]uuid.UUID, 0, len(t.local))
+	for roomID := range t.local {
+		roomIDs = append(roomIDs, roomID)
+	}
+	t.mu.Unlock()
+
+	if len(roomIDs) == 0 {
+		return
+	}
+
+	rows, err := t.q.CountParticipantsByRoom(ctx, roomIDs)
+	if err != nil {
+		logging.For("presence").Error("failed to count participants", "error", err)
+		return
+	}
+
+	current := make(map[uuid.UUID]int64, len(rows))
+	for _, row := range rows {
+		current[row.RoomID] = row.Count
+	}
+
+	var changed []entity.Message
+
+	t.mu.Lock()
+	for _, roomID := range roomIDs {
+		if _, ok := t.local[roomID]; !ok {
+			continue
+		}
+
+		count := current[roomID]
+		if previous, ok := t.counts[roomID]; ok && previous == count {
+			continue
+		}
+
+		t.counts[roomID] = count
+		changed = append(changed, entity.Message{
+			Kind:   entity.MessageKindPresenceChanged,
+			RoomId: roomID.String(),
+			Value:  entity.MessagePresenceChanged{Count: count},
+		})
+	}
+	t.mu.Unlock()
+
+	for _, msg := range changed {
+		notify(msg)
+	}
+}
@@ -0,0 +1,176 @@
+package presence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore/pgstoretest"
+)
+
+func newTracker(t *testing.T) (*Tracker, *pgstore.Queries, uuid.UUID) {
+	t.Helper()
+
+	q := pgstore.New(pgstoretest.Open(t))
+	roomID, err := q.InsertRoom(context.Background(), "Release planning")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := NewTracker(q, "local")
+	tracker.throttleInterval = time.Hour
+	tracker.heartbeatInterval = time.Hour
+
+	return tracker, q, roomID
+}
+
+// run runs tracker until the test ends and returns the events it broadcasts.
+func run(t *testing.T, tracker *Tracker) <-chan entity.Message {
+	t.Helper()
+
+	events := make(chan entity.Message, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tracker.Run(ctx, func(msg entity.Message) { events <- msg })
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return events
+}
+
+func count(t *testing.T, q *pgstore.Queries, roomID uuid.UUID) int64 {
+	t.Helper()
+
+	n, err := q.CountRoomParticipants(context.Background(), roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func next(t *testing.T, events <-chan entity.Message) int64 {
+	t.Helper()
+
+	select {
+	case msg := <-events:
+		v, ok := entity.Payload[entity.MessagePresenceChanged](msg)
+		if !ok {
+			t.Fatalf("got %s, want %s", msg.Kind, entity.MessageKindPresenceChanged)
+		}
+		return v.Count
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for presence_changed")
+		return 0
+	}
+}
+
+func TestTrackerCountsConnections(t *testing.T) {
+	tracker, q, roomID := newTracker(t)
+	ctx := context.Background()
+
+	for _, connection := range []string{"c1", "c2", "c3"} {
+		if err := tracker.Join(ctx, roomID, connection); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := count(t, q, roomID); n != 3 {
+		t.Fatalf("count %d after three connections, want 3", n)
+	}
+
+	if err := tracker.Leave(ctx, roomID, "c2"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, q, roomID); n != 2 {
+		t.Errorf("count %d after a connection left, want 2", n)
+	}
+}
+
+func TestTrackerThrottlesBroadcasts(t *testing.T) {
+	tracker, _, roomID := newTracker(t)
+	tracker.throttleInterval = 20 * time.Millisecond
+	ctx := context.Background()
+
+	for _, connection := range []string{"c1", "c2", "c3"} {
+		if err := tracker.Join(ctx, roomID, connection); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events := run(t, tracker)
+	if n := next(t, events); n != 3 {
+		t.Fatalf("broadcast a count of %d, want the three joins in one event", n)
+	}
+
+	// Counts that didn't change are not broadcast again.
+	select {
+	case msg := <-events:
+		t.Fatalf("unchanged count broadcast again: %+v", msg.Value)
+	case <-time.After(5 * tracker.throttleInterval):
+	}
+
+	if err := tracker.Leave(ctx, roomID, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := next(t, events); n != 2 {
+		t.Errorf("broadcast a count of %d after a connection left, want 2", n)
+	}
+}
+
+func TestTrackerHeartbeatExpiresStaleRows(t *testing.T) {
+	tracker, q, roomID := newTracker(t)
+	tracker.heartbeatInterval = 10 * time.Millisecond
+	tracker.ttl = 100 * time.Millisecond
+	ctx := context.Background()
+
+	if err := tracker.Join(ctx, roomID, "c1"); err != nil {
+		t.Fatal(err)
+	}
+	// A connection of an instance that stopped heartbeating.
+	if err := q.UpsertRoomParticipant(ctx, pgstore.UpsertRoomParticipantParams{RoomID: roomID, ParticipantID: "c2", InstanceID: "gone"}); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, tracker)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for count(t, q, roomID) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("count %d, want the stale row removed", count(t, q, roomID))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The live row outlives several TTLs, and comes back if it was removed.
+	if err := q.DeleteInstanceParticipants(ctx, "local"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * tracker.ttl)
+	if n := count(t, q, roomID); n != 1 {
+		t.Errorf("count %d after heartbeats, want the live connection", n)
+	}
+}
+
+func TestTrackerRemovesRowsOnShutdown(t *testing.T) {
+	tracker, q, roomID := newTracker(t)
+
+	if err := tracker.Join(context.Background(), roomID, "c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tracker.Run(ctx, func(entity.Message) {})
+
+	if n := count(t, q, roomID); n != 0 {
+		t.Errorf("count %d after shutdown, want 0", n)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+)
+
+// DefaultReloadInterval is how often certificate files are checked for
+// changes.
+const DefaultReloadInterval = 10 * time.Second
+
+// certReloader serves the key pair in certFile and keyFile and reloads it
+// when either file changes, so renewed certificates are picked up without a
+// restart. A pair that fails to load is logged and the previous one kept.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+func (r *certReloader) load() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+
+	return nil
+}
+
+// watch reloads the key pair every interval if the files changed, until ctx
+// is done.
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTime, err := r.lastModified()
+		if err != nil {
+			logging.For("server").Error("failed to check certificate", "cert_file", r.certFile, "error", err)
+			continue
+		}
+
+		r.mu.RLock()
+		changed := !modTime.Equal(r.modTime)
+		r.mu.RUnlock()
+
+		if !changed {
+			continue
+		}
+
+		if err := r.load(); err != nil {
+			logging.For("server").Error("failed to reload certificate", "cert_file", r.certFile, "error", err)
+			continue
+		}
+
+		logging.For("server").Info("certificate reloaded", "cert_file", r.certFile)
+	}
+}
+
+// lastModified returns the latest modification time of the two files.
+func (r *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
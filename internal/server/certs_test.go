@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed key pair for localhost named commonName to
+// certFile and keyFile, dated modTime.
+func writeCert(t *testing.T, certFile, keyFile, commonName string, modTime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	}
+	for name, block := range files {
+		if err := os.WriteFile(name, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// Files written in quick succession may share a modification time.
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// served returns the common name of the certificate r serves.
+func served(t *testing.T, r *certReloader) string {
+	t.Helper()
+
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, "first", start)
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := served(t, r); got != "first" {
+		t.Fatalf("serving %q, want first", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.watch(ctx, 5*time.Millisecond)
+
+	// A renewed pair is picked up.
+	writeCert(t, certFile, keyFile, "renewed", start.Add(time.Minute))
+	deadline := time.Now().Add(5 * time.Second)
+	for served(t, r) != "renewed" {
+		if time.Now().After(deadline) {
+			t.Fatal("renewed certificate not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A pair that fails to load keeps the previous one.
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(certFile, start.Add(2*time.Minute), start.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := served(t, r); got != "renewed" {
+		t.Errorf("serving %q after a failed reload, want renewed", got)
+	}
+
+	// So do missing files.
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := served(t, r); got != "renewed" {
+		t.Errorf("serving %q without a key file, want renewed", got)
+	}
+
+	// Once the pair is fixed, it is reloaded.
+	writeCert(t, certFile, keyFile, "fixed", start.Add(3*time.Minute))
+	deadline = time.Now().Add(5 * time.Second)
+	for served(t, r) != "fixed" {
+		if time.Now().After(deadline) {
+			t.Fatal("fixed certificate not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNewCertReloaderErrors(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	if _, err := newCertReloader(certFile, keyFile); err == nil {
+		t.Error("loaded missing files")
+	}
+
+	writeCert(t, certFile, keyFile, "first", time.Now())
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newCertReloader(certFile, keyFile); err == nil {
+		t.Error("loaded an invalid key")
+	}
+}
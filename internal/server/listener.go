@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// Listener is where the server accepts connections, parsed from a URL:
+//
+//	http://:8080                  plain HTTP/1.1
+//	https://:8443                 TLS with HTTP/2 negotiated over ALPN
+//	h2c://:8081                   HTTP/1.1 and cleartext HTTP/2
+//	unix:///run/wsrs.sock         plain HTTP on a Unix domain socket
+//	unix+h2c:///run/wsrs.sock     cleartext HTTP/2 on a Unix domain socket
+//
+// Unix sockets accept a mode query parameter, as in ?mode=0660.
+type Listener struct {
+	Network string
+	Addr    string
+	TLS     bool
+	H2C     bool
+	// Mode is the permission of a Unix socket. Zero keeps the umask default.
+	Mode fs.FileMode
+
+	spec string
+}
+
+func ParseListener(spec string) (Listener, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return Listener{}, fmt.Errorf("listener %q: %w", spec, err)
+	}
+
+	l := Listener{Network: "tcp", Addr: u.Host, spec: spec}
+
+	switch u.Scheme {
+	case "http":
+	case "https":
+		l.TLS = true
+	case "h2c":
+		l.H2C = true
+	case "unix", "unix+h2c":
+		l.Network = "unix"
+		l.Addr = u.Path
+		l.H2C = u.Scheme == "unix+h2c"
+
+		if mode := u.Query().Get("mode"); mode != "" {
+			m, err := strconv.ParseUint(mode, 8, 32)
+			if err != nil {
+				return Listener{}, fmt.Errorf("listener %q: invalid mode: %w", spec, err)
+			}
+			l.Mode = fs.FileMode(m)
+		}
+	default:
+		return Listener{}, fmt.Errorf("listener %q: scheme must be http, https, h2c, unix or unix+h2c", spec)
+	}
+
+	if l.Addr == "" {
+		return Listener{}, fmt.Errorf("listener %q: missing address", spec)
+	}
+
+	return l, nil
+}
+
+func (l Listener) String() string {
+	return l.spec
+}
+
+func (l Listener) listen() (net.Listener, error) {
+	if l.Network != "unix" {
+		return net.Listen(l.Network, l.Addr)
+	}
+
+	// A socket left behind by a previous run would make Listen fail.
+	if info, err := os.Lstat(l.Addr); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if err := os.Remove(l.Addr); err != nil {
+			return nil, err
+		}
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", l.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Mode != 0 {
+		if err := os.Chmod(l.Addr, l.Mode); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+
+	return ln, nil
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseListener(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Listener
+	}{
+		{"http://:8080", Listener{Network: "tcp", Addr: ":8080"}},
+		{"http://127.0.0.1:8080", Listener{Network: "tcp", Addr: "127.0.0.1:8080"}},
+		{"https://:8443", Listener{Network: "tcp", Addr: ":8443", TLS: true}},
+		{"h2c://[::1]:8081", Listener{Network: "tcp", Addr: "[::1]:8081", H2C: true}},
+		{"unix:///run/wsrs.sock", Listener{Network: "unix", Addr: "/run/wsrs.sock"}},
+		{"unix+h2c:///run/wsrs.sock", Listener{Network: "unix", Addr: "/run/wsrs.sock", H2C: true}},
+		{"unix:///run/wsrs.sock?mode=0660", Listener{Network: "unix", Addr: "/run/wsrs.sock", Mode: 0o660}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseListener(tt.spec)
+		if err != nil {
+			t.Errorf("ParseListener(%q): %v", tt.spec, err)
+			continue
+		}
+
+		tt.want.spec = tt.spec
+		if got != tt.want {
+			t.Errorf("ParseListener(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+		if got.String() != tt.spec {
+			t.Errorf("String() = %q, want %q", got.String(), tt.spec)
+		}
+	}
+}
+
+func TestParseListenerErrors(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"ftp://:21", "scheme must be"},
+		{"localhost:8080", "scheme must be"},
+		{"http://", "missing address"},
+		{"https://", "missing address"},
+		{"unix://", "missing address"},
+		{"unix:///run/wsrs.sock?mode=rw", "invalid mode"},
+		{"unix:///run/wsrs.sock?mode=0999", "invalid mode"},
+		{"http://%zz", "invalid URL escape"},
+	}
+
+	for _, tt := range tests {
+		_, err := ParseListener(tt.spec)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseListener(%q) = %v, want an error containing %q", tt.spec, err, tt.want)
+		}
+	}
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wsrs.sock")
+
+	// A socket left behind by a process that didn't close its listener.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if _, err := os.Lstat(path); err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+
+	l, err := ParseListener("unix://" + path + "?mode=0600")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := l.listen()
+	if err != nil {
+		t.Fatalf("listening over a stale socket: %v", err)
+	}
+	defer ln.Close()
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&fs.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode %v, want a socket with permissions 0600", info.Mode())
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dialing the new socket: %v", err)
+	}
+	conn.Close()
+}
+
+func TestListenKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wsrs.sock")
+	if err := os.WriteFile(path, []byte("not a socket"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := ParseListener("unix://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ln, err := l.listen(); err == nil {
+		ln.Close()
+		t.Fatal("listened over a regular file")
+	}
+
+	if data, err := os.ReadFile(path); err != nil || string(data) != "not a socket" {
+		t.Errorf("file replaced: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,152 @@
+// Package server serves the API handler on several listeners at once:
+// plain HTTP, TLS with HTTP/2, cleartext HTTP/2 and Unix domain sockets.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+type Options struct {
+	// CertFile and KeyFile are the key pair served by TLS listeners.
+	CertFile string
+	KeyFile  string
+	// ReloadInterval is how often the key pair is checked for changes.
+	// Zero uses DefaultReloadInterval.
+	ReloadInterval time.Duration
+}
+
+// Server runs one http.Server per listener, all serving the same handler.
+type Server struct {
+	opts      Options
+	listeners []Listener
+	servers   []*http.Server
+	certs     *certReloader
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+func New(handler http.Handler, listeners []Listener, opts Options) (*Server, error) {
+	if len(listeners) == 0 {
+		return nil, errors.New("server: no listeners")
+	}
+
+	if opts.ReloadInterval <= 0 {
+		opts.ReloadInterval = DefaultReloadInterval
+	}
+
+	s := &Server{opts: opts, listeners: listeners}
+
+	for _, l := range listeners {
+		srv := &http.Server{Handler: handler}
+
+		switch {
+		case l.TLS:
+			if s.certs == nil {
+				certs, err := newCertReloader(opts.CertFile, opts.KeyFile)
+				if err != nil {
+					return nil, fmt.Errorf("server: failed to load certificate: %w", err)
+				}
+				s.certs = certs
+			}
+
+			srv.TLSConfig = &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: s.certs.GetCertificate,
+			}
+		case l.H2C:
+			srv.Handler = h2c.NewHandler(handler, &http2.Server{})
+		}
+
+		s.servers = append(s.servers, srv)
+	}
+
+	return s, nil
+}
+
+// ListenAndServe opens every listener and serves them until Shutdown. If a
+// listener can't be opened, the ones already open are closed and the error
+// returned; if one stops serving, the others are closed too before its error
+// is returned. Like http.Server, it returns http.ErrServerClosed after
+// Shutdown.
+func (s *Server) ListenAndServe() error {
+	var listeners []net.Listener
+	for _, l := range s.listeners {
+		ln, err := l.listen()
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return fmt.Errorf("server: failed to listen on %s: %w", l, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+
+	if s.certs != nil {
+		go s.certs.watch(ctx, s.opts.ReloadInterval)
+	}
+
+	errs := make(chan error, len(s.servers))
+	for i, srv := range s.servers {
+		l, ln := s.listeners[i], listeners[i]
+		logging.For("server").Info("listening", "listener", l.String(), "addr", ln.Addr().String())
+
+		go func(srv *http.Server, l Listener, ln net.Listener) {
+			if l.TLS {
+				errs <- srv.ServeTLS(ln, "", "")
+				return
+			}
+			errs <- srv.Serve(ln)
+		}(srv, l, ln)
+	}
+
+	err := <-errs
+	if !errors.Is(err, http.ErrServerClosed) {
+		// Shutdown was not called: don't leave the process half serving.
+		cancel()
+		for _, srv := range s.servers {
+			srv.Close()
+		}
+	}
+
+	return err
+}
+
+// Shutdown gracefully stops every listener; see http.Server.Shutdown.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(s.servers))
+	for i, srv := range s.servers {
+		wg.Add(1)
+		go func(i int, srv *http.Server) {
+			defer wg.Done()
+			errs[i] = srv.Shutdown(ctx)
+		}(i, srv)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// freeAddr returns a loopback address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	return ln.Addr().String()
+}
+
+func parse(t *testing.T, spec string) Listener {
+	t.Helper()
+
+	l, err := ParseListener(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l
+}
+
+// protoHandler answers with the protocol of the request.
+var protoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, r.Proto)
+})
+
+// get requests url with client and returns the body, retrying until the
+// listener is open.
+func get(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := client.Get(url)
+		if err == nil {
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(body)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func unixClient(path string, h2c bool) *http.Client {
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", path)
+	}
+	if h2c {
+		return &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, _, _ string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx)
+			},
+		}}
+	}
+
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dial(ctx)
+		},
+	}}
+}
+
+func TestServeEveryListener(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "localhost", time.Now())
+
+	httpAddr, httpsAddr, h2cAddr := freeAddr(t), freeAddr(t), freeAddr(t)
+	socket, h2cSocket := filepath.Join(dir, "http.sock"), filepath.Join(dir, "h2c.sock")
+
+	s, err := New(protoHandler, []Listener{
+		parse(t, "http://"+httpAddr),
+		parse(t, "https://"+httpsAddr),
+		parse(t, "h2c://"+h2cAddr),
+		parse(t, "unix://"+socket),
+		parse(t, "unix+h2c://"+h2cSocket),
+	}, Options{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe() }()
+
+	h2cClient := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	tlsClient := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+
+	tests := []struct {
+		name   string
+		client *http.Client
+		url    string
+		want   string
+	}{
+		{"http", http.DefaultClient, "http://" + httpAddr, "HTTP/1.1"},
+		{"https", tlsClient, "https://" + httpsAddr, "HTTP/2.0"},
+		{"h2c", h2cClient, "http://" + h2cAddr, "HTTP/2.0"},
+		{"h2c upgrade to HTTP/1.1", http.DefaultClient, "http://" + h2cAddr, "HTTP/1.1"},
+		{"unix", unixClient(socket, false), "http://unix", "HTTP/1.1"},
+		{"unix+h2c", unixClient(h2cSocket, true), "http://unix", "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		if got := get(t, tt.client, tt.url); got != tt.want {
+			t.Errorf("%s: served over %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-served:
+		if !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("ListenAndServe returned %v after Shutdown, want http.ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenAndServe did not return after Shutdown")
+	}
+}
+
+func TestListenAndServeClosesListenersItOpened(t *testing.T) {
+	addr := freeAddr(t)
+
+	// The second listener can't be opened: its address is taken.
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	s, err := New(protoHandler, []Listener{
+		parse(t, "http://"+addr),
+		parse(t, "http://"+taken.Addr().String()),
+	}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.ListenAndServe(); err == nil {
+		t.Fatal("ListenAndServe succeeded on a taken address")
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("first listener left open: %v", err)
+	}
+	ln.Close()
+}
+
+func TestListenAndServeStopsEveryServerOnError(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, "localhost", time.Now())
+
+	addr := freeAddr(t)
+	s, err := New(protoHandler, []Listener{
+		parse(t, "http://"+addr),
+		parse(t, "https://"+freeAddr(t)),
+	}, Options{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a certificate, the TLS server fails as soon as it starts.
+	s.servers[1].TLSConfig.GetCertificate = nil
+
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe() }()
+
+	select {
+	case err := <-served:
+		if err == nil || errors.Is(err, http.ErrServerClosed) {
+			t.Fatalf("ListenAndServe returned %v, want the error of the TLS server", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenAndServe did not return when a server failed")
+	}
+
+	// The plain HTTP server was closed with it.
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("HTTP server still listening after ListenAndServe returned")
+	}
+}
@@ -0,0 +1,249 @@
+package counters
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+	"github.com/thiagoleet/go-ama-api/internal/outbox"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+// TxBeginner starts transactions. *pgxpool.Pool implements it.
+type TxBeginner interface {
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+}
+
+// WriteBehind accumulates reaction deltas in memory and periodically adds
+// them to messages.reactions_count in a single statement, so concurrent
+// reactions to the same message don't queue on its row lock. Deltas not yet
+// flushed are lost if the process crashes, which bounds the loss to one
+// flush interval.
+//
+// Unflushed deltas are only known to this process, so reads served by
+// another instance would miss them: write-behind is for deployments running
+// a single instance.
+//
+// Reaction events are raised by the flush rather than by each reaction: one
+// per message whose count changed, carrying its new count. With a database
+// they are written to the outbox in the flush transaction.
+type WriteBehind struct {
+	q        *pgstore.Queries
+	db       TxBeginner
+	publish  func(ctx context.Context, msg entity.Message)
+	interval time.Duration
+
+	// flushMu is held exclusively while a flush commits, so View never
+	// observes a delta both in the table and in memory.
+	flushMu sync.RWMutex
+	// flushing serialises flushes.
+	flushing sync.Mutex
+
+	mu sync.Mutex
+	// pending holds the deltas recorded since the last flush started and
+	// inFlight the ones being written by it.
+	pending  map[uuid.UUID]int64
+	inFlight map[uuid.UUID]int64
+}
+
+// NewWriteBehind returns a counter flushing to q every interval. With db,
+// deltas are written in a transaction and only its commit blocks View.
+// Without it the whole write does, and events are handed to publish once
+// written.
+func NewWriteBehind(q *pgstore.Queries, db TxBeginner, interval time.Duration, publish func(ctx context.Context, msg entity.Message)) *WriteBehind {
+	return &WriteBehind{
+		q:        q,
+		db:       db,
+		publish:  publish,
+		interval: interval,
+		pending:  make(map[uuid.UUID]int64),
+		inFlight: make(map[uuid.UUID]int64),
+	}
+}
+
+// View runs fn while no flush is in progress. Reads of reactions_count done
+// inside fn plus Pending give the exact count.
+func (w *WriteBehind) View(fn func() error) error {
+	w.flushMu.RLock()
+	defer w.flushMu.RUnlock()
+
+	return fn()
+}
+
+// Add records delta for messageID and returns its unflushed total, as
+// Pending does.
+func (w *WriteBehind) Add(messageID uuid.UUID, delta int64) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending[messageID] += delta
+	return w.pending[messageID] + w.inFlight[messageID]
+}
+
+// Pending returns the unflushed delta of messageID.
+func (w *WriteBehind) Pending(messageID uuid.UUID) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.pending[messageID] + w.inFlight[messageID]
+}
+
+// Run flushes every interval until ctx is done, then flushes one last time.
+func (w *WriteBehind) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := w.Flush(flushCtx); err != nil {
+				logging.For("counters").Error("failed to flush reaction counters", "error", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := w.Flush(ctx); err != nil {
+				logging.For("counters").Error("failed to flush reaction counters", "error", err)
+			}
+		}
+	}
+}
+
+// Flush writes the pending deltas. On failure they are kept and retried on
+// the next flush.
+func (w *WriteBehind) Flush(ctx context.Context) error {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+
+	// Reactions recorded from now on go to a fresh map, while the swapped
+	// one is still counted by Pending until the write commits.
+	w.mu.Lock()
+	w.inFlight, w.pending = w.pending, make(map[uuid.UUID]int64)
+	params := pgstore.ApplyReactionDeltasParams{}
+	for id, delta := range w.inFlight {
+		if delta == 0 {
+			continue
+		}
+		params.Ids = append(params.Ids, id)
+		params.Deltas = append(params.Deltas, delta)
+	}
+	w.mu.Unlock()
+
+	if len(params.Ids) == 0 {
+		w.settle(false)
+		return nil
+	}
+
+	if err := w.apply(ctx, params); err != nil {
+		w.settle(true)
+		return err
+	}
+
+	return nil
+}
+
+// apply writes params and forgets the in-flight deltas as it commits.
+func (w *WriteBehind) apply(ctx context.Context, params pgstore.ApplyReactionDeltasParams) error {
+	if w.db == nil {
+		w.flushMu.Lock()
+		counts, err := w.q.ApplyReactionDeltas(ctx, params)
+		if err != nil {
+			w.flushMu.Unlock()
+			return err
+		}
+		w.clearInFlight()
+		w.flushMu.Unlock()
+
+		if w.publish != nil {
+			for _, msg := range events(params, counts) {
+				w.publish(ctx, msg)
+			}
+		}
+		return nil
+	}
+
+	tx, err := w.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+
+	// Rolling back a committed transaction is a no-op.
+	defer func() {
+		_ = tx.Rollback(context.WithoutCancel(ctx))
+	}()
+
+	qtx := w.q.WithTx(tx)
+
+	counts, err := qtx.ApplyReactionDeltas(ctx, params)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range events(params, counts) {
+		if err := outbox.Write(ctx, qtx, msg); err != nil {
+			return err
+		}
+	}
+
+	// The new counts become visible on commit, when the in-flight deltas
+	// must stop being added to them.
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	w.clearInFlight()
+	return nil
+}
+
+// events returns a reaction event for each message in counts, added or
+// removed depending on the sign of its delta.
+func events(params pgstore.ApplyReactionDeltasParams, counts []pgstore.ApplyReactionDeltasRow) []entity.Message {
+	deltas := make(map[uuid.UUID]int64, len(params.Ids))
+	for i, id := range params.Ids {
+		deltas[id] = params.Deltas[i]
+	}
+
+	msgs := make([]entity.Message, 0, len(counts))
+	for _, c := range counts {
+		msg := entity.Message{
+			Kind:   entity.MessageKindMessageReactAdded,
+			RoomId: c.RoomID.String(),
+			Value:  entity.MessageMessageReactAdded{ID: c.ID.String(), Count: c.ReactionsCount},
+		}
+		if deltas[c.ID] < 0 {
+			msg.Kind = entity.MessageKindMessageReactRemoved
+			msg.Value = entity.MessageMessageReactRemoved{ID: c.ID.String(), Count: c.ReactionsCount}
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+func (w *WriteBehind) clearInFlight() {
+	w.mu.Lock()
+	w.inFlight = make(map[uuid.UUID]int64)
+	w.mu.Unlock()
+}
+
+// settle empties the in-flight deltas after a flush that didn't write them,
+// adding them back to the pending ones when retry is set.
+func (w *WriteBehind) settle(retry bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if retry {
+		for id, delta := range w.inFlight {
+			w.pending[id] += delta
+		}
+	}
+	w.inFlight = make(map[uuid.UUID]int64)
+}
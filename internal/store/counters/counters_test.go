@@ -0,0 +1,211 @@
+package counters
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore/pgstoretest"
+)
+
+func seed(t *testing.T, q *pgstore.Queries) (uuid.UUID, uuid.UUID, uuid.UUID) {
+	t.Helper()
+
+	ctx := context.Background()
+	roomID, err := q.InsertRoom(ctx, "Release planning")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids [2]uuid.UUID
+	for i := range ids {
+		if ids[i], err = q.InsertMessage(ctx, pgstore.InsertMessageParams{RoomID: roomID, Message: "When is the next release?"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return roomID, ids[0], ids[1]
+}
+
+func outboxEvents(t *testing.T, q *pgstore.Queries) []entity.Message {
+	t.Helper()
+
+	rows, err := q.ListOutboxEvents(context.Background(), pgstore.ListOutboxEventsParams{MaxEvents: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []entity.Message
+	for _, row := range rows {
+		msg, err := entity.Events.Decode(row.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+func TestFlushWritesCoalescedEventsToTheOutbox(t *testing.T) {
+	db := pgstoretest.Open(t)
+	q := pgstore.New(db)
+	roomID, added, removed := seed(t, q)
+	for i := 0; i < 2; i++ {
+		if _, err := q.ReactToMessage(context.Background(), pgstore.ReactToMessageParams{ID: removed}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := NewWriteBehind(q, db, time.Hour, nil)
+	w.Add(added, 1)
+	w.Add(added, 1)
+	w.Add(added, 1)
+	w.Add(removed, 1)
+	w.Add(removed, -2)
+
+	if events := outboxEvents(t, q); len(events) != 0 {
+		t.Fatalf("%d events written before the flush", len(events))
+	}
+
+	if err := w.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]entity.Message{}
+	for _, msg := range outboxEvents(t, q) {
+		if msg.RoomId != roomID.String() {
+			t.Errorf("%s event for room %s, want %s", msg.Kind, msg.RoomId, roomID)
+		}
+		got[msg.Kind] = msg
+	}
+	if len(got) != 2 {
+		t.Fatalf("got events %v, want one added and one removed", got)
+	}
+
+	if v, _ := entity.Payload[entity.MessageMessageReactAdded](got[entity.MessageKindMessageReactAdded]); v != (entity.MessageMessageReactAdded{ID: added.String(), Count: 3}) {
+		t.Errorf("added event %+v, want a count of 3 for %s", v, added)
+	}
+	if v, _ := entity.Payload[entity.MessageMessageReactRemoved](got[entity.MessageKindMessageReactRemoved]); v != (entity.MessageMessageReactRemoved{ID: removed.String(), Count: 1}) {
+		t.Errorf("removed event %+v, want a count of 1 for %s", v, removed)
+	}
+
+	if w.Pending(added) != 0 || w.Pending(removed) != 0 {
+		t.Error("deltas still pending after the flush")
+	}
+}
+
+func TestFlushPublishesWithoutTransactions(t *testing.T) {
+	q := pgstore.New(pgstoretest.Open(t))
+	_, id, _ := seed(t, q)
+
+	var mu sync.Mutex
+	var published []entity.Message
+	w := NewWriteBehind(q, nil, time.Hour, func(_ context.Context, msg entity.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		published = append(published, msg)
+	})
+
+	w.Add(id, 2)
+	if err := w.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(published) != 1 || published[0].Kind != entity.MessageKindMessageReactAdded {
+		t.Fatalf("published %v, want one added event", published)
+	}
+	if v, _ := entity.Payload[entity.MessageMessageReactAdded](published[0]); v.Count != 2 {
+		t.Errorf("published count %d, want 2", v.Count)
+	}
+	if events := outboxEvents(t, q); len(events) != 0 {
+		t.Errorf("%d events written to the outbox without transactions", len(events))
+	}
+}
+
+// stalledDB holds flushes in ApplyReactionDeltas until release is closed.
+type stalledDB struct {
+	*pgstoretest.DB
+	applying chan struct{}
+	release  chan struct{}
+}
+
+func (db *stalledDB) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return stalledTx{Tx: tx, db: db}, nil
+}
+
+type stalledTx struct {
+	pgx.Tx
+	db *stalledDB
+}
+
+func (tx stalledTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if strings.Contains(sql, "name: ApplyReactionDeltas") {
+		close(tx.db.applying)
+		<-tx.db.release
+	}
+
+	return tx.Tx.Query(ctx, sql, args...)
+}
+
+func TestAddCountsDeltasBeingFlushed(t *testing.T) {
+	db := &stalledDB{DB: pgstoretest.New(), applying: make(chan struct{}), release: make(chan struct{})}
+	q := pgstore.New(db)
+	_, id, _ := seed(t, q)
+
+	w := NewWriteBehind(q, db, time.Hour, nil)
+	if got := w.Add(id, 1); got != 1 {
+		t.Fatalf("Add returned %d, want 1", got)
+	}
+
+	flushed := make(chan error, 1)
+	go func() { flushed <- w.Flush(context.Background()) }()
+	<-db.applying
+
+	// The first delta is in flight and not yet in the table: the count
+	// read now plus Add must still include it.
+	var count int64
+	if err := w.View(func() error {
+		message, err := q.GetMessage(context.Background(), id)
+		if err != nil {
+			return err
+		}
+		count = message.ReactionsCount + w.Add(id, 1)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("count %d while a flush is in progress, want 2", count)
+	}
+	if got := w.Pending(id); got != 2 {
+		t.Errorf("Pending returned %d, want 2", got)
+	}
+
+	close(db.release)
+	if err := <-flushed; err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := q.GetMessage(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total := message.ReactionsCount + w.Pending(id); total != 2 {
+		t.Errorf("count %d after the flush, want 2", total)
+	}
+}
@@ -0,0 +1,251 @@
+// Package migrate applies the migrations embedded in pgstore. It reads
+// tern's file format and shares tern's schema_version table, so databases
+// migrated with either tool stay compatible.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+// lockKey identifies the advisory lock held while migrating, so only one
+// replica migrates at a time.
+const lockKey int64 = 0x7773_7273_6d67 // "wsrsmg"
+
+const separator = "---- create above / drop below ----"
+
+var fileName = regexp.MustCompile(`^(\d+)_.+\.sql$`)
+
+type Migration struct {
+	Version int32
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load parses the migrations in fsys, which must be numbered 1 to N
+// without gaps or duplicates.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		up, down, _ := strings.Cut(string(data), separator)
+		migrations = append(migrations, Migration{
+			Version: int32(version),
+			Name:    strings.TrimSuffix(path.Base(entry.Name()), ".sql"),
+			Up:      up,
+			Down:    down,
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, m := range migrations {
+		if i > 0 && m.Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", migrations[i-1].Name, m.Name, m.Version)
+		}
+		if m.Version != int32(i+1) {
+			return nil, fmt.Errorf("migration %s: expected version %d", m.Name, i+1)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Latest returns the version of the last embedded migration.
+func Latest() int32 {
+	migrations, err := Load(pgstore.Migrations())
+	if err != nil {
+		panic(err)
+	}
+
+	return int32(len(migrations))
+}
+
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+// New returns a migrator for the migrations embedded in pgstore.
+func New(pool *pgxpool.Pool) (*Migrator, error) {
+	migrations, err := Load(pgstore.Migrations())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{pool: pool, migrations: migrations}, nil
+}
+
+type Status struct {
+	Current    int32
+	Latest     int32
+	Migrations []Migration
+}
+
+// Status reports the version of the database without taking the migration
+// lock or writing to it, so it can run while another replica migrates. A
+// database without the version table is at version 0.
+func (m *Migrator) Status(ctx context.Context) (*Status, error) {
+	var exists bool
+	if err := m.pool.QueryRow(ctx, "SELECT to_regclass('schema_version') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, err
+	}
+
+	var current int32
+	if exists {
+		// An empty table is at version 0 too.
+		err := m.pool.QueryRow(ctx, "SELECT version FROM schema_version").Scan(&current)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	return &Status{
+		Current:    current,
+		Latest:     int32(len(m.migrations)),
+		Migrations: m.migrations,
+	}, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, int32(len(m.migrations)))
+}
+
+// Down reverts the last applied migration.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *pgx.Conn) error {
+		current, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if current == 0 {
+			return errors.New("no migration to revert")
+		}
+
+		return m.migrate(ctx, conn, current, current-1)
+	})
+}
+
+// To migrates up or down to target.
+func (m *Migrator) To(ctx context.Context, target int32) error {
+	if target < 0 || target > int32(len(m.migrations)) {
+		return fmt.Errorf("target version %d out of range 0-%d", target, len(m.migrations))
+	}
+
+	return m.withLock(ctx, func(conn *pgx.Conn) error {
+		current, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		return m.migrate(ctx, conn, current, target)
+	})
+}
+
+// migrate applies one migration per transaction, so a failure leaves the
+// schema at the last version that succeeded.
+func (m *Migrator) migrate(ctx context.Context, conn *pgx.Conn, current, target int32) error {
+	if current > int32(len(m.migrations)) {
+		return fmt.Errorf("database is at version %d, newer than this build (%d)", current, len(m.migrations))
+	}
+
+	for current != target {
+		var sql string
+		next := current + 1
+		if target < current {
+			sql = m.migrations[current-1].Down
+			next = current - 1
+		} else {
+			sql = m.migrations[current].Up
+		}
+
+		name := m.migrations[max(current, next)-1].Name
+		if strings.TrimSpace(sql) == "" && target < current {
+			return fmt.Errorf("migration %s is irreversible", name)
+		}
+
+		err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, sql); err != nil {
+				return err
+			}
+
+			_, err := tx.Exec(ctx, "UPDATE schema_version SET version = $1", next)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+
+		current = next
+	}
+
+	return nil
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *pgx.Conn) error) error {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		_, _ = conn.Exec(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", lockKey)
+	}()
+
+	if err := ensureVersionTable(ctx, conn.Conn()); err != nil {
+		return err
+	}
+
+	return fn(conn.Conn())
+}
+
+func ensureVersionTable(ctx context.Context, conn *pgx.Conn) error {
+	_, err := conn.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_version (version int4 NOT NULL);
+		INSERT INTO schema_version (version) SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM schema_version);
+	`)
+	return err
+}
+
+func currentVersion(ctx context.Context, conn *pgx.Conn) (int32, error) {
+	var version int32
+	err := conn.QueryRow(ctx, "SELECT version FROM schema_version").Scan(&version)
+	return version, err
+}
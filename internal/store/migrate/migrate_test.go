@@ -0,0 +1,102 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+func file(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"002_add_index.sql":   file("CREATE INDEX i ON t (id);\n" + separator + "\nDROP INDEX i;\n"),
+		"001_create_t.sql":    file("CREATE TABLE t (id int);\n" + separator + "\nDROP TABLE t;\n"),
+		"003_backfill.sql":    file("UPDATE t SET id = id;\n"),
+		"README.md":           file("not a migration"),
+		"tern.conf":           file("[database]"),
+		"004_dir.sql/ignored": file(""),
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		name, up, down string
+	}{
+		{"001_create_t", "CREATE TABLE t (id int);", "DROP TABLE t;"},
+		{"002_add_index", "CREATE INDEX i ON t (id);", "DROP INDEX i;"},
+		{"003_backfill", "UPDATE t SET id = id;", ""},
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("loaded %d migrations, want %d", len(migrations), len(want))
+	}
+
+	for i, m := range migrations {
+		if m.Version != int32(i+1) || m.Name != want[i].name {
+			t.Errorf("migration %d is %d %s, want %d %s", i, m.Version, m.Name, i+1, want[i].name)
+		}
+		if got := strings.TrimSpace(m.Up); got != want[i].up {
+			t.Errorf("%s up %q, want %q", m.Name, got, want[i].up)
+		}
+		if got := strings.TrimSpace(m.Down); got != want[i].down {
+			t.Errorf("%s down %q, want %q", m.Name, got, want[i].down)
+		}
+	}
+}
+
+func TestLoadRejectsBadNumbering(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		want string
+	}{
+		{"gap", fstest.MapFS{
+			"001_a.sql": file("SELECT 1;"),
+			"003_c.sql": file("SELECT 3;"),
+		}, "migration 003_c: expected version 2"},
+		{"not starting at 1", fstest.MapFS{
+			"002_b.sql": file("SELECT 2;"),
+		}, "migration 002_b: expected version 1"},
+		{"duplicate", fstest.MapFS{
+			"001_a.sql":     file("SELECT 1;"),
+			"002_b.sql":     file("SELECT 2;"),
+			"002_b_too.sql": file("SELECT 2;"),
+		}, "share version 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.fsys)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load(pgstore.Migrations())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if Latest() != int32(len(migrations)) {
+		t.Errorf("Latest() = %d, want %d", Latest(), len(migrations))
+	}
+
+	for _, m := range migrations {
+		if strings.TrimSpace(m.Up) == "" {
+			t.Errorf("%s has no up statements", m.Name)
+		}
+		if strings.TrimSpace(m.Down) == "" {
+			t.Errorf("%s can't be reverted", m.Name)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+-- Write your migrate up statements here
+CREATE TABLE
+  IF NOT EXISTS room_participants (
+    "room_id" uuid NOT NULL,
+    "participant_id" VARCHAR(255) NOT NULL,
+    "instance_id" VARCHAR(255) NOT NULL,
+    "last_seen" TIMESTAMPTZ NOT NULL DEFAULT now (),
+    PRIMARY KEY (room_id, participant_id, instance_id),
+    FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE
+  );
+
+---- create above / drop below ----
+DROP TABLE IF EXISTS room_participants;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
\ No newline at end of file
@@ -0,0 +1,17 @@
+-- Write your migrate up statements here
+ALTER TABLE rooms
+  ADD COLUMN IF NOT EXISTS "status" VARCHAR(16) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'closed', 'archived')),
+  ADD COLUMN IF NOT EXISTS "host_token_hash" BYTEA;
+
+ALTER TABLE messages
+  ADD COLUMN IF NOT EXISTS "hidden" BOOLEAN NOT NULL DEFAULT FALSE;
+
+---- create above / drop below ----
+ALTER TABLE messages DROP COLUMN IF EXISTS "hidden";
+
+ALTER TABLE rooms
+  DROP COLUMN IF EXISTS "host_token_hash",
+  DROP COLUMN IF EXISTS "status";
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
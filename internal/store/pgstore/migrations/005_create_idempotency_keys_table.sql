@@ -0,0 +1,21 @@
+-- Write your migrate up statements here
+CREATE TABLE
+  IF NOT EXISTS idempotency_keys (
+    "scope" VARCHAR(255) NOT NULL,
+    "key" VARCHAR(255) NOT NULL,
+    "request_hash" BYTEA NOT NULL,
+    "status_code" INTEGER,
+    "content_type" VARCHAR(255) NOT NULL DEFAULT '',
+    "response_body" BYTEA,
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT now (),
+    "expires_at" TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (scope, key)
+  );
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+---- create above / drop below ----
+DROP TABLE IF EXISTS idempotency_keys;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
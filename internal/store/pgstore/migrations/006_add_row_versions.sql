@@ -0,0 +1,16 @@
+-- Write your migrate up statements here
+-- version is bumped when a row is edited, for If-Match and ETag. Reaction
+-- counts change too often to take part and don't bump it.
+ALTER TABLE rooms
+  ADD COLUMN IF NOT EXISTS "version" BIGINT NOT NULL DEFAULT 1;
+
+ALTER TABLE messages
+  ADD COLUMN IF NOT EXISTS "version" BIGINT NOT NULL DEFAULT 1;
+
+---- create above / drop below ----
+ALTER TABLE messages DROP COLUMN IF EXISTS "version";
+
+ALTER TABLE rooms DROP COLUMN IF EXISTS "version";
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
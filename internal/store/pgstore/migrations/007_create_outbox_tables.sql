@@ -0,0 +1,31 @@
+-- Write your migrate up statements here
+-- tx_id is the transaction that wrote the event. Consumers read events in
+-- (tx_id, id) order and only from transactions older than every one still
+-- running, so an event committed after a later one is never skipped.
+CREATE TABLE
+  IF NOT EXISTS outbox (
+    "id" BIGSERIAL PRIMARY KEY,
+    "tx_id" BIGINT NOT NULL DEFAULT pg_current_xact_id ()::text::bigint,
+    "room_id" uuid NOT NULL,
+    "kind" VARCHAR(255) NOT NULL,
+    "payload" JSONB NOT NULL,
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT now ()
+  );
+
+CREATE INDEX IF NOT EXISTS outbox_tx_id_id_idx ON outbox (tx_id, id);
+
+CREATE TABLE
+  IF NOT EXISTS outbox_cursors (
+    "consumer" VARCHAR(255) PRIMARY KEY,
+    "tx_id" BIGINT NOT NULL,
+    "event_id" BIGINT NOT NULL,
+    "updated_at" TIMESTAMPTZ NOT NULL DEFAULT now ()
+  );
+
+---- create above / drop below ----
+DROP TABLE IF EXISTS outbox_cursors;
+
+DROP TABLE IF EXISTS outbox;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
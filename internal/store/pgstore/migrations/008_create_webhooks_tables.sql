@@ -0,0 +1,49 @@
+-- Write your migrate up statements here
+CREATE TABLE
+  IF NOT EXISTS webhooks (
+    "id" uuid PRIMARY KEY NOT NULL DEFAULT gen_random_uuid (),
+    "room_id" uuid NOT NULL REFERENCES rooms (id) ON DELETE CASCADE,
+    "url" TEXT NOT NULL,
+    "secret" VARCHAR(255) NOT NULL,
+    "kinds" TEXT[] NOT NULL,
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT now ()
+  );
+
+CREATE INDEX IF NOT EXISTS webhooks_room_id_idx ON webhooks (room_id);
+
+-- A delivery is created once per webhook and event. Manual redeliveries are
+-- new rows pointing at the delivery they repeat.
+CREATE TABLE
+  IF NOT EXISTS webhook_deliveries (
+    "id" uuid PRIMARY KEY NOT NULL DEFAULT gen_random_uuid (),
+    "webhook_id" uuid NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    "event_id" VARCHAR(255) NOT NULL,
+    "kind" VARCHAR(255) NOT NULL,
+    "payload" JSONB NOT NULL,
+    "status" VARCHAR(255) NOT NULL DEFAULT 'pending',
+    "attempts" INTEGER NOT NULL DEFAULT 0,
+    "next_attempt_at" TIMESTAMPTZ NOT NULL DEFAULT now (),
+    "last_status_code" INTEGER,
+    "last_error" TEXT NOT NULL DEFAULT '',
+    "redelivery_of" uuid REFERENCES webhook_deliveries (id) ON DELETE SET NULL,
+    "created_at" TIMESTAMPTZ NOT NULL DEFAULT now (),
+    "updated_at" TIMESTAMPTZ NOT NULL DEFAULT now ()
+  );
+
+CREATE UNIQUE INDEX IF NOT EXISTS webhook_deliveries_event_idx ON webhook_deliveries (webhook_id, event_id)
+WHERE
+  redelivery_of IS NULL;
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_created_at_idx ON webhook_deliveries (webhook_id, created_at);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at)
+WHERE
+  status = 'pending';
+
+---- create above / drop below ----
+DROP TABLE IF EXISTS webhook_deliveries;
+
+DROP TABLE IF EXISTS webhooks;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
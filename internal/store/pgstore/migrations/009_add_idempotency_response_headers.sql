@@ -0,0 +1,10 @@
+-- Write your migrate up statements here
+-- Headers such as ETag that are replayed along with the stored response.
+ALTER TABLE idempotency_keys
+  ADD COLUMN IF NOT EXISTS "response_headers" JSONB;
+
+---- create above / drop below ----
+ALTER TABLE idempotency_keys DROP COLUMN IF EXISTS "response_headers";
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
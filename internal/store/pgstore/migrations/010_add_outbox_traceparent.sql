@@ -0,0 +1,11 @@
+-- Write your migrate up statements here
+-- W3C traceparent of the span that wrote the event, so its delivery can be
+-- linked to the request that caused it.
+ALTER TABLE outbox
+  ADD COLUMN IF NOT EXISTS "traceparent" TEXT;
+
+---- create above / drop below ----
+ALTER TABLE outbox DROP COLUMN IF EXISTS "traceparent";
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
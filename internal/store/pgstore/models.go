@@ -6,17 +6,84 @@ package pgstore
 
 import (
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type IdempotencyKey struct {
+	Scope           string
+	Key             string
+	RequestHash     []byte
+	StatusCode      pgtype.Int4
+	ContentType     string
+	ResponseBody    []byte
+	CreatedAt       pgtype.Timestamptz
+	ExpiresAt       pgtype.Timestamptz
+	ResponseHeaders []byte
+}
+
 type Message struct {
 	ID             uuid.UUID
 	RoomID         uuid.UUID
 	Message        string
 	ReactionsCount int64
 	Answered       bool
+	Hidden         bool
+	Version        int64
+}
+
+type Outbox struct {
+	ID          int64
+	TxID        int64
+	RoomID      uuid.UUID
+	Kind        string
+	Payload     []byte
+	CreatedAt   pgtype.Timestamptz
+	Traceparent pgtype.Text
+}
+
+type OutboxCursor struct {
+	Consumer  string
+	TxID      int64
+	EventID   int64
+	UpdatedAt pgtype.Timestamptz
 }
 
 type Room struct {
-	ID    uuid.UUID
-	Theme string
+	ID            uuid.UUID
+	Theme         string
+	Status        string
+	HostTokenHash []byte
+	Version       int64
+}
+
+type RoomParticipant struct {
+	RoomID        uuid.UUID
+	ParticipantID string
+	InstanceID    string
+	LastSeen      pgtype.Timestamptz
+}
+
+type Webhook struct {
+	ID        uuid.UUID
+	RoomID    uuid.UUID
+	Url       string
+	Secret    string
+	Kinds     []string
+	CreatedAt pgtype.Timestamptz
+}
+
+type WebhookDelivery struct {
+	ID             uuid.UUID
+	WebhookID      uuid.UUID
+	EventID        string
+	Kind           string
+	Payload        []byte
+	Status         string
+	Attempts       int32
+	NextAttemptAt  pgtype.Timestamptz
+	LastStatusCode pgtype.Int4
+	LastError      string
+	RedeliveryOf   pgtype.UUID
+	CreatedAt      pgtype.Timestamptz
+	UpdatedAt      pgtype.Timestamptz
 }
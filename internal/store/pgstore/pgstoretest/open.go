@@ -0,0 +1,11 @@
+//go:build !integration
+
+package pgstoretest
+
+import "testing"
+
+// Open returns an empty in-memory DB. Built with the integration tag, it
+// returns a migrated Postgres database instead.
+func Open(tb testing.TB) Store {
+	return New()
+}
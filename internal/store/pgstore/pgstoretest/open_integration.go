@@ -0,0 +1,66 @@
+//go:build integration
+
+package pgstoretest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/thiagoleet/go-ama-api/internal/store/migrate"
+)
+
+// Open returns a pool on a fresh, migrated schema of the Postgres database
+// at WSRS_TEST_DATABASE_URL, dropped when the test ends.
+func Open(tb testing.TB) Store {
+	tb.Helper()
+
+	url := os.Getenv("WSRS_TEST_DATABASE_URL")
+	if url == "" {
+		tb.Fatal("integration tests need WSRS_TEST_DATABASE_URL")
+	}
+
+	ctx := context.Background()
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+
+	admin, err := pgxpool.New(ctx, url)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(admin.Close)
+
+	if _, err := admin.Exec(ctx, fmt.Sprintf("CREATE SCHEMA %s", schema)); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if _, err := admin.Exec(ctx, fmt.Sprintf("DROP SCHEMA %s CASCADE", schema)); err != nil {
+			tb.Error(err)
+		}
+	})
+
+	cfg, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(pool.Close)
+
+	m, err := migrate.New(pool)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := m.Up(ctx); err != nil {
+		tb.Fatal(err)
+	}
+
+	return pool
+}
@@ -0,0 +1,867 @@
+// Package pgstoretest is an in-memory stand-in for Postgres that answers the
+// queries of pgstore, for tests of the packages built on it.
+//
+// Statements are recognized by their sqlc name, not parsed: DB implements
+// what callers rely on, such as column defaults, versions, ON CONFLICT and
+// RETURNING clauses, and nothing else of SQL. Transactions run one at a
+// time, and rolling one back discards every change made since it began.
+package pgstoretest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+const sqlstateForeignKeyViolation = "23503"
+
+// Store is what tests need of a database: a pgstore.DBTX that begins
+// transactions. Both *DB and *pgxpool.Pool implement it.
+type Store interface {
+	pgstore.DBTX
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+}
+
+// DB holds the tables. It implements pgstore.DBTX and begins transactions
+// like a pgxpool.Pool.
+type DB struct {
+	// tx is held by the open transaction.
+	tx sync.Mutex
+
+	mu     sync.Mutex
+	tables tables
+	// xid is the last transaction ID handed out, and open the one of the
+	// open transaction, if any.
+	xid  int64
+	open int64
+}
+
+type tables struct {
+	rooms        []pgstore.Room
+	messages     []pgstore.Message
+	participants []pgstore.RoomParticipant
+	idempotency  []pgstore.IdempotencyKey
+	outbox       []pgstore.Outbox
+	cursors      []pgstore.OutboxCursor
+	webhooks     []pgstore.Webhook
+	deliveries   []pgstore.WebhookDelivery
+	outboxID     int64
+}
+
+func (t tables) clone() tables {
+	t.rooms = slices.Clone(t.rooms)
+	t.messages = slices.Clone(t.messages)
+	t.participants = slices.Clone(t.participants)
+	t.idempotency = slices.Clone(t.idempotency)
+	t.outbox = slices.Clone(t.outbox)
+	t.cursors = slices.Clone(t.cursors)
+	t.webhooks = slices.Clone(t.webhooks)
+	t.deliveries = slices.Clone(t.deliveries)
+	return t
+}
+
+func New() *DB {
+	return &DB{}
+}
+
+func (db *DB) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return db.exec(0, sql, args)
+}
+
+func (db *DB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return db.query(0, sql, args)
+}
+
+func (db *DB) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return db.queryRow(0, sql, args)
+}
+
+// BeginTx waits for the open transaction, if any, to finish. Options are
+// ignored: transactions running one at a time are serializable.
+func (db *DB) BeginTx(ctx context.Context, _ pgx.TxOptions) (pgx.Tx, error) {
+	db.tx.Lock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.xid++
+	db.open = db.xid
+
+	return &tx{db: db, xid: db.xid, saved: db.tables.clone()}, nil
+}
+
+// Begin is BeginTx with the default options.
+func (db *DB) Begin(ctx context.Context) (pgx.Tx, error) {
+	return db.BeginTx(ctx, pgx.TxOptions{})
+}
+
+func (db *DB) exec(xid int64, sql string, args []any) (pgconn.CommandTag, error) {
+	_, n, err := db.run(xid, sql, args)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+
+	return pgconn.NewCommandTag(fmt.Sprintf("UPDATE %d", n)), nil
+}
+
+func (db *DB) query(xid int64, sql string, args []any) (pgx.Rows, error) {
+	result, _, err := db.run(xid, sql, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rows{values: result, i: -1}, nil
+}
+
+func (db *DB) queryRow(xid int64, sql string, args []any) pgx.Row {
+	result, _, err := db.run(xid, sql, args)
+	if err == nil && len(result) == 0 {
+		err = pgx.ErrNoRows
+	}
+	if err != nil {
+		return row{err: err}
+	}
+
+	return row{values: result[0]}
+}
+
+// tx is an open transaction. Methods of pgx.Tx not used by pgstore panic.
+type tx struct {
+	pgx.Tx
+
+	db    *DB
+	xid   int64
+	saved tables
+	done  bool
+}
+
+func (t *tx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return t.db.exec(t.xid, sql, args)
+}
+
+func (t *tx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return t.db.query(t.xid, sql, args)
+}
+
+func (t *tx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return t.db.queryRow(t.xid, sql, args)
+}
+
+func (t *tx) Commit(ctx context.Context) error {
+	if t.done {
+		return pgx.ErrTxClosed
+	}
+
+	t.end(false)
+	return nil
+}
+
+func (t *tx) Rollback(ctx context.Context) error {
+	if t.done {
+		return pgx.ErrTxClosed
+	}
+
+	t.end(true)
+	return nil
+}
+
+func (t *tx) end(rollback bool) {
+	t.done = true
+
+	t.db.mu.Lock()
+	if rollback {
+		t.db.tables = t.saved
+	}
+	t.db.open = 0
+	t.db.mu.Unlock()
+
+	t.db.tx.Unlock()
+}
+
+type rows struct {
+	values [][]any
+	i      int
+}
+
+func (r *rows) Close()                                       {}
+func (r *rows) Err() error                                   { return nil }
+func (r *rows) CommandTag() pgconn.CommandTag                { return pgconn.CommandTag{} }
+func (r *rows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *rows) RawValues() [][]byte                          { return nil }
+func (r *rows) Conn() *pgx.Conn                              { return nil }
+
+func (r *rows) Next() bool {
+	r.i++
+	return r.i < len(r.values)
+}
+
+func (r *rows) Scan(dest ...any) error {
+	return scan(r.values[r.i], dest)
+}
+
+func (r *rows) Values() ([]any, error) {
+	return r.values[r.i], nil
+}
+
+type row struct {
+	values []any
+	err    error
+}
+
+func (r row) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+
+	return scan(r.values, dest)
+}
+
+func scan(values, dest []any) error {
+	if len(values) != len(dest) {
+		return fmt.Errorf("pgstoretest: scanning %d columns into %d targets", len(values), len(dest))
+	}
+
+	for i, d := range dest {
+		target := reflect.ValueOf(d).Elem()
+		if values[i] == nil {
+			target.SetZero()
+			continue
+		}
+
+		v := reflect.ValueOf(values[i])
+		if !v.Type().AssignableTo(target.Type()) {
+			return fmt.Errorf("pgstoretest: cannot scan %s into %s", v.Type(), target.Type())
+		}
+		target.Set(v)
+	}
+
+	return nil
+}
+
+// run executes the statement named by the sqlc header of sql and returns
+// the rows it returned and how many it affected.
+func (db *DB) run(xid int64, sql string, args []any) ([][]any, int64, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if xid == 0 {
+		db.xid++
+		xid = db.xid
+	}
+
+	name, _, _ := strings.Cut(strings.TrimPrefix(sql, "-- name: "), " ")
+	t := &db.tables
+	now := time.Now()
+
+	switch name {
+	case "GetRoom":
+		id := arg[uuid.UUID](args, 0)
+		return selectRows(t.rooms, func(r pgstore.Room) bool { return r.ID == id }, roomColumns)
+
+	case "GetRooms":
+		return selectRows(t.rooms, func(pgstore.Room) bool { return true }, roomColumns)
+
+	case "InsertRoom":
+		room := pgstore.Room{ID: uuid.New(), Theme: arg[string](args, 0), Status: "open", Version: 1}
+		t.rooms = append(t.rooms, room)
+		return [][]any{{room.ID}}, 1, nil
+
+	case "UpdateRoom":
+		theme, status := arg[pgtype.Text](args, 0), arg[pgtype.Text](args, 1)
+		id, versions := arg[uuid.UUID](args, 2), arg[[]int64](args, 3)
+		return updateRows(t.rooms, func(r pgstore.Room) bool {
+			return r.ID == id && versionMatches(versions, r.Version)
+		}, func(r *pgstore.Room) []any {
+			if theme.Valid {
+				r.Theme = theme.String
+			}
+			if status.Valid {
+				r.Status = status.String
+			}
+			r.Version++
+			return roomColumns(*r)
+		})
+
+	case "SetRoomStatus":
+		id, status := arg[uuid.UUID](args, 0), arg[string](args, 1)
+		return updateRows(t.rooms, func(r pgstore.Room) bool { return r.ID == id }, func(r *pgstore.Room) []any {
+			r.Status = status
+			r.Version++
+			return nil
+		})
+
+	case "SetRoomHostToken":
+		id, hash := arg[uuid.UUID](args, 0), arg[[]byte](args, 1)
+		return updateRows(t.rooms, func(r pgstore.Room) bool { return r.ID == id }, func(r *pgstore.Room) []any {
+			r.HostTokenHash = hash
+			return nil
+		})
+
+	case "ListRoomsWithStats":
+		rooms := slices.Clone(t.rooms)
+		sort.SliceStable(rooms, func(i, j int) bool { return rooms[i].Theme < rooms[j].Theme })
+
+		var result [][]any
+		for _, r := range rooms {
+			var messages int64
+			for _, m := range t.messages {
+				if m.RoomID == r.ID {
+					messages++
+				}
+			}
+			result = append(result, []any{r.ID, r.Theme, r.Status, messages})
+		}
+		return result, int64(len(result)), nil
+
+	case "GetMessage", "GetMessageForUpdate":
+		// Transactions run one at a time: every row is locked already.
+		id := arg[uuid.UUID](args, 0)
+		return selectRows(t.messages, func(m pgstore.Message) bool { return m.ID == id }, messageColumns)
+
+	case "GetRoomMessages":
+		roomID := arg[uuid.UUID](args, 0)
+		return selectRows(t.messages, func(m pgstore.Message) bool { return m.RoomID == roomID }, messageColumns)
+
+	case "InsertMessage":
+		roomID := arg[uuid.UUID](args, 0)
+		if !t.hasRoom(roomID) {
+			return nil, 0, foreignKeyViolation("messages_room_id_fkey")
+		}
+		message := pgstore.Message{ID: uuid.New(), RoomID: roomID, Message: arg[string](args, 1), Version: 1}
+		t.messages = append(t.messages, message)
+		return [][]any{{message.ID}}, 1, nil
+
+	case "ReactToMessage", "RemoveReactionFromMessage":
+		id, versions := arg[uuid.UUID](args, 0), arg[[]int64](args, 1)
+		delta := int64(1)
+		if name == "RemoveReactionFromMessage" {
+			delta = -1
+		}
+		return updateRows(t.messages, func(m pgstore.Message) bool {
+			return m.ID == id && versionMatches(versions, m.Version)
+		}, func(m *pgstore.Message) []any {
+			m.ReactionsCount += delta
+			return []any{m.ReactionsCount}
+		})
+
+	case "MarkMessageAsAnswered":
+		id, versions := arg[uuid.UUID](args, 0), arg[[]int64](args, 1)
+		return updateRows(t.messages, func(m pgstore.Message) bool {
+			return m.ID == id && versionMatches(versions, m.Version)
+		}, func(m *pgstore.Message) []any {
+			m.Answered = true
+			m.Version++
+			return []any{m.Version}
+		})
+
+	case "ApplyReactionDeltas":
+		ids, deltas := arg[[]uuid.UUID](args, 0), arg[[]int64](args, 1)
+		var result [][]any
+		for i, id := range ids {
+			updated, _, _ := updateRows(t.messages, func(m pgstore.Message) bool { return m.ID == id }, func(m *pgstore.Message) []any {
+				m.ReactionsCount += deltas[i]
+				return []any{m.ID, m.RoomID, m.ReactionsCount}
+			})
+			result = append(result, updated...)
+		}
+		return result, int64(len(result)), nil
+
+	case "SetMessageHidden":
+		id, hidden := arg[uuid.UUID](args, 0), arg[bool](args, 1)
+		return updateRows(t.messages, func(m pgstore.Message) bool { return m.ID == id }, func(m *pgstore.Message) []any {
+			m.Hidden = hidden
+			m.Version++
+			return []any{m.RoomID}
+		})
+
+	case "ResetMessageReactions":
+		id := arg[uuid.UUID](args, 0)
+		return updateRows(t.messages, func(m pgstore.Message) bool { return m.ID == id }, func(m *pgstore.Message) []any {
+			m.ReactionsCount = 0
+			return []any{m.RoomID}
+		})
+
+	case "ResetRoomReactions":
+		roomID := arg[uuid.UUID](args, 0)
+		return updateRows(t.messages, func(m pgstore.Message) bool { return m.RoomID == roomID }, func(m *pgstore.Message) []any {
+			m.ReactionsCount = 0
+			return []any{m.ID}
+		})
+
+	case "DeleteMessage":
+		id := arg[uuid.UUID](args, 0)
+		return deleteRows(&t.messages, func(m pgstore.Message) bool { return m.ID == id }, func(m pgstore.Message) []any {
+			return []any{m.RoomID}
+		})
+
+	case "UpsertRoomParticipant":
+		t.upsertParticipant(arg[uuid.UUID](args, 0), arg[string](args, 1), arg[string](args, 2), now)
+		return nil, 1, nil
+
+	case "UpsertInstanceParticipants":
+		instance, roomIDs, participants := arg[string](args, 0), arg[[]uuid.UUID](args, 1), arg[[]string](args, 2)
+		for i := range roomIDs {
+			t.upsertParticipant(roomIDs[i], participants[i], instance, now)
+		}
+		return nil, int64(len(roomIDs)), nil
+
+	case "DeleteRoomParticipant":
+		roomID, participant, instance := arg[uuid.UUID](args, 0), arg[string](args, 1), arg[string](args, 2)
+		return deleteRows(&t.participants, func(p pgstore.RoomParticipant) bool {
+			return p.RoomID == roomID && p.ParticipantID == participant && p.InstanceID == instance
+		}, nil)
+
+	case "DeleteInstanceParticipants":
+		instance := arg[string](args, 0)
+		return deleteRows(&t.participants, func(p pgstore.RoomParticipant) bool { return p.InstanceID == instance }, nil)
+
+	case "DeleteStaleRoomParticipants":
+		before := now.Add(-seconds(arg[float64](args, 0)))
+		return deleteRows(&t.participants, func(p pgstore.RoomParticipant) bool { return p.LastSeen.Time.Before(before) }, nil)
+
+	case "CountRoomParticipants":
+		roomID := arg[uuid.UUID](args, 0)
+		return [][]any{{t.countParticipants(roomID)}}, 1, nil
+
+	case "CountParticipantsByRoom":
+		var result [][]any
+		for _, roomID := range arg[[]uuid.UUID](args, 0) {
+			if count := t.countParticipants(roomID); count > 0 {
+				result = append(result, []any{roomID, count})
+			}
+		}
+		return result, int64(len(result)), nil
+
+	case "ListRoomPresence":
+		var result [][]any
+		seen := map[uuid.UUID]bool{}
+		for _, p := range t.participants {
+			if seen[p.RoomID] {
+				continue
+			}
+			seen[p.RoomID] = true
+
+			var connections int64
+			instances := map[string]bool{}
+			for _, other := range t.participants {
+				if other.RoomID == p.RoomID {
+					connections++
+					instances[other.InstanceID] = true
+				}
+			}
+			result = append(result, []any{p.RoomID, connections, int64(len(instances))})
+		}
+		return result, int64(len(result)), nil
+
+	case "ClaimIdempotencyKey":
+		scope, key := arg[string](args, 0), arg[string](args, 1)
+		claimed := pgstore.IdempotencyKey{
+			Scope:       scope,
+			Key:         key,
+			RequestHash: arg[[]byte](args, 2),
+			CreatedAt:   timestamp(now),
+			ExpiresAt:   timestamp(now.Add(seconds(arg[float64](args, 3)))),
+		}
+		for i, k := range t.idempotency {
+			if k.Scope == scope && k.Key == key {
+				if !k.ExpiresAt.Time.Before(now) {
+					return nil, 0, nil
+				}
+				t.idempotency[i] = claimed
+				return nil, 1, nil
+			}
+		}
+		t.idempotency = append(t.idempotency, claimed)
+		return nil, 1, nil
+
+	case "GetIdempotencyKey":
+		scope, key := arg[string](args, 0), arg[string](args, 1)
+		return selectRows(t.idempotency, func(k pgstore.IdempotencyKey) bool {
+			return k.Scope == scope && k.Key == key
+		}, func(k pgstore.IdempotencyKey) []any {
+			return []any{k.Scope, k.Key, k.RequestHash, k.StatusCode, k.ContentType, k.ResponseBody, k.CreatedAt, k.ExpiresAt, k.ResponseHeaders}
+		})
+
+	case "CompleteIdempotencyKey":
+		scope, key := arg[string](args, 0), arg[string](args, 1)
+		return updateRows(t.idempotency, func(k pgstore.IdempotencyKey) bool {
+			return k.Scope == scope && k.Key == key
+		}, func(k *pgstore.IdempotencyKey) []any {
+			k.StatusCode = arg[pgtype.Int4](args, 2)
+			k.ContentType = arg[string](args, 3)
+			k.ResponseBody = arg[[]byte](args, 4)
+			k.ResponseHeaders = arg[[]byte](args, 5)
+			return nil
+		})
+
+	case "DeleteIdempotencyKey":
+		scope, key := arg[string](args, 0), arg[string](args, 1)
+		return deleteRows(&t.idempotency, func(k pgstore.IdempotencyKey) bool {
+			return k.Scope == scope && k.Key == key
+		}, nil)
+
+	case "DeleteExpiredIdempotencyKeys":
+		return deleteRows(&t.idempotency, func(k pgstore.IdempotencyKey) bool { return k.ExpiresAt.Time.Before(now) }, nil)
+
+	case "InsertOutboxEvent":
+		t.outboxID++
+		t.outbox = append(t.outbox, pgstore.Outbox{
+			ID:          t.outboxID,
+			TxID:        xid,
+			RoomID:      arg[uuid.UUID](args, 0),
+			Kind:        arg[string](args, 1),
+			Payload:     arg[[]byte](args, 2),
+			CreatedAt:   timestamp(now),
+			Traceparent: arg[pgtype.Text](args, 3),
+		})
+		return nil, 1, nil
+
+	case "InitOutboxCursor":
+		consumer := arg[string](args, 0)
+		for _, c := range t.cursors {
+			if c.Consumer == consumer {
+				return nil, 0, nil
+			}
+		}
+		t.cursors = append(t.cursors, pgstore.OutboxCursor{Consumer: consumer, TxID: db.xmin(), UpdatedAt: timestamp(now)})
+		return nil, 1, nil
+
+	case "LockOutboxCursor":
+		consumer := arg[string](args, 0)
+		return selectRows(t.cursors, func(c pgstore.OutboxCursor) bool { return c.Consumer == consumer }, func(c pgstore.OutboxCursor) []any {
+			return []any{c.Consumer, c.TxID, c.EventID, c.UpdatedAt}
+		})
+
+	case "ListOutboxEvents":
+		txID, eventID, limit := arg[int64](args, 0), arg[int64](args, 1), int(arg[int32](args, 2))
+		xmin := db.xmin()
+
+		events := slices.Clone(t.outbox)
+		sort.Slice(events, func(i, j int) bool { return outboxBefore(events[i].TxID, events[i].ID, events[j].TxID, events[j].ID) })
+
+		var result [][]any
+		for _, e := range events {
+			if len(result) == limit {
+				break
+			}
+			if outboxBefore(txID, eventID, e.TxID, e.ID) && e.TxID < xmin {
+				result = append(result, []any{e.ID, e.TxID, e.RoomID, e.Kind, e.Payload, e.CreatedAt, e.Traceparent})
+			}
+		}
+		return result, int64(len(result)), nil
+
+	case "AdvanceOutboxCursor":
+		consumer := arg[string](args, 0)
+		return updateRows(t.cursors, func(c pgstore.OutboxCursor) bool { return c.Consumer == consumer }, func(c *pgstore.OutboxCursor) []any {
+			c.TxID, c.EventID, c.UpdatedAt = arg[int64](args, 1), arg[int64](args, 2), timestamp(now)
+			return nil
+		})
+
+	case "DeleteStaleOutboxCursors":
+		before := now.Add(-seconds(arg[float64](args, 0)))
+		return deleteRows(&t.cursors, func(c pgstore.OutboxCursor) bool { return c.UpdatedAt.Time.Before(before) }, nil)
+
+	case "DeleteProcessedOutboxEvents":
+		if len(t.cursors) == 0 {
+			return nil, 0, nil
+		}
+		oldest := slices.MinFunc(t.cursors, func(a, b pgstore.OutboxCursor) int {
+			if outboxBefore(a.TxID, a.EventID, b.TxID, b.EventID) {
+				return -1
+			}
+			return 1
+		})
+		return deleteRows(&t.outbox, func(e pgstore.Outbox) bool {
+			return !outboxBefore(oldest.TxID, oldest.EventID, e.TxID, e.ID)
+		}, nil)
+
+	case "InsertWebhook":
+		roomID := arg[uuid.UUID](args, 0)
+		if !t.hasRoom(roomID) {
+			return nil, 0, foreignKeyViolation("webhooks_room_id_fkey")
+		}
+		webhook := pgstore.Webhook{
+			ID:        uuid.New(),
+			RoomID:    roomID,
+			Url:       arg[string](args, 1),
+			Secret:    arg[string](args, 2),
+			Kinds:     arg[[]string](args, 3),
+			CreatedAt: timestamp(now),
+		}
+		t.webhooks = append(t.webhooks, webhook)
+		return [][]any{webhookColumns(webhook)}, 1, nil
+
+	case "ListRoomWebhooks":
+		roomID := arg[uuid.UUID](args, 0)
+		return selectRows(t.webhooks, func(w pgstore.Webhook) bool { return w.RoomID == roomID }, webhookColumns)
+
+	case "GetWebhook":
+		id, roomID := arg[uuid.UUID](args, 0), arg[uuid.UUID](args, 1)
+		return selectRows(t.webhooks, func(w pgstore.Webhook) bool { return w.ID == id && w.RoomID == roomID }, webhookColumns)
+
+	case "DeleteWebhook":
+		id, roomID := arg[uuid.UUID](args, 0), arg[uuid.UUID](args, 1)
+		result, n, err := deleteRows(&t.webhooks, func(w pgstore.Webhook) bool { return w.ID == id && w.RoomID == roomID }, nil)
+		if n > 0 {
+			_, _, _ = deleteRows(&t.deliveries, func(d pgstore.WebhookDelivery) bool { return d.WebhookID == id }, nil)
+		}
+		return result, n, err
+
+	case "EnqueueWebhookDeliveries":
+		eventID, kind, payload, roomID := arg[string](args, 0), arg[string](args, 1), arg[[]byte](args, 2), arg[uuid.UUID](args, 3)
+		var enqueued int64
+		for _, w := range t.webhooks {
+			if w.RoomID != roomID || !slices.Contains(w.Kinds, kind) {
+				continue
+			}
+			if slices.ContainsFunc(t.deliveries, func(d pgstore.WebhookDelivery) bool {
+				return d.WebhookID == w.ID && d.EventID == eventID && !d.RedeliveryOf.Valid
+			}) {
+				continue
+			}
+			t.deliveries = append(t.deliveries, newDelivery(w.ID, eventID, kind, payload, now))
+			enqueued++
+		}
+		return nil, enqueued, nil
+
+	case "ClaimWebhookDeliveries":
+		limit, lease := int(arg[int32](args, 0)), seconds(arg[float64](args, 1))
+
+		var due []int
+		for i, d := range t.deliveries {
+			if d.Status == "pending" && !d.NextAttemptAt.Time.After(now) {
+				due = append(due, i)
+			}
+		}
+		sort.SliceStable(due, func(i, j int) bool {
+			return t.deliveries[due[i]].NextAttemptAt.Time.Before(t.deliveries[due[j]].NextAttemptAt.Time)
+		})
+		if len(due) > limit {
+			due = due[:limit]
+		}
+
+		var result [][]any
+		for _, i := range due {
+			d := &t.deliveries[i]
+			d.Attempts++
+			d.NextAttemptAt = timestamp(now.Add(lease))
+			d.UpdatedAt = timestamp(now)
+
+			w, _ := t.webhook(d.WebhookID)
+			result = append(result, []any{d.ID, d.WebhookID, d.EventID, d.Kind, d.Payload, d.Attempts, w.Url, w.Secret})
+		}
+		return result, int64(len(result)), nil
+
+	case "RecordWebhookAttempt":
+		id := arg[uuid.UUID](args, 0)
+		return updateRows(t.deliveries, func(d pgstore.WebhookDelivery) bool { return d.ID == id }, func(d *pgstore.WebhookDelivery) []any {
+			d.Status = arg[string](args, 1)
+			d.LastStatusCode = arg[pgtype.Int4](args, 2)
+			d.LastError = arg[string](args, 3)
+			d.NextAttemptAt = timestamp(now.Add(seconds(arg[float64](args, 4))))
+			d.UpdatedAt = timestamp(now)
+			return nil
+		})
+
+	case "ListWebhookDeliveries":
+		webhookID, limit := arg[uuid.UUID](args, 0), int(arg[int32](args, 1))
+
+		var result [][]any
+		for i := len(t.deliveries) - 1; i >= 0 && len(result) < limit; i-- {
+			if d := t.deliveries[i]; d.WebhookID == webhookID {
+				result = append(result, deliveryColumns(d))
+			}
+		}
+		return result, int64(len(result)), nil
+
+	case "RedeliverWebhookDelivery":
+		id, webhookID := arg[uuid.UUID](args, 0), arg[uuid.UUID](args, 1)
+		for _, d := range t.deliveries {
+			if d.ID == id && d.WebhookID == webhookID {
+				redelivery := newDelivery(d.WebhookID, d.EventID, d.Kind, d.Payload, now)
+				redelivery.RedeliveryOf = pgtype.UUID{Bytes: d.ID, Valid: true}
+				t.deliveries = append(t.deliveries, redelivery)
+				return [][]any{deliveryColumns(redelivery)}, 1, nil
+			}
+		}
+		return nil, 0, nil
+
+	case "DeleteOldWebhookDeliveries":
+		before := now.Add(-seconds(arg[float64](args, 0)))
+		return deleteRows(&t.deliveries, func(d pgstore.WebhookDelivery) bool {
+			return d.Status != "pending" && d.UpdatedAt.Time.Before(before)
+		}, nil)
+	}
+
+	return nil, 0, fmt.Errorf("pgstoretest: unsupported statement %q", name)
+}
+
+// xmin is the oldest transaction still running, as in pg_snapshot_xmin.
+func (db *DB) xmin() int64 {
+	if db.open != 0 {
+		return db.open
+	}
+
+	return db.xid + 1
+}
+
+func (t *tables) hasRoom(id uuid.UUID) bool {
+	return slices.ContainsFunc(t.rooms, func(r pgstore.Room) bool { return r.ID == id })
+}
+
+func (t *tables) webhook(id uuid.UUID) (pgstore.Webhook, bool) {
+	i := slices.IndexFunc(t.webhooks, func(w pgstore.Webhook) bool { return w.ID == id })
+	if i < 0 {
+		return pgstore.Webhook{}, false
+	}
+
+	return t.webhooks[i], true
+}
+
+func (t *tables) upsertParticipant(roomID uuid.UUID, participant, instance string, now time.Time) {
+	for i, p := range t.participants {
+		if p.RoomID == roomID && p.ParticipantID == participant && p.InstanceID == instance {
+			t.participants[i].LastSeen = timestamp(now)
+			return
+		}
+	}
+
+	t.participants = append(t.participants, pgstore.RoomParticipant{
+		RoomID:        roomID,
+		ParticipantID: participant,
+		InstanceID:    instance,
+		LastSeen:      timestamp(now),
+	})
+}
+
+func (t *tables) countParticipants(roomID uuid.UUID) int64 {
+	var count int64
+	for _, p := range t.participants {
+		if p.RoomID == roomID {
+			count++
+		}
+	}
+
+	return count
+}
+
+func newDelivery(webhookID uuid.UUID, eventID, kind string, payload []byte, now time.Time) pgstore.WebhookDelivery {
+	return pgstore.WebhookDelivery{
+		ID:            uuid.New(),
+		WebhookID:     webhookID,
+		EventID:       eventID,
+		Kind:          kind,
+		Payload:       payload,
+		Status:        "pending",
+		NextAttemptAt: timestamp(now),
+		CreatedAt:     timestamp(now),
+		UpdatedAt:     timestamp(now),
+	}
+}
+
+func roomColumns(r pgstore.Room) []any {
+	return []any{r.ID, r.Theme, r.Status, r.HostTokenHash, r.Version}
+}
+
+func messageColumns(m pgstore.Message) []any {
+	return []any{m.ID, m.RoomID, m.Message, m.ReactionsCount, m.Answered, m.Hidden, m.Version}
+}
+
+func webhookColumns(w pgstore.Webhook) []any {
+	return []any{w.ID, w.RoomID, w.Url, w.Secret, w.Kinds, w.CreatedAt}
+}
+
+func deliveryColumns(d pgstore.WebhookDelivery) []any {
+	return []any{
+		d.ID, d.WebhookID, d.EventID, d.Kind, d.Payload, d.Status, d.Attempts, d.NextAttemptAt,
+		d.LastStatusCode, d.LastError, d.RedeliveryOf, d.CreatedAt, d.UpdatedAt,
+	}
+}
+
+func selectRows[T any](table []T, where func(T) bool, columns func(T) []any) ([][]any, int64, error) {
+	var result [][]any
+	for _, r := range table {
+		if where(r) {
+			result = append(result, columns(r))
+		}
+	}
+
+	return result, int64(len(result)), nil
+}
+
+// updateRows applies set to the rows matching where. set returns the
+// RETURNING columns, or nil.
+func updateRows[T any](table []T, where func(T) bool, set func(*T) []any) ([][]any, int64, error) {
+	var result [][]any
+	var n int64
+	for i := range table {
+		if !where(table[i]) {
+			continue
+		}
+		n++
+		if columns := set(&table[i]); columns != nil {
+			result = append(result, columns)
+		}
+	}
+
+	return result, n, nil
+}
+
+func deleteRows[T any](table *[]T, where func(T) bool, returning func(T) []any) ([][]any, int64, error) {
+	var result [][]any
+	var n int64
+	*table = slices.DeleteFunc(*table, func(r T) bool {
+		if !where(r) {
+			return false
+		}
+		n++
+		if returning != nil {
+			result = append(result, returning(r))
+		}
+		return true
+	})
+
+	return result, n, nil
+}
+
+// versionMatches mirrors "versions IS NULL OR version = ANY(versions)": a
+// nil slice is sent as NULL.
+func versionMatches(versions []int64, version int64) bool {
+	return versions == nil || slices.Contains(versions, version)
+}
+
+func outboxBefore(txA, idA, txB, idB int64) bool {
+	return txA < txB || txA == txB && idA < idB
+}
+
+func arg[T any](args []any, i int) T {
+	v, ok := args[i].(T)
+	if !ok && args[i] != nil {
+		panic(fmt.Sprintf("pgstoretest: argument %d is %T, not %T", i+1, args[i], v))
+	}
+
+	return v
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func timestamp(t time.Time) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: t, Valid: true}
+}
+
+func foreignKeyViolation(constraint string) error {
+	return &pgconn.PgError{Code: sqlstateForeignKeyViolation, ConstraintName: constraint, Message: "violates foreign key constraint"}
+}
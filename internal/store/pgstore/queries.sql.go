@@ -9,10 +9,399 @@ import (
 	"context"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const advanceOutboxCursor = `-- name: AdvanceOutboxCursor :exec
+UPDATE outbox_cursors SET tx_id = $2, event_id = $3, updated_at = now() WHERE consumer = $1
+`
+
+type AdvanceOutboxCursorParams struct {
+	Consumer string
+	TxID     int64
+	EventID  int64
+}
+
+func (q *Queries) AdvanceOutboxCursor(ctx context.Context, arg AdvanceOutboxCursorParams) error {
+	_, err := q.db.Exec(ctx, advanceOutboxCursor, arg.Consumer, arg.TxID, arg.EventID)
+	return err
+}
+
+const applyReactionDeltas = `-- name: ApplyReactionDeltas :many
+UPDATE messages SET reactions_count = messages.reactions_count + d.delta
+FROM unnest($1::uuid[], $2::bigint[]) AS d(id, delta)
+WHERE messages.id = d.id
+RETURNING messages.id, messages.room_id, messages.reactions_count
+`
+
+type ApplyReactionDeltasParams struct {
+	Ids    []uuid.UUID
+	Deltas []int64
+}
+
+type ApplyReactionDeltasRow struct {
+	ID             uuid.UUID
+	RoomID         uuid.UUID
+	ReactionsCount int64
+}
+
+func (q *Queries) ApplyReactionDeltas(ctx context.Context, arg ApplyReactionDeltasParams) ([]ApplyReactionDeltasRow, error) {
+	rows, err := q.db.Query(ctx, applyReactionDeltas, arg.Ids, arg.Deltas)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ApplyReactionDeltasRow
+	for rows.Next() {
+		var i ApplyReactionDeltasRow
+		if err := rows.Scan(&i.ID, &i.RoomID, &i.ReactionsCount); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const claimIdempotencyKey = `-- name: ClaimIdempotencyKey :execrows
+INSERT INTO idempotency_keys (scope, key, request_hash, expires_at)
+VALUES ($1, $2, $3, now() + make_interval(secs => $4::float8))
+ON CONFLICT (scope, key) DO UPDATE SET
+  request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = '', response_body = NULL,
+  response_headers = NULL, created_at = now(), expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at < now()
+`
+
+type ClaimIdempotencyKeyParams struct {
+	Scope       string
+	Key         string
+	RequestHash []byte
+	TtlSeconds  float64
+}
+
+func (q *Queries) ClaimIdempotencyKey(ctx context.Context, arg ClaimIdempotencyKeyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, claimIdempotencyKey,
+		arg.Scope,
+		arg.Key,
+		arg.RequestHash,
+		arg.TtlSeconds,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const claimWebhookDeliveries = `-- name: ClaimWebhookDeliveries :many
+WITH due AS (
+  SELECT id FROM webhook_deliveries
+  WHERE status = 'pending' AND next_attempt_at <= now()
+  ORDER BY next_attempt_at
+  LIMIT $1
+  FOR UPDATE SKIP LOCKED
+)
+UPDATE webhook_deliveries d SET
+  attempts = d.attempts + 1,
+  next_attempt_at = now() + make_interval(secs => $2::float8),
+  updated_at = now()
+FROM due, webhooks w
+WHERE d.id = due.id AND w.id = d.webhook_id
+RETURNING d.id, d.webhook_id, d.event_id, d.kind, d.payload, d.attempts, w.url, w.secret
+`
+
+type ClaimWebhookDeliveriesParams struct {
+	MaxDeliveries int32
+	LeaseSeconds  float64
+}
+
+type ClaimWebhookDeliveriesRow struct {
+	ID        uuid.UUID
+	WebhookID uuid.UUID
+	EventID   string
+	Kind      string
+	Payload   []byte
+	Attempts  int32
+	Url       string
+	Secret    string
+}
+
+func (q *Queries) ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]ClaimWebhookDeliveriesRow, error) {
+	rows, err := q.db.Query(ctx, claimWebhookDeliveries, arg.MaxDeliveries, arg.LeaseSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ClaimWebhookDeliveriesRow
+	for rows.Next() {
+		var i ClaimWebhookDeliveriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.WebhookID,
+			&i.EventID,
+			&i.Kind,
+			&i.Payload,
+			&i.Attempts,
+			&i.Url,
+			&i.Secret,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const completeIdempotencyKey = `-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys SET status_code = $3, content_type = $4, response_body = $5, response_headers = $6 WHERE scope = $1 AND key = $2
+`
+
+type CompleteIdempotencyKeyParams struct {
+	Scope           string
+	Key             string
+	StatusCode      pgtype.Int4
+	ContentType     string
+	ResponseBody    []byte
+	ResponseHeaders []byte
+}
+
+func (q *Queries) CompleteIdempotencyKey(ctx context.Context, arg CompleteIdempotencyKeyParams) error {
+	_, err := q.db.Exec(ctx, completeIdempotencyKey,
+		arg.Scope,
+		arg.Key,
+		arg.StatusCode,
+		arg.ContentType,
+		arg.ResponseBody,
+		arg.ResponseHeaders,
+	)
+	return err
+}
+
+const countParticipantsByRoom = `-- name: CountParticipantsByRoom :many
+SELECT room_id, COUNT(*) FROM room_participants WHERE room_id = ANY($1::uuid[]) GROUP BY room_id
+`
+
+type CountParticipantsByRoomRow struct {
+	RoomID uuid.UUID
+	Count  int64
+}
+
+func (q *Queries) CountParticipantsByRoom(ctx context.Context, roomIds []uuid.UUID) ([]CountParticipantsByRoomRow, error) {
+	rows, err := q.db.Query(ctx, countParticipantsByRoom, roomIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountParticipantsByRoomRow
+	for rows.Next() {
+		var i CountParticipantsByRoomRow
+		if err := rows.Scan(&i.RoomID, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countRoomParticipants = `-- name: CountRoomParticipants :one
+SELECT COUNT(*) FROM room_participants WHERE room_id = $1
+`
+
+func (q *Queries) CountRoomParticipants(ctx context.Context, roomID uuid.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countRoomParticipants, roomID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const deleteExpiredIdempotencyKeys = `-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys WHERE expires_at < now()
+`
+
+func (q *Queries) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredIdempotencyKeys)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteIdempotencyKey = `-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2
+`
+
+type DeleteIdempotencyKeyParams struct {
+	Scope string
+	Key   string
+}
+
+func (q *Queries) DeleteIdempotencyKey(ctx context.Context, arg DeleteIdempotencyKeyParams) error {
+	_, err := q.db.Exec(ctx, deleteIdempotencyKey, arg.Scope, arg.Key)
+	return err
+}
+
+const deleteInstanceParticipants = `-- name: DeleteInstanceParticipants :exec
+DELETE FROM room_participants WHERE instance_id = $1
+`
+
+func (q *Queries) DeleteInstanceParticipants(ctx context.Context, instanceID string) error {
+	_, err := q.db.Exec(ctx, deleteInstanceParticipants, instanceID)
+	return err
+}
+
+const deleteMessage = `-- name: DeleteMessage :one
+DELETE FROM messages WHERE id = $1 RETURNING room_id
+`
+
+func (q *Queries) DeleteMessage(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	row := q.db.QueryRow(ctx, deleteMessage, id)
+	var room_id uuid.UUID
+	err := row.Scan(&room_id)
+	return room_id, err
+}
+
+const deleteOldWebhookDeliveries = `-- name: DeleteOldWebhookDeliveries :execrows
+DELETE FROM webhook_deliveries
+WHERE status <> 'pending' AND updated_at < now() - make_interval(secs => $1::float8)
+`
+
+func (q *Queries) DeleteOldWebhookDeliveries(ctx context.Context, retentionSeconds float64) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOldWebhookDeliveries, retentionSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteProcessedOutboxEvents = `-- name: DeleteProcessedOutboxEvents :execrows
+DELETE FROM outbox
+WHERE (tx_id, id) <= (SELECT tx_id, event_id FROM outbox_cursors ORDER BY tx_id, event_id LIMIT 1)
+`
+
+func (q *Queries) DeleteProcessedOutboxEvents(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteProcessedOutboxEvents)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteRoomParticipant = `-- name: DeleteRoomParticipant :exec
+DELETE FROM room_participants WHERE room_id = $1 AND participant_id = $2 AND instance_id = $3
+`
+
+type DeleteRoomParticipantParams struct {
+	RoomID        uuid.UUID
+	ParticipantID string
+	InstanceID    string
+}
+
+func (q *Queries) DeleteRoomParticipant(ctx context.Context, arg DeleteRoomParticipantParams) error {
+	_, err := q.db.Exec(ctx, deleteRoomParticipant, arg.RoomID, arg.ParticipantID, arg.InstanceID)
+	return err
+}
+
+const deleteStaleOutboxCursors = `-- name: DeleteStaleOutboxCursors :execrows
+DELETE FROM outbox_cursors WHERE updated_at < now() - make_interval(secs => $1::float8)
+`
+
+func (q *Queries) DeleteStaleOutboxCursors(ctx context.Context, ttlSeconds float64) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteStaleOutboxCursors, ttlSeconds)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteStaleRoomParticipants = `-- name: DeleteStaleRoomParticipants :exec
+DELETE FROM room_participants WHERE last_seen < now() - make_interval(secs => $1::float8)
+`
+
+func (q *Queries) DeleteStaleRoomParticipants(ctx context.Context, ttlSeconds float64) error {
+	_, err := q.db.Exec(ctx, deleteStaleRoomParticipants, ttlSeconds)
+	return err
+}
+
+const deleteWebhook = `-- name: DeleteWebhook :execrows
+DELETE FROM webhooks WHERE id = $1 AND room_id = $2
+`
+
+type DeleteWebhookParams struct {
+	ID     uuid.UUID
+	RoomID uuid.UUID
+}
+
+func (q *Queries) DeleteWebhook(ctx context.Context, arg DeleteWebhookParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteWebhook, arg.ID, arg.RoomID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const enqueueWebhookDeliveries = `-- name: EnqueueWebhookDeliveries :execrows
+INSERT INTO webhook_deliveries (webhook_id, event_id, kind, payload)
+SELECT id, $1, $2::text, $3 FROM webhooks
+WHERE room_id = $4 AND $2::text = ANY(kinds)
+ON CONFLICT (webhook_id, event_id) WHERE redelivery_of IS NULL DO NOTHING
+`
+
+type EnqueueWebhookDeliveriesParams struct {
+	EventID string
+	Kind    string
+	Payload []byte
+	RoomID  uuid.UUID
+}
+
+func (q *Queries) EnqueueWebhookDeliveries(ctx context.Context, arg EnqueueWebhookDeliveriesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, enqueueWebhookDeliveries,
+		arg.EventID,
+		arg.Kind,
+		arg.Payload,
+		arg.RoomID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getIdempotencyKey = `-- name: GetIdempotencyKey :one
+SELECT "scope", "key", "request_hash", "status_code", "content_type", "response_body", "created_at", "expires_at", "response_headers"
+FROM idempotency_keys WHERE scope = $1 AND key = $2
+`
+
+type GetIdempotencyKeyParams struct {
+	Scope string
+	Key   string
+}
+
+func (q *Queries) GetIdempotencyKey(ctx context.Context, arg GetIdempotencyKeyParams) (IdempotencyKey, error) {
+	row := q.db.QueryRow(ctx, getIdempotencyKey, arg.Scope, arg.Key)
+	var i IdempotencyKey
+	err := row.Scan(
+		&i.Scope,
+		&i.Key,
+		&i.RequestHash,
+		&i.StatusCode,
+		&i.ContentType,
+		&i.ResponseBody,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.ResponseHeaders,
+	)
+	return i, err
+}
+
 const getMessage = `-- name: GetMessage :one
-SELECT "id", "room_id", "message", "reactions_count", "answered" FROM messages WHERE id = $1
+SELECT "id", "room_id", "message", "reactions_count", "answered", "hidden", "version" FROM messages WHERE id = $1
 `
 
 func (q *Queries) GetMessage(ctx context.Context, id uuid.UUID) (Message, error) {
@@ -24,23 +413,50 @@ func (q *Queries) GetMessage(ctx context.Context, id uuid.UUID) (Message, error)
 		&i.Message,
 		&i.ReactionsCount,
 		&i.Answered,
+		&i.Hidden,
+		&i.Version,
+	)
+	return i, err
+}
+
+const getMessageForUpdate = `-- name: GetMessageForUpdate :one
+SELECT "id", "room_id", "message", "reactions_count", "answered", "hidden", "version" FROM messages WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) GetMessageForUpdate(ctx context.Context, id uuid.UUID) (Message, error) {
+	row := q.db.QueryRow(ctx, getMessageForUpdate, id)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.RoomID,
+		&i.Message,
+		&i.ReactionsCount,
+		&i.Answered,
+		&i.Hidden,
+		&i.Version,
 	)
 	return i, err
 }
 
 const getRoom = `-- name: GetRoom :one
-SELECT "id", "theme" FROM rooms WHERE id = $1
+SELECT "id", "theme", "status", "host_token_hash", "version" FROM rooms WHERE id = $1
 `
 
 func (q *Queries) GetRoom(ctx context.Context, id uuid.UUID) (Room, error) {
 	row := q.db.QueryRow(ctx, getRoom, id)
 	var i Room
-	err := row.Scan(&i.ID, &i.Theme)
+	err := row.Scan(
+		&i.ID,
+		&i.Theme,
+		&i.Status,
+		&i.HostTokenHash,
+		&i.Version,
+	)
 	return i, err
 }
 
 const getRoomMessages = `-- name: GetRoomMessages :many
-SELECT "id", "room_id", "message", "reactions_count", "answered" FROM messages WHERE room_id = $1
+SELECT "id", "room_id", "message", "reactions_count", "answered", "hidden", "version" FROM messages WHERE room_id = $1
 `
 
 func (q *Queries) GetRoomMessages(ctx context.Context, roomID uuid.UUID) ([]Message, error) {
@@ -58,6 +474,8 @@ func (q *Queries) GetRoomMessages(ctx context.Context, roomID uuid.UUID) ([]Mess
 			&i.Message,
 			&i.ReactionsCount,
 			&i.Answered,
+			&i.Hidden,
+			&i.Version,
 		); err != nil {
 			return nil, err
 		}
@@ -70,7 +488,7 @@ func (q *Queries) GetRoomMessages(ctx context.Context, roomID uuid.UUID) ([]Mess
 }
 
 const getRooms = `-- name: GetRooms :many
-SELECT "id", "theme" FROM rooms
+SELECT "id", "theme", "status", "host_token_hash", "version" FROM rooms
 `
 
 func (q *Queries) GetRooms(ctx context.Context) ([]Room, error) {
@@ -82,7 +500,13 @@ func (q *Queries) GetRooms(ctx context.Context) ([]Room, error) {
 	var items []Room
 	for rows.Next() {
 		var i Room
-		if err := rows.Scan(&i.ID, &i.Theme); err != nil {
+		if err := rows.Scan(
+			&i.ID,
+			&i.Theme,
+			&i.Status,
+			&i.HostTokenHash,
+			&i.Version,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -93,6 +517,40 @@ func (q *Queries) GetRooms(ctx context.Context) ([]Room, error) {
 	return items, nil
 }
 
+const getWebhook = `-- name: GetWebhook :one
+SELECT "id", "room_id", "url", "secret", "kinds", "created_at" FROM webhooks WHERE id = $1 AND room_id = $2
+`
+
+type GetWebhookParams struct {
+	ID     uuid.UUID
+	RoomID uuid.UUID
+}
+
+func (q *Queries) GetWebhook(ctx context.Context, arg GetWebhookParams) (Webhook, error) {
+	row := q.db.QueryRow(ctx, getWebhook, arg.ID, arg.RoomID)
+	var i Webhook
+	err := row.Scan(
+		&i.ID,
+		&i.RoomID,
+		&i.Url,
+		&i.Secret,
+		&i.Kinds,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const initOutboxCursor = `-- name: InitOutboxCursor :exec
+INSERT INTO outbox_cursors (consumer, tx_id, event_id)
+VALUES ($1, pg_snapshot_xmin(pg_current_snapshot())::text::bigint, 0)
+ON CONFLICT (consumer) DO NOTHING
+`
+
+func (q *Queries) InitOutboxCursor(ctx context.Context, consumer string) error {
+	_, err := q.db.Exec(ctx, initOutboxCursor, consumer)
+	return err
+}
+
 const insertMessage = `-- name: InsertMessage :one
 INSERT INTO messages (room_id, message) VALUES ($1, $2) RETURNING "id"
 `
@@ -109,6 +567,27 @@ func (q *Queries) InsertMessage(ctx context.Context, arg InsertMessageParams) (u
 	return id, err
 }
 
+const insertOutboxEvent = `-- name: InsertOutboxEvent :exec
+INSERT INTO outbox (room_id, kind, payload, traceparent) VALUES ($1, $2, $3, $4)
+`
+
+type InsertOutboxEventParams struct {
+	RoomID      uuid.UUID
+	Kind        string
+	Payload     []byte
+	Traceparent pgtype.Text
+}
+
+func (q *Queries) InsertOutboxEvent(ctx context.Context, arg InsertOutboxEventParams) error {
+	_, err := q.db.Exec(ctx, insertOutboxEvent,
+		arg.RoomID,
+		arg.Kind,
+		arg.Payload,
+		arg.Traceparent,
+	)
+	return err
+}
+
 const insertRoom = `-- name: InsertRoom :one
 INSERT INTO rooms (theme) VALUES ($1) RETURNING "id"
 `
@@ -120,33 +599,501 @@ func (q *Queries) InsertRoom(ctx context.Context, theme string) (uuid.UUID, erro
 	return id, err
 }
 
-const markMessageAsAnswered = `-- name: MarkMessageAsAnswered :exec
-UPDATE messages SET answered = true WHERE id = $1
+const insertWebhook = `-- name: InsertWebhook :one
+INSERT INTO webhooks (room_id, url, secret, kinds) VALUES ($1, $2, $3, $4)
+RETURNING "id", "room_id", "url", "secret", "kinds", "created_at"
 `
 
-func (q *Queries) MarkMessageAsAnswered(ctx context.Context, id uuid.UUID) error {
-	_, err := q.db.Exec(ctx, markMessageAsAnswered, id)
-	return err
+type InsertWebhookParams struct {
+	RoomID uuid.UUID
+	Url    string
+	Secret string
+	Kinds  []string
+}
+
+func (q *Queries) InsertWebhook(ctx context.Context, arg InsertWebhookParams) (Webhook, error) {
+	row := q.db.QueryRow(ctx, insertWebhook,
+		arg.RoomID,
+		arg.Url,
+		arg.Secret,
+		arg.Kinds,
+	)
+	var i Webhook
+	err := row.Scan(
+		&i.ID,
+		&i.RoomID,
+		&i.Url,
+		&i.Secret,
+		&i.Kinds,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const listOutboxEvents = `-- name: ListOutboxEvents :many
+SELECT "id", "tx_id", "room_id", "kind", "payload", "created_at", "traceparent" FROM outbox
+WHERE (tx_id, id) > ($1::bigint, $2::bigint)
+  AND tx_id < pg_snapshot_xmin(pg_current_snapshot())::text::bigint
+ORDER BY tx_id, id
+LIMIT $3
+`
+
+type ListOutboxEventsParams struct {
+	TxID      int64
+	EventID   int64
+	MaxEvents int32
+}
+
+func (q *Queries) ListOutboxEvents(ctx context.Context, arg ListOutboxEventsParams) ([]Outbox, error) {
+	rows, err := q.db.Query(ctx, listOutboxEvents, arg.TxID, arg.EventID, arg.MaxEvents)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Outbox
+	for rows.Next() {
+		var i Outbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.TxID,
+			&i.RoomID,
+			&i.Kind,
+			&i.Payload,
+			&i.CreatedAt,
+			&i.Traceparent,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRoomPresence = `-- name: ListRoomPresence :many
+SELECT room_id, COUNT(*) AS connections, COUNT(DISTINCT instance_id) AS instances
+FROM room_participants GROUP BY room_id
+`
+
+type ListRoomPresenceRow struct {
+	RoomID      uuid.UUID
+	Connections int64
+	Instances   int64
+}
+
+func (q *Queries) ListRoomPresence(ctx context.Context) ([]ListRoomPresenceRow, error) {
+	rows, err := q.db.Query(ctx, listRoomPresence)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRoomPresenceRow
+	for rows.Next() {
+		var i ListRoomPresenceRow
+		if err := rows.Scan(&i.RoomID, &i.Connections, &i.Instances); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRoomWebhooks = `-- name: ListRoomWebhooks :many
+SELECT "id", "room_id", "url", "secret", "kinds", "created_at" FROM webhooks WHERE room_id = $1 ORDER BY created_at
+`
+
+func (q *Queries) ListRoomWebhooks(ctx context.Context, roomID uuid.UUID) ([]Webhook, error) {
+	rows, err := q.db.Query(ctx, listRoomWebhooks, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Webhook
+	for rows.Next() {
+		var i Webhook
+		if err := rows.Scan(
+			&i.ID,
+			&i.RoomID,
+			&i.Url,
+			&i.Secret,
+			&i.Kinds,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRoomsWithStats = `-- name: ListRoomsWithStats :many
+SELECT r.id, r.theme, r.status, COUNT(m.id) AS messages
+FROM rooms r LEFT JOIN messages m ON m.room_id = r.id
+GROUP BY r.id ORDER BY r.theme
+`
+
+type ListRoomsWithStatsRow struct {
+	ID       uuid.UUID
+	Theme    string
+	Status   string
+	Messages int64
+}
+
+func (q *Queries) ListRoomsWithStats(ctx context.Context) ([]ListRoomsWithStatsRow, error) {
+	rows, err := q.db.Query(ctx, listRoomsWithStats)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRoomsWithStatsRow
+	for rows.Next() {
+		var i ListRoomsWithStatsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Theme,
+			&i.Status,
+			&i.Messages,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listWebhookDeliveries = `-- name: ListWebhookDeliveries :many
+SELECT "id", "webhook_id", "event_id", "kind", "payload", "status", "attempts", "next_attempt_at", "last_status_code", "last_error", "redelivery_of", "created_at", "updated_at"
+FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC LIMIT $2
+`
+
+type ListWebhookDeliveriesParams struct {
+	WebhookID uuid.UUID
+	Limit     int32
+}
+
+func (q *Queries) ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]WebhookDelivery, error) {
+	rows, err := q.db.Query(ctx, listWebhookDeliveries, arg.WebhookID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookDelivery
+	for rows.Next() {
+		var i WebhookDelivery
+		if err := rows.Scan(
+			&i.ID,
+			&i.WebhookID,
+			&i.EventID,
+			&i.Kind,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastStatusCode,
+			&i.LastError,
+			&i.RedeliveryOf,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockOutboxCursor = `-- name: LockOutboxCursor :one
+SELECT "consumer", "tx_id", "event_id", "updated_at" FROM outbox_cursors WHERE consumer = $1 FOR UPDATE SKIP LOCKED
+`
+
+func (q *Queries) LockOutboxCursor(ctx context.Context, consumer string) (OutboxCursor, error) {
+	row := q.db.QueryRow(ctx, lockOutboxCursor, consumer)
+	var i OutboxCursor
+	err := row.Scan(
+		&i.Consumer,
+		&i.TxID,
+		&i.EventID,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const markMessageAsAnswered = `-- name: MarkMessageAsAnswered :one
+UPDATE messages SET answered = true, version = version + 1
+WHERE id = $1 AND ($2::bigint[] IS NULL OR version = ANY($2::bigint[]))
+RETURNING version
+`
+
+type MarkMessageAsAnsweredParams struct {
+	ID       uuid.UUID
+	Versions []int64
+}
+
+func (q *Queries) MarkMessageAsAnswered(ctx context.Context, arg MarkMessageAsAnsweredParams) (int64, error) {
+	row := q.db.QueryRow(ctx, markMessageAsAnswered, arg.ID, arg.Versions)
+	var version int64
+	err := row.Scan(&version)
+	return version, err
 }
 
 const reactToMessage = `-- name: ReactToMessage :one
-UPDATE messages SET reactions_count = reactions_count + 1 WHERE id = $1 RETURNING reactions_count
+UPDATE messages SET reactions_count = reactions_count + 1
+WHERE id = $1 AND ($2::bigint[] IS NULL OR version = ANY($2::bigint[]))
+RETURNING reactions_count
 `
 
-func (q *Queries) ReactToMessage(ctx context.Context, id uuid.UUID) (int64, error) {
-	row := q.db.QueryRow(ctx, reactToMessage, id)
+type ReactToMessageParams struct {
+	ID       uuid.UUID
+	Versions []int64
+}
+
+func (q *Queries) ReactToMessage(ctx context.Context, arg ReactToMessageParams) (int64, error) {
+	row := q.db.QueryRow(ctx, reactToMessage, arg.ID, arg.Versions)
 	var reactions_count int64
 	err := row.Scan(&reactions_count)
 	return reactions_count, err
 }
 
+const recordWebhookAttempt = `-- name: RecordWebhookAttempt :exec
+UPDATE webhook_deliveries SET
+  status = $2, last_status_code = $3, last_error = $4,
+  next_attempt_at = now() + make_interval(secs => $5::float8),
+  updated_at = now()
+WHERE id = $1
+`
+
+type RecordWebhookAttemptParams struct {
+	ID             uuid.UUID
+	Status         string
+	LastStatusCode pgtype.Int4
+	LastError      string
+	RetryInSeconds float64
+}
+
+func (q *Queries) RecordWebhookAttempt(ctx context.Context, arg RecordWebhookAttemptParams) error {
+	_, err := q.db.Exec(ctx, recordWebhookAttempt,
+		arg.ID,
+		arg.Status,
+		arg.LastStatusCode,
+		arg.LastError,
+		arg.RetryInSeconds,
+	)
+	return err
+}
+
+const redeliverWebhookDelivery = `-- name: RedeliverWebhookDelivery :one
+INSERT INTO webhook_deliveries (webhook_id, event_id, kind, payload, redelivery_of)
+SELECT webhook_id, event_id, kind, payload, id FROM webhook_deliveries WHERE id = $1 AND webhook_id = $2
+RETURNING "id", "webhook_id", "event_id", "kind", "payload", "status", "attempts", "next_attempt_at", "last_status_code", "last_error", "redelivery_of", "created_at", "updated_at"
+`
+
+type RedeliverWebhookDeliveryParams struct {
+	ID        uuid.UUID
+	WebhookID uuid.UUID
+}
+
+func (q *Queries) RedeliverWebhookDelivery(ctx context.Context, arg RedeliverWebhookDeliveryParams) (WebhookDelivery, error) {
+	row := q.db.QueryRow(ctx, redeliverWebhookDelivery, arg.ID, arg.WebhookID)
+	var i WebhookDelivery
+	err := row.Scan(
+		&i.ID,
+		&i.WebhookID,
+		&i.EventID,
+		&i.Kind,
+		&i.Payload,
+		&i.Status,
+		&i.Attempts,
+		&i.NextAttemptAt,
+		&i.LastStatusCode,
+		&i.LastError,
+		&i.RedeliveryOf,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
 const removeReactionFromMessage = `-- name: RemoveReactionFromMessage :one
-UPDATE messages SET reactions_count = reactions_count - 1 WHERE id = $1 RETURNING reactions_count
+UPDATE messages SET reactions_count = reactions_count - 1
+WHERE id = $1 AND ($2::bigint[] IS NULL OR version = ANY($2::bigint[]))
+RETURNING reactions_count
 `
 
-func (q *Queries) RemoveReactionFromMessage(ctx context.Context, id uuid.UUID) (int64, error) {
-	row := q.db.QueryRow(ctx, removeReactionFromMessage, id)
+type RemoveReactionFromMessageParams struct {
+	ID       uuid.UUID
+	Versions []int64
+}
+
+func (q *Queries) RemoveReactionFromMessage(ctx context.Context, arg RemoveReactionFromMessageParams) (int64, error) {
+	row := q.db.QueryRow(ctx, removeReactionFromMessage, arg.ID, arg.Versions)
 	var reactions_count int64
 	err := row.Scan(&reactions_count)
 	return reactions_count, err
 }
+
+const resetMessageReactions = `-- name: ResetMessageReactions :one
+UPDATE messages SET reactions_count = 0 WHERE id = $1 RETURNING room_id
+`
+
+func (q *Queries) ResetMessageReactions(ctx context.Context, id uuid.UUID) (uuid.UUID, error) {
+	row := q.db.QueryRow(ctx, resetMessageReactions, id)
+	var room_id uuid.UUID
+	err := row.Scan(&room_id)
+	return room_id, err
+}
+
+const resetRoomReactions = `-- name: ResetRoomReactions :many
+UPDATE messages SET reactions_count = 0 WHERE room_id = $1 RETURNING id
+`
+
+func (q *Queries) ResetRoomReactions(ctx context.Context, roomID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.Query(ctx, resetRoomReactions, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setMessageHidden = `-- name: SetMessageHidden :one
+UPDATE messages SET hidden = $2, version = version + 1 WHERE id = $1 RETURNING room_id
+`
+
+type SetMessageHiddenParams struct {
+	ID     uuid.UUID
+	Hidden bool
+}
+
+func (q *Queries) SetMessageHidden(ctx context.Context, arg SetMessageHiddenParams) (uuid.UUID, error) {
+	row := q.db.QueryRow(ctx, setMessageHidden, arg.ID, arg.Hidden)
+	var room_id uuid.UUID
+	err := row.Scan(&room_id)
+	return room_id, err
+}
+
+const setRoomHostToken = `-- name: SetRoomHostToken :execrows
+UPDATE rooms SET host_token_hash = $2 WHERE id = $1
+`
+
+type SetRoomHostTokenParams struct {
+	ID            uuid.UUID
+	HostTokenHash []byte
+}
+
+func (q *Queries) SetRoomHostToken(ctx context.Context, arg SetRoomHostTokenParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setRoomHostToken, arg.ID, arg.HostTokenHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setRoomStatus = `-- name: SetRoomStatus :execrows
+UPDATE rooms SET status = $2, version = version + 1 WHERE id = $1
+`
+
+type SetRoomStatusParams struct {
+	ID     uuid.UUID
+	Status string
+}
+
+func (q *Queries) SetRoomStatus(ctx context.Context, arg SetRoomStatusParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setRoomStatus, arg.ID, arg.Status)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateRoom = `-- name: UpdateRoom :one
+UPDATE rooms SET
+  theme = COALESCE($1, theme),
+  status = COALESCE($2, status),
+  version = version + 1
+WHERE id = $3 AND ($4::bigint[] IS NULL OR version = ANY($4::bigint[]))
+RETURNING "id", "theme", "status", "host_token_hash", "version"
+`
+
+type UpdateRoomParams struct {
+	Theme    pgtype.Text
+	Status   pgtype.Text
+	ID       uuid.UUID
+	Versions []int64
+}
+
+func (q *Queries) UpdateRoom(ctx context.Context, arg UpdateRoomParams) (Room, error) {
+	row := q.db.QueryRow(ctx, updateRoom,
+		arg.Theme,
+		arg.Status,
+		arg.ID,
+		arg.Versions,
+	)
+	var i Room
+	err := row.Scan(
+		&i.ID,
+		&i.Theme,
+		&i.Status,
+		&i.HostTokenHash,
+		&i.Version,
+	)
+	return i, err
+}
+
+const upsertInstanceParticipants = `-- name: UpsertInstanceParticipants :exec
+INSERT INTO room_participants (room_id, participant_id, instance_id, last_seen)
+SELECT p.room_id, p.participant_id, $1, now()
+FROM unnest($2::uuid[], $3::text[]) AS p(room_id, participant_id)
+ON CONFLICT (room_id, participant_id, instance_id) DO UPDATE SET last_seen = now()
+`
+
+type UpsertInstanceParticipantsParams struct {
+	InstanceID     string
+	RoomIds        []uuid.UUID
+	ParticipantIds []string
+}
+
+func (q *Queries) UpsertInstanceParticipants(ctx context.Context, arg UpsertInstanceParticipantsParams) error {
+	_, err := q.db.Exec(ctx, upsertInstanceParticipants, arg.InstanceID, arg.RoomIds, arg.ParticipantIds)
+	return err
+}
+
+const upsertRoomParticipant = `-- name: UpsertRoomParticipant :exec
+INSERT INTO room_participants (room_id, participant_id, instance_id, last_seen) VALUES ($1, $2, $3, now())
+ON CONFLICT (room_id, participant_id, instance_id) DO UPDATE SET last_seen = now()
+`
+
+type UpsertRoomParticipantParams struct {
+	RoomID        uuid.UUID
+	ParticipantID string
+	InstanceID    string
+}
+
+func (q *Queries) UpsertRoomParticipant(ctx context.Context, arg UpsertRoomParticipantParams) error {
+	_, err := q.db.Exec(ctx, upsertRoomParticipant, arg.RoomID, arg.ParticipantID, arg.InstanceID)
+	return err
+}
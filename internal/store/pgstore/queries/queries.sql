@@ -1,26 +1,205 @@
 -- name: GetRoom :one
-SELECT "id", "theme" FROM rooms WHERE id = $1;
+SELECT "id", "theme", "status", "host_token_hash", "version" FROM rooms WHERE id = $1;
 
 -- name: GetRooms :many
-SELECT "id", "theme" FROM rooms;
+SELECT "id", "theme", "status", "host_token_hash", "version" FROM rooms;
 
 -- name: InsertRoom :one
 INSERT INTO rooms (theme) VALUES ($1) RETURNING "id";
 
 -- name: GetMessage :one
-SELECT "id", "room_id", "message", "reactions_count", "answered" FROM messages WHERE id = $1;
+SELECT "id", "room_id", "message", "reactions_count", "answered", "hidden", "version" FROM messages WHERE id = $1;
+
+-- name: GetMessageForUpdate :one
+SELECT "id", "room_id", "message", "reactions_count", "answered", "hidden", "version" FROM messages WHERE id = $1 FOR UPDATE;
 
 -- name: GetRoomMessages :many
-SELECT "id", "room_id", "message", "reactions_count", "answered" FROM messages WHERE room_id = $1;
+SELECT "id", "room_id", "message", "reactions_count", "answered", "hidden", "version" FROM messages WHERE room_id = $1;
 
 -- name: InsertMessage :one
 INSERT INTO messages (room_id, message) VALUES ($1, $2) RETURNING "id";
 
 -- name: ReactToMessage :one
-UPDATE messages SET reactions_count = reactions_count + 1 WHERE id = $1 RETURNING reactions_count;
+UPDATE messages SET reactions_count = reactions_count + 1
+WHERE id = $1 AND (sqlc.narg(versions)::bigint[] IS NULL OR version = ANY(sqlc.narg(versions)::bigint[]))
+RETURNING reactions_count;
 
 -- name: RemoveReactionFromMessage :one
-UPDATE messages SET reactions_count = reactions_count - 1 WHERE id = $1 RETURNING reactions_count;
+UPDATE messages SET reactions_count = reactions_count - 1
+WHERE id = $1 AND (sqlc.narg(versions)::bigint[] IS NULL OR version = ANY(sqlc.narg(versions)::bigint[]))
+RETURNING reactions_count;
+
+-- name: MarkMessageAsAnswered :one
+UPDATE messages SET answered = true, version = version + 1
+WHERE id = $1 AND (sqlc.narg(versions)::bigint[] IS NULL OR version = ANY(sqlc.narg(versions)::bigint[]))
+RETURNING version;
+
+-- name: UpsertRoomParticipant :exec
+INSERT INTO room_participants (room_id, participant_id, instance_id, last_seen) VALUES ($1, $2, $3, now())
+ON CONFLICT (room_id, participant_id, instance_id) DO UPDATE SET last_seen = now();
+
+-- name: DeleteRoomParticipant :exec
+DELETE FROM room_participants WHERE room_id = $1 AND participant_id = $2 AND instance_id = $3;
+
+-- name: DeleteInstanceParticipants :exec
+DELETE FROM room_participants WHERE instance_id = $1;
+
+-- name: UpsertInstanceParticipants :exec
+INSERT INTO room_participants (room_id, participant_id, instance_id, last_seen)
+SELECT p.room_id, p.participant_id, sqlc.arg(instance_id), now()
+FROM unnest(sqlc.arg(room_ids)::uuid[], sqlc.arg(participant_ids)::text[]) AS p(room_id, participant_id)
+ON CONFLICT (room_id, participant_id, instance_id) DO UPDATE SET last_seen = now();
+
+-- name: DeleteStaleRoomParticipants :exec
+DELETE FROM room_participants WHERE last_seen < now() - make_interval(secs => sqlc.arg(ttl_seconds)::float8);
+
+-- name: CountRoomParticipants :one
+SELECT COUNT(*) FROM room_participants WHERE room_id = $1;
+
+-- name: CountParticipantsByRoom :many
+SELECT room_id, COUNT(*) FROM room_participants WHERE room_id = ANY(sqlc.arg(room_ids)::uuid[]) GROUP BY room_id;
+
+-- name: ApplyReactionDeltas :many
+UPDATE messages SET reactions_count = messages.reactions_count + d.delta
+FROM unnest(sqlc.arg(ids)::uuid[], sqlc.arg(deltas)::bigint[]) AS d(id, delta)
+WHERE messages.id = d.id
+RETURNING messages.id, messages.room_id, messages.reactions_count;
+
+-- name: ListRoomsWithStats :many
+SELECT r.id, r.theme, r.status, COUNT(m.id) AS messages
+FROM rooms r LEFT JOIN messages m ON m.room_id = r.id
+GROUP BY r.id ORDER BY r.theme;
+
+-- name: ListRoomPresence :many
+SELECT room_id, COUNT(*) AS connections, COUNT(DISTINCT instance_id) AS instances
+FROM room_participants GROUP BY room_id;
+
+-- name: SetRoomStatus :execrows
+UPDATE rooms SET status = $2, version = version + 1 WHERE id = $1;
+
+-- name: SetRoomHostToken :execrows
+UPDATE rooms SET host_token_hash = $2 WHERE id = $1;
+
+-- name: SetMessageHidden :one
+UPDATE messages SET hidden = $2, version = version + 1 WHERE id = $1 RETURNING room_id;
+
+-- name: DeleteMessage :one
+DELETE FROM messages WHERE id = $1 RETURNING room_id;
+
+-- name: ResetMessageReactions :one
+UPDATE messages SET reactions_count = 0 WHERE id = $1 RETURNING room_id;
+
+-- name: ResetRoomReactions :many
+UPDATE messages SET reactions_count = 0 WHERE room_id = $1 RETURNING id;
+
+-- name: ClaimIdempotencyKey :execrows
+INSERT INTO idempotency_keys (scope, key, request_hash, expires_at)
+VALUES ($1, $2, $3, now() + make_interval(secs => sqlc.arg(ttl_seconds)::float8))
+ON CONFLICT (scope, key) DO UPDATE SET
+  request_hash = EXCLUDED.request_hash, status_code = NULL, content_type = '', response_body = NULL,
+  response_headers = NULL, created_at = now(), expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at < now();
+
+-- name: GetIdempotencyKey :one
+SELECT "scope", "key", "request_hash", "status_code", "content_type", "response_body", "created_at", "expires_at", "response_headers"
+FROM idempotency_keys WHERE scope = $1 AND key = $2;
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys SET status_code = $3, content_type = $4, response_body = $5, response_headers = $6 WHERE scope = $1 AND key = $2;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys WHERE expires_at < now();
+
+-- name: UpdateRoom :one
+UPDATE rooms SET
+  theme = COALESCE(sqlc.narg(theme), theme),
+  status = COALESCE(sqlc.narg(status), status),
+  version = version + 1
+WHERE id = sqlc.arg(id) AND (sqlc.narg(versions)::bigint[] IS NULL OR version = ANY(sqlc.narg(versions)::bigint[]))
+RETURNING "id", "theme", "status", "host_token_hash", "version";
+
+-- name: InsertOutboxEvent :exec
+INSERT INTO outbox (room_id, kind, payload, traceparent) VALUES ($1, $2, $3, $4);
+
+-- name: InitOutboxCursor :exec
+INSERT INTO outbox_cursors (consumer, tx_id, event_id)
+VALUES ($1, pg_snapshot_xmin(pg_current_snapshot())::text::bigint, 0)
+ON CONFLICT (consumer) DO NOTHING;
+
+-- name: LockOutboxCursor :one
+SELECT "consumer", "tx_id", "event_id", "updated_at" FROM outbox_cursors WHERE consumer = $1 FOR UPDATE SKIP LOCKED;
+
+-- name: ListOutboxEvents :many
+SELECT "id", "tx_id", "room_id", "kind", "payload", "created_at", "traceparent" FROM outbox
+WHERE (tx_id, id) > (sqlc.arg(tx_id)::bigint, sqlc.arg(event_id)::bigint)
+  AND tx_id < pg_snapshot_xmin(pg_current_snapshot())::text::bigint
+ORDER BY tx_id, id
+LIMIT sqlc.arg(max_events);
+
+-- name: AdvanceOutboxCursor :exec
+UPDATE outbox_cursors SET tx_id = $2, event_id = $3, updated_at = now() WHERE consumer = $1;
+
+-- name: DeleteStaleOutboxCursors :execrows
+DELETE FROM outbox_cursors WHERE updated_at < now() - make_interval(secs => sqlc.arg(ttl_seconds)::float8);
+
+-- name: DeleteProcessedOutboxEvents :execrows
+DELETE FROM outbox
+WHERE (tx_id, id) <= (SELECT tx_id, event_id FROM outbox_cursors ORDER BY tx_id, event_id LIMIT 1);
+
+-- name: InsertWebhook :one
+INSERT INTO webhooks (room_id, url, secret, kinds) VALUES ($1, $2, $3, $4)
+RETURNING "id", "room_id", "url", "secret", "kinds", "created_at";
+
+-- name: ListRoomWebhooks :many
+SELECT "id", "room_id", "url", "secret", "kinds", "created_at" FROM webhooks WHERE room_id = $1 ORDER BY created_at;
+
+-- name: GetWebhook :one
+SELECT "id", "room_id", "url", "secret", "kinds", "created_at" FROM webhooks WHERE id = $1 AND room_id = $2;
+
+-- name: DeleteWebhook :execrows
+DELETE FROM webhooks WHERE id = $1 AND room_id = $2;
+
+-- name: EnqueueWebhookDeliveries :execrows
+INSERT INTO webhook_deliveries (webhook_id, event_id, kind, payload)
+SELECT id, sqlc.arg(event_id), sqlc.arg(kind)::text, sqlc.arg(payload) FROM webhooks
+WHERE room_id = sqlc.arg(room_id) AND sqlc.arg(kind)::text = ANY(kinds)
+ON CONFLICT (webhook_id, event_id) WHERE redelivery_of IS NULL DO NOTHING;
+
+-- name: ClaimWebhookDeliveries :many
+WITH due AS (
+  SELECT id FROM webhook_deliveries
+  WHERE status = 'pending' AND next_attempt_at <= now()
+  ORDER BY next_attempt_at
+  LIMIT sqlc.arg(max_deliveries)
+  FOR UPDATE SKIP LOCKED
+)
+UPDATE webhook_deliveries d SET
+  attempts = d.attempts + 1,
+  next_attempt_at = now() + make_interval(secs => sqlc.arg(lease_seconds)::float8),
+  updated_at = now()
+FROM due, webhooks w
+WHERE d.id = due.id AND w.id = d.webhook_id
+RETURNING d.id, d.webhook_id, d.event_id, d.kind, d.payload, d.attempts, w.url, w.secret;
+
+-- name: RecordWebhookAttempt :exec
+UPDATE webhook_deliveries SET
+  status = $2, last_status_code = $3, last_error = $4,
+  next_attempt_at = now() + make_interval(secs => sqlc.arg(retry_in_seconds)::float8),
+  updated_at = now()
+WHERE id = $1;
+
+-- name: ListWebhookDeliveries :many
+SELECT "id", "webhook_id", "event_id", "kind", "payload", "status", "attempts", "next_attempt_at", "last_status_code", "last_error", "redelivery_of", "created_at", "updated_at"
+FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC LIMIT $2;
+
+-- name: RedeliverWebhookDelivery :one
+INSERT INTO webhook_deliveries (webhook_id, event_id, kind, payload, redelivery_of)
+SELECT webhook_id, event_id, kind, payload, id FROM webhook_deliveries WHERE id = $1 AND webhook_id = $2
+RETURNING "id", "webhook_id", "event_id", "kind", "payload", "status", "attempts", "next_attempt_at", "last_status_code", "last_error", "redelivery_of", "created_at", "updated_at";
 
--- name: MarkMessageAsAnswered :exec
-UPDATE messages SET answered = true WHERE id = $1;
\ No newline at end of file
+-- name: DeleteOldWebhookDeliveries :execrows
+DELETE FROM webhook_deliveries
+WHERE status <> 'pending' AND updated_at < now() - make_interval(secs => sqlc.arg(retention_seconds)::float8);
@@ -0,0 +1,14 @@
+package pgstore
+
+import "context"
+
+const schemaVersion = `SELECT version FROM schema_version`
+
+// SchemaVersion returns the version recorded by the migrator in the
+// schema_version table.
+func (q *Queries) SchemaVersion(ctx context.Context) (int32, error) {
+	row := q.db.QueryRow(ctx, schemaVersion)
+	var version int32
+	err := row.Scan(&version)
+	return version, err
+}
@@ -0,0 +1,55 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type linksKey struct{}
+
+// WithLinks returns a context carrying span contexts that work started from
+// it should link to, for work triggered by several spans at once.
+func WithLinks(ctx context.Context, spans ...trace.SpanContext) context.Context {
+	return context.WithValue(ctx, linksKey{}, spans)
+}
+
+// Links returns links to the span in ctx and to the span contexts added with
+// WithLinks.
+func Links(ctx context.Context) []trace.Link {
+	var links []trace.Link
+	if link := trace.LinkFromContext(ctx); link.SpanContext.IsValid() {
+		links = append(links, link)
+	}
+
+	spans, _ := ctx.Value(linksKey{}).([]trace.SpanContext)
+	for _, sc := range spans {
+		if sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+
+	return links
+}
+
+// traceContext formats span contexts as W3C traceparent headers, whatever
+// propagators Setup installed.
+var traceContext = propagation.TraceContext{}
+
+// Traceparent returns the W3C traceparent of the span in ctx, to store along
+// with work picked up later, or "" without a valid span.
+func Traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+
+	return carrier.Get("traceparent")
+}
+
+// ParseTraceparent returns the span context of a traceparent written by
+// Traceparent. It is invalid if traceparent is empty or malformed.
+func ParseTraceparent(traceparent string) trace.SpanContext {
+	ctx := traceContext.Extract(context.Background(), propagation.MapCarrier{"traceparent": traceparent})
+
+	return trace.SpanContextFromContext(ctx)
+}
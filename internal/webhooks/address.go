@@ -0,0 +1,125 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for webhook endpoints on loopback,
+// link-local, private or unspecified addresses, which would let a room host
+// reach services that are not public. Networks can be allowed explicitly
+// for internal tools.
+var ErrForbiddenAddress = errors.New("webhook address is not allowed")
+
+// ParseNetworks parses CIDR prefixes such as "10.0.0.0/8". A bare address
+// allows only itself.
+func ParseNetworks(networks []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(networks))
+	for _, network := range networks {
+		network = strings.TrimSpace(network)
+		if network == "" {
+			continue
+		}
+
+		if addr, err := netip.ParseAddr(network); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, fmt.Errorf("network %q: %w", network, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// MustParseNetworks is like ParseNetworks but panics if a network is
+// invalid.
+func MustParseNetworks(networks ...string) []netip.Prefix {
+	prefixes, err := ParseNetworks(networks)
+	if err != nil {
+		panic(err)
+	}
+
+	return prefixes
+}
+
+// CheckHost returns ErrForbiddenAddress when host is localhost or a
+// forbidden IP address outside of allowed. Other names are checked once
+// resolved, when a delivery is sent.
+func CheckHost(host string, allowed []netip.Prefix) error {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrForbiddenAddress
+	}
+
+	addr, err := netip.ParseAddr(strings.Trim(host, "[]"))
+	if err != nil {
+		return nil
+	}
+
+	return checkAddr(addr, allowed)
+}
+
+func checkAddr(addr netip.Addr, allowed []netip.Prefix) error {
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range allowed {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+
+	if addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsPrivate() || addr.IsUnspecified() {
+		return ErrForbiddenAddress
+	}
+
+	return nil
+}
+
+// newClient returns a client that doesn't follow redirects and refuses to
+// connect to forbidden addresses. The check runs on the address being
+// dialed, after name resolution, so a name can't be pointed at an internal
+// address once the webhook is registered.
+func newClient(allowed []netip.Prefix) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+
+			return checkAddr(addr, allowed)
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed instead of the endpoint.
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckHost(t *testing.T) {
+	allowed := MustParseNetworks("10.1.0.0/16", "fd00::1")
+
+	tests := []struct {
+		host      string
+		forbidden bool
+	}{
+		{"hooks.example.com", false},
+		{"203.0.113.7", false},
+		{"localhost", true},
+		{"api.localhost.", true},
+		{"127.0.0.1", true},
+		{"0.0.0.0", true},
+		{"169.254.169.254", true},
+		{"192.168.1.10", true},
+		{"10.2.0.1", true},
+		{"10.1.4.2", false},
+		{"::1", true},
+		{"[::ffff:127.0.0.1]", true},
+		{"fe80::1", true},
+		{"fd00::2", true},
+		{"fd00::1", false},
+	}
+
+	for _, tt := range tests {
+		err := CheckHost(tt.host, allowed)
+		if got := errors.Is(err, ErrForbiddenAddress); got != tt.forbidden {
+			t.Errorf("CheckHost(%q) = %v, want forbidden %t", tt.host, err, tt.forbidden)
+		}
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	if _, err := ParseNetworks([]string{"10.0.0.0/8", " 192.168.0.1 ", ""}); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseNetworks([]string{"internal.example.com"}); err == nil {
+		t.Error("ParseNetworks accepted a host name")
+	}
+}
+
+// TestClientChecksDialedAddress checks the address connected to rather than
+// the URL, as a name can resolve to a different address on every lookup.
+func TestClientChecksDialedAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := newClient(nil).Get(server.URL)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("Get(%s) error = %v, want ErrForbiddenAddress", server.URL, err)
+	}
+	if got := describe(err); got != ErrForbiddenAddress.Error() {
+		t.Errorf("describe() = %q, want %q", got, ErrForbiddenAddress)
+	}
+
+	resp, err := newClient(MustParseNetworks("127.0.0.0/8", "::1")).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,329 @@
+// Package webhooks POSTs room events to the endpoints hosts registered for
+// them. Deliveries are queued from the outbox, signed with the webhook's
+// secret and retried with exponential backoff. Every attempt is recorded in
+// webhook_deliveries.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/thiagoleet/go-ama-api/internal/buildinfo"
+	"github.com/thiagoleet/go-ama-api/internal/logging"
+	"github.com/thiagoleet/go-ama-api/internal/metrics"
+	"github.com/thiagoleet/go-ama-api/internal/store/pgstore"
+)
+
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256, keyed with
+	// the webhook secret, of the timestamp, a dot and the body.
+	SignatureHeader = "X-Wsrs-Signature"
+	// TimestampHeader is the Unix time the request was signed at. Receivers
+	// should refuse old timestamps to stop replays.
+	TimestampHeader = "X-Wsrs-Timestamp"
+	EventHeader     = "X-Wsrs-Event"
+	DeliveryHeader  = "X-Wsrs-Delivery"
+
+	// ConsumerName is the outbox cursor shared by every instance queueing
+	// deliveries.
+	ConsumerName = "webhooks"
+
+	DefaultTimeout      = 10 * time.Second
+	DefaultMaxAttempts  = 8
+	DefaultBackoff      = 10 * time.Second
+	DefaultMaxBackoff   = time.Hour
+	DefaultPollInterval = time.Second
+	DefaultRetention    = 7 * 24 * time.Hour
+
+	batchSize       = 20
+	cleanupInterval = 10 * time.Minute
+	// maxDrainBody is how much of a response is read so the connection can
+	// be reused. Responses are not kept: the log is shown to room hosts.
+	maxDrainBody = 4 << 10
+)
+
+// Sign returns the signature header value of body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Enqueue queues a delivery of each event to the webhooks of its room
+// subscribed to its kind. It is the handler of the ConsumerName outbox
+// consumer, so deliveries are queued in the transaction advancing its
+// cursor.
+func Enqueue(ctx context.Context, q *pgstore.Queries, events []entity.Message) error {
+	for _, msg := range events {
+		roomID, err := uuid.Parse(msg.RoomId)
+		if err != nil {
+			continue
+		}
+
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+
+		_, err = q.EnqueueWebhookDeliveries(ctx, pgstore.EnqueueWebhookDeliveriesParams{
+			EventID: msg.ID,
+			Kind:    msg.Kind,
+			Payload: payload,
+			RoomID:  roomID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type Options struct {
+	// Timeout bounds each request, including reading the response.
+	Timeout time.Duration
+	// MaxAttempts is how many times a delivery is tried before it is marked
+	// failed. It can still be redelivered by hand.
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It doubles on every
+	// retry, up to MaxBackoff.
+	Backoff      time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration
+	// Retention is how long finished deliveries are kept in the log.
+	Retention time.Duration
+	// AllowedNetworks are private networks deliveries may be sent to, for
+	// internal tools. Loopback, link-local, private and unspecified
+	// addresses are refused otherwise.
+	AllowedNetworks []netip.Prefix
+	// Client sends the requests. It defaults to a client that doesn't follow
+	// redirects and refuses the addresses above, checked as they are
+	// dialed. A custom client must do its own checks.
+	Client *http.Client
+}
+
+// Sender POSTs due deliveries. Senders of several instances share the
+// queue; each delivery is claimed by one of them.
+type Sender struct {
+	q    *pgstore.Queries
+	opts Options
+}
+
+func NewSender(q *pgstore.Queries, opts Options) *Sender {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultRetention
+	}
+	if opts.Client == nil {
+		opts.Client = newClient(opts.AllowedNetworks)
+	}
+
+	return &Sender{q: q, opts: opts}
+}
+
+// Run sends due deliveries and deletes old ones until ctx is done.
+func (s *Sender) Run(ctx context.Context) {
+	poll := time.NewTicker(s.opts.PollInterval)
+	defer poll.Stop()
+
+	cleanup := time.NewTicker(cleanupInterval)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			s.sendDue(ctx)
+		case <-cleanup.C:
+			deleted, err := s.q.DeleteOldWebhookDeliveries(ctx, s.opts.Retention.Seconds())
+			if err != nil {
+				logging.For("webhooks").Error("failed to delete old deliveries", "error", err)
+				continue
+			}
+			if deleted > 0 {
+				logging.For("webhooks").Debug("deleted old deliveries", "count", deleted)
+			}
+		}
+	}
+}
+
+func (s *Sender) sendDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		// A claimed delivery is not due again until its lease ends, so one
+		// whose sender died is retried.
+		deliveries, err := s.q.ClaimWebhookDeliveries(ctx, pgstore.ClaimWebhookDeliveriesParams{
+			MaxDeliveries: batchSize,
+			LeaseSeconds:  (2 * s.opts.Timeout).Seconds(),
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				logging.For("webhooks").Error("failed to claim deliveries", "error", err)
+			}
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, d := range deliveries {
+			wg.Add(1)
+			go func(d pgstore.ClaimWebhookDeliveriesRow) {
+				defer wg.Done()
+				s.send(ctx, d)
+			}(d)
+		}
+		wg.Wait()
+
+		if len(deliveries) < batchSize {
+			return
+		}
+	}
+}
+
+func (s *Sender) send(ctx context.Context, d pgstore.ClaimWebhookDeliveriesRow) {
+	status, err := s.post(ctx, d)
+	if ctx.Err() != nil {
+		// Shutting down: the lease runs out and another attempt is made.
+		return
+	}
+
+	params := pgstore.RecordWebhookAttemptParams{ID: d.ID}
+	if status != 0 {
+		params.LastStatusCode = pgtype.Int4{Int32: int32(status), Valid: true}
+	}
+
+	switch {
+	case err == nil:
+		params.Status = entity.WebhookDeliverySucceeded
+	case int(d.Attempts) >= s.opts.MaxAttempts:
+		params.Status = entity.WebhookDeliveryFailed
+		params.LastError = describe(err)
+	default:
+		params.Status = entity.WebhookDeliveryPending
+		params.LastError = describe(err)
+		params.RetryInSeconds = s.backoff(int(d.Attempts)).Seconds()
+	}
+
+	result := params.Status
+	if result == entity.WebhookDeliveryPending {
+		result = "retrying"
+	}
+	metrics.WebhookDeliveries.WithLabelValues(result).Inc()
+
+	logger := logging.For("webhooks").With("delivery_id", d.ID.String(), "webhook_id", d.WebhookID.String(), "attempt", d.Attempts)
+	if err != nil {
+		logger.Warn("webhook delivery failed", "status", status, "error", err, "result", result)
+	} else {
+		logger.Debug("webhook delivered", "status", status)
+	}
+
+	if err := s.q.RecordWebhookAttempt(ctx, params); err != nil {
+		logger.Error("failed to record delivery attempt", "error", err)
+	}
+}
+
+// post sends the delivery and returns the response status, or 0 when no
+// response was received.
+func (s *Sender) post(ctx context.Context, d pgstore.ClaimWebhookDeliveriesRow) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.Url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "wsrs-webhooks/"+buildinfo.Version)
+	req.Header.Set(EventHeader, d.Kind)
+	req.Header.Set(DeliveryHeader, d.ID.String())
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(d.Secret, timestamp, d.Payload))
+
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainBody))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, &statusError{code: resp.StatusCode}
+	}
+
+	return resp.StatusCode, nil
+}
+
+// describe summarises err for the delivery log, which room hosts can read.
+// Transport errors can name internal addresses, so only their kind is kept;
+// the full error is logged.
+func describe(err error) string {
+	var status *statusError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &status):
+		return status.Error()
+	case errors.Is(err, ErrForbiddenAddress):
+		return ErrForbiddenAddress.Error()
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "request timed out"
+	default:
+		return "request failed"
+	}
+}
+
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d", e.code)
+}
+
+// backoff returns the delay after the given failed attempt, with jitter so
+// deliveries to an endpoint that was down don't all retry at once.
+func (s *Sender) backoff(attempt int) time.Duration {
+	delay := s.opts.MaxBackoff
+	if attempt < 32 {
+		if d := s.opts.Backoff << (attempt - 1); d > 0 && d < delay {
+			delay = d
+		}
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,148 @@
+package wire
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of schema/events.proto.
+const (
+	fieldVersion   protowire.Number = 1
+	fieldID        protowire.Number = 2
+	fieldRoomID    protowire.Number = 3
+	fieldTimestamp protowire.Number = 4
+	fieldKind      protowire.Number = 5
+
+	fieldMessageCreated  protowire.Number = 10
+	fieldReactAdded      protowire.Number = 11
+	fieldReactRemoved    protowire.Number = 12
+	fieldAnswered        protowire.Number = 13
+	fieldPresenceChanged protowire.Number = 14
+	fieldReactionsBatch  protowire.Number = 15
+	fieldAck             protowire.Number = 16
+	fieldError           protowire.Number = 17
+	fieldMessageHidden   protowire.Number = 18
+	fieldMessageDeleted  protowire.Number = 19
+	fieldRawJSON         protowire.Number = 100
+)
+
+type protoCodec struct{}
+
+func (protoCodec) Subprotocol() string { return SubprotocolProto }
+func (protoCodec) MessageType() int    { return websocket.BinaryMessage }
+
+// Encode writes an ama.v1.Event. It is hand-written with protowire so the
+// server needs no generated code; keep it in sync with schema/events.proto.
+func (protoCodec) Encode(msg entity.Message) ([]byte, error) {
+	var b []byte
+	b = appendUint(b, fieldVersion, uint64(msg.Version))
+	b = appendString(b, fieldID, msg.ID)
+	b = appendString(b, fieldRoomID, msg.RoomId)
+	if !msg.Time.IsZero() {
+		b = appendUint(b, fieldTimestamp, uint64(msg.Time.UnixNano()))
+	}
+	b = appendString(b, fieldKind, msg.Kind)
+
+	switch v := msg.Value.(type) {
+	case entity.MessageMessageCreated:
+		b = appendMessage(b, fieldMessageCreated, func(b []byte) []byte {
+			b = appendString(b, 1, v.ID)
+			return appendString(b, 2, v.Message)
+		})
+	case entity.MessageMessageReactAdded:
+		b = appendMessage(b, fieldReactAdded, reactionCount(v.ID, v.Count))
+	case entity.MessageMessageReactRemoved:
+		b = appendMessage(b, fieldReactRemoved, reactionCount(v.ID, v.Count))
+	case entity.MessageMessageAnswered:
+		b = appendMessage(b, fieldAnswered, func(b []byte) []byte {
+			return appendString(b, 1, v.ID)
+		})
+	case entity.MessagePresenceChanged:
+		b = appendMessage(b, fieldPresenceChanged, func(b []byte) []byte {
+			return appendUint(b, 1, uint64(v.Count))
+		})
+	case entity.MessageReactionsBatch:
+		b = appendMessage(b, fieldReactionsBatch, func(b []byte) []byte {
+			for _, r := range v.Reactions {
+				b = appendMessage(b, 1, reactionCount(r.ID, r.Count))
+			}
+			return b
+		})
+	case entity.MessageMessageHidden:
+		b = appendMessage(b, fieldMessageHidden, func(b []byte) []byte {
+			b = appendString(b, 1, v.ID)
+			if v.Hidden {
+				b = appendUint(b, 2, 1)
+			}
+			return b
+		})
+	case entity.MessageMessageDeleted:
+		b = appendMessage(b, fieldMessageDeleted, func(b []byte) []byte {
+			return appendString(b, 1, v.ID)
+		})
+	case entity.MessageAck:
+		result, err := json.Marshal(v.Result)
+		if err != nil {
+			return nil, err
+		}
+		b = appendMessage(b, fieldAck, func(b []byte) []byte {
+			b = appendString(b, 1, v.ID)
+			if v.Result != nil {
+				b = protowire.AppendTag(b, 2, protowire.BytesType)
+				b = protowire.AppendBytes(b, result)
+			}
+			return b
+		})
+	case entity.MessageError:
+		b = appendMessage(b, fieldError, func(b []byte) []byte {
+			b = appendString(b, 1, v.ID)
+			b = appendString(b, 2, v.Code)
+			return appendString(b, 3, v.Message)
+		})
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, fieldRawJSON, protowire.BytesType)
+		b = protowire.AppendBytes(b, raw)
+	}
+
+	return b, nil
+}
+
+func reactionCount(id string, count int64) func([]byte) []byte {
+	return func(b []byte) []byte {
+		b = appendString(b, 1, id)
+		return appendUint(b, 2, uint64(count))
+	}
+}
+
+// appendString and appendUint omit zero values, as proto3 does.
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendUint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// appendMessage writes an embedded message. Fields of a oneof are written
+// even when empty, so the receiver knows which one is set.
+func appendMessage(b []byte, num protowire.Number, fields func([]byte) []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, fields(nil))
+}
@@ -0,0 +1,86 @@
+// Package wire encodes events for the WebSocket subprotocols a subscriber
+// can negotiate. Schemas for the binary encodings are in schema/.
+package wire
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	SubprotocolJSON    = "ama.json.v1"
+	SubprotocolMsgPack = "ama.msgpack.v1"
+	SubprotocolProto   = "ama.proto.v1"
+)
+
+// Subprotocols lists the supported subprotocols, most compact first, for
+// websocket.Upgrader.Subprotocols.
+var Subprotocols = []string{SubprotocolProto, SubprotocolMsgPack, SubprotocolJSON}
+
+// Codec encodes events into WebSocket frames.
+type Codec interface {
+	Subprotocol() string
+	// MessageType is websocket.TextMessage or websocket.BinaryMessage.
+	MessageType() int
+	Encode(msg entity.Message) ([]byte, error)
+}
+
+// ForSubprotocol returns the codec negotiated with a client. Clients that
+// asked for no subprotocol get JSON.
+func ForSubprotocol(name string) Codec {
+	switch name {
+	case SubprotocolMsgPack:
+		return MsgPack
+	case SubprotocolProto:
+		return Proto
+	default:
+		return JSON
+	}
+}
+
+var (
+	JSON    Codec = jsonCodec{}
+	MsgPack Codec = msgpackCodec{}
+	Proto   Codec = protoCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return SubprotocolJSON }
+func (jsonCodec) MessageType() int    { return websocket.TextMessage }
+
+func (jsonCodec) Encode(msg entity.Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return SubprotocolMsgPack }
+func (msgpackCodec) MessageType() int    { return websocket.BinaryMessage }
+
+// Encode writes the keys of the JSON encoding, reading the json struct tags
+// of the entity types.
+func (msgpackCodec) Encode(msg entity.Message) ([]byte, error) {
+	if raw, ok := msg.Value.(entity.RawPayload); ok {
+		var value any
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, err
+		}
+		msg.Value = value
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+
+	if err := enc.Encode(msg); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,239 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/thiagoleet/go-ama-api/internal/api/entity"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fields is a decoded protobuf message: the values of each field, as
+// uint64 for varints and []byte otherwise.
+type fields map[protowire.Number][]any
+
+func decodeProto(t *testing.T, b []byte) fields {
+	t.Helper()
+
+	f := fields{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				t.Fatalf("invalid varint: %v", protowire.ParseError(n))
+			}
+			f[num] = append(f[num], v)
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				t.Fatalf("invalid bytes: %v", protowire.ParseError(n))
+			}
+			f[num] = append(f[num], v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d for field %d", typ, num)
+		}
+	}
+
+	return f
+}
+
+// matchProto compares got with want, whose values are uint64, string for
+// bytes fields, or fields for embedded messages.
+func matchProto(t *testing.T, path string, got fields, want fields) {
+	t.Helper()
+
+	for num := range got {
+		if _, ok := want[num]; !ok {
+			t.Errorf("%s: unexpected field %d", path, num)
+		}
+	}
+
+	for num, values := range want {
+		if len(got[num]) != len(values) {
+			t.Errorf("%s.%d: %d values, want %d", path, num, len(got[num]), len(values))
+			continue
+		}
+
+		for i, w := range values {
+			g := got[num][i]
+			switch w := w.(type) {
+			case fields:
+				matchProto(t, fmt.Sprintf("%s.%d", path, num), decodeProto(t, g.([]byte)), w)
+			case string:
+				if b, ok := g.([]byte); !ok || string(b) != w {
+					t.Errorf("%s.%d = %v, want %q", path, num, g, w)
+				}
+			default:
+				if g != w {
+					t.Errorf("%s.%d = %v, want %v", path, num, g, w)
+				}
+			}
+		}
+	}
+}
+
+type codecTest struct {
+	kind  string
+	value any
+	// proto is the field of the payload in ama.v1.Event and its content.
+	field protowire.Number
+	proto []any
+}
+
+var codecTests = []codecTest{
+	{entity.MessageKindMessageCreated, entity.MessageMessageCreated{ID: "m1", Message: "When is the next release?"},
+		fieldMessageCreated, []any{fields{1: {"m1"}, 2: {"When is the next release?"}}}},
+	{entity.MessageKindMessageReactAdded, entity.MessageMessageReactAdded{ID: "m1", Count: 3},
+		fieldReactAdded, []any{fields{1: {"m1"}, 2: {uint64(3)}}}},
+	{entity.MessageKindMessageReactRemoved, entity.MessageMessageReactRemoved{ID: "m1", Count: 2},
+		fieldReactRemoved, []any{fields{1: {"m1"}, 2: {uint64(2)}}}},
+	{entity.MessageKindMessageAnswered, entity.MessageMessageAnswered{ID: "m1"},
+		fieldAnswered, []any{fields{1: {"m1"}}}},
+	{entity.MessageKindPresenceChanged, entity.MessagePresenceChanged{Count: 12},
+		fieldPresenceChanged, []any{fields{1: {uint64(12)}}}},
+	{entity.MessageKindReactionsBatch, entity.MessageReactionsBatch{Reactions: []entity.MessageReactionCount{{ID: "m1", Count: 3}, {ID: "m2"}}},
+		fieldReactionsBatch, []any{fields{1: {fields{1: {"m1"}, 2: {uint64(3)}}, fields{1: {"m2"}}}}}},
+	{entity.MessageKindMessageHidden, entity.MessageMessageHidden{ID: "m1", Hidden: true},
+		fieldMessageHidden, []any{fields{1: {"m1"}, 2: {uint64(1)}}}},
+	{entity.MessageKindMessageDeleted, entity.MessageMessageDeleted{ID: "m1"},
+		fieldMessageDeleted, []any{fields{1: {"m1"}}}},
+	{entity.MessageKindAck, entity.MessageAck{ID: "c1", Result: map[string]any{"id": "m3"}},
+		fieldAck, []any{fields{1: {"c1"}, 2: {`{"id":"m3"}`}}}},
+	{entity.MessageKindError, entity.MessageError{ID: "c1", Code: entity.ErrorCodeNotFound, Message: "message not found"},
+		fieldError, []any{fields{1: {"c1"}, 2: {entity.ErrorCodeNotFound}, 3: {"message not found"}}}},
+	{"poll_opened", entity.RawPayload(`{"id":"p1","options":["yes","no"]}`),
+		fieldRawJSON, []any{`{"id":"p1","options":["yes","no"]}`}},
+}
+
+var testTime = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+func (tt codecTest) message() entity.Message {
+	return entity.Message{
+		Version: entity.MessageSchemaVersion,
+		ID:      "e1",
+		RoomId:  "r1",
+		Time:    testTime,
+		Kind:    tt.kind,
+		Value:   tt.value,
+	}
+}
+
+func TestCodecTestsCoverEveryKind(t *testing.T) {
+	tested := map[string]bool{}
+	for _, tt := range codecTests {
+		tested[tt.kind] = true
+	}
+
+	for _, kind := range entity.Events.Kinds() {
+		if !tested[kind] {
+			t.Errorf("kind %s has no codec test", kind)
+		}
+	}
+}
+
+func TestProtoEncode(t *testing.T) {
+	for _, tt := range codecTests {
+		t.Run(tt.kind, func(t *testing.T) {
+			data, err := Proto.Encode(tt.message())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			matchProto(t, "Event", decodeProto(t, data), fields{
+				fieldVersion:   {uint64(entity.MessageSchemaVersion)},
+				fieldID:        {"e1"},
+				fieldRoomID:    {"r1"},
+				fieldTimestamp: {uint64(testTime.UnixNano())},
+				fieldKind:      {tt.kind},
+				tt.field:       tt.proto,
+			})
+		})
+	}
+}
+
+// TestMsgPackEncode checks that msgpack frames carry the same document as
+// JSON ones.
+func TestMsgPackEncode(t *testing.T) {
+	for _, tt := range codecTests {
+		t.Run(tt.kind, func(t *testing.T) {
+			msg := tt.message()
+
+			packed, err := MsgPack.Encode(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got map[string]any
+			if err := msgpack.Unmarshal(packed, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			// Timestamps use the msgpack extension type rather than a string.
+			if ts, ok := got["ts"].(time.Time); !ok || !ts.Equal(testTime) {
+				t.Errorf("ts = %v, want %v", got["ts"], testTime)
+			}
+			delete(got, "ts")
+
+			encoded, err := JSON.Encode(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var want map[string]any
+			if err := json.Unmarshal(encoded, &want); err != nil {
+				t.Fatal(err)
+			}
+			delete(want, "ts")
+
+			if normalized := normalize(t, got); !reflect.DeepEqual(normalized, want) {
+				t.Errorf("msgpack document\n got %v\nwant %v", normalized, want)
+			}
+		})
+	}
+}
+
+// normalize gives v the types encoding/json decodes to, e.g. float64 for
+// every number.
+func normalize(t *testing.T, v any) any {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out any
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	return out
+}
+
+func TestForSubprotocol(t *testing.T) {
+	for name, want := range map[string]Codec{
+		SubprotocolJSON:    JSON,
+		SubprotocolMsgPack: MsgPack,
+		SubprotocolProto:   Proto,
+		"":                 JSON,
+		"ama.xml.v1":       JSON,
+	} {
+		if got := ForSubprotocol(name); got != want {
+			t.Errorf("ForSubprotocol(%q) = %s, want %s", name, got.Subprotocol(), want.Subprotocol())
+		}
+	}
+}